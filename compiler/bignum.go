@@ -0,0 +1,941 @@
+// bignum.go contains the code for emitting instructions which operate
+// upon integers of arbitrary size, along with the runtime which
+// implements their arithmetic.
+
+package compiler
+
+import (
+	"strings"
+
+	"github.com/skx/math-compiler/instructions"
+)
+
+// genBignum generates the assembly code for the given instruction, which
+// is the i'th of our program, when working with integers of arbitrary
+// size.
+//
+// Each entry upon our stack is a pointer to a number, which is allocated
+// upon the heap.  Numbers are never changed once created, so they may be
+// shared by dup.
+func (c *Compiler) genBignum(opr instructions.Instruction, i int) string {
+
+	switch opr.Type {
+
+	case instructions.Abs:
+		return c.integerUnary("ABS", bignumCall("big_abs", 1))
+
+	case instructions.Divide:
+		return c.integerBinary("DIVIDE", bignumCall("big_divmod", 2))
+
+	case instructions.Dup:
+		return c.genDup()
+
+	case instructions.Factorial:
+		return c.integerUnary("FACTORIAL", bignumCall("big_factorial", 1))
+
+	case instructions.FlooredModulus:
+		return c.integerBinary("FLOORED MODULUS", bignumCall("big_floored_mod", 2))
+
+	case instructions.Input:
+		return c.genInput(opr.Value)
+
+	case instructions.Label:
+		return c.genLabel(opr.Value)
+
+	case instructions.Minus:
+		return c.integerBinary("MINUS", bignumCall("big_sub", 2))
+
+	case instructions.Modulus:
+		return c.integerBinary("MODULUS", bignumCall("big_divmod", 2)+`        mov rax, rdx
+`)
+
+	case instructions.Multiply:
+		return c.integerBinary("MULTIPLY", bignumCall("big_mul", 2))
+
+	case instructions.Plus:
+		return c.integerBinary("PLUS", bignumCall("big_add", 2))
+
+	case instructions.Power:
+		return c.integerBinary("POWER", bignumCall("big_pow", 2))
+
+	case instructions.Print:
+		return c.genPrint()
+
+	case instructions.PrintStack:
+		return c.genPrintStack()
+
+	case instructions.Push:
+		return c.genBignumPush(opr.Value)
+
+	case instructions.Swap:
+		return c.genSwap()
+
+	}
+	return ""
+}
+
+// bignumCall returns the assembly code which calls the named function of
+// our runtime, with the value in rax, and the value in rcx if it takes
+// two arguments.
+func bignumCall(name string, args int) string {
+	text := `        mov rdi, rax
+`
+	if args > 1 {
+		text += `        mov rsi, rcx
+`
+	}
+	return text + `        call ` + name + `
+`
+}
+
+// genBignumPush generates assembly code to push a number upon the stack,
+// which is converted from its decimal digits.
+func (c *Compiler) genBignumPush(value string) string {
+
+	text := `
+        # [PUSH]
+        # Convert the value #VALUE, and push it onto the stack
+        # Increase the value stored at [depth] to note we've a new stack-entry
+        lea rdi, #ESCAPED
+        lea rsi, b
+        call big_parse
+        mov qword ptr [a], rax
+        #PUSH a
+        inc qword ptr [depth]
+`
+	text = strings.Replace(text, "#VALUE", value, -1)
+	return strings.Replace(text, "#ESCAPED", c.escapeConstant(value), -1)
+}
+
+// genBignumData generates the data-area entries which are used by our
+// runtime.
+func (c *Compiler) genBignumData() string {
+	return `
+#
+# Integers of arbitrary size.
+#
+#  big_first: prints the sign, and the most-significant limb, of a number.
+#
+#   big_next: prints each of the remaining limbs.
+#
+  big_first: .asciz "%s%u"
+   big_next: .asciz "%09u"
+   big_plus: .asciz ""
+  big_minus: .asciz "-"
+ no_memory: .asciz "Out of memory.  Aborting\n"
+`
+}
+
+// genBignumHelpers generates the runtime which implements the arithmetic
+// of our integers.
+//
+// A number is a sign, which is 1 if it is negative, followed by the count
+// of its limbs, and then the limbs themselves.  Each limb is a 32-bit
+// value holding nine decimal digits, the least-significant first, which
+// makes it simple to convert numbers to, and from, text.
+//
+// Zero has no limbs, and the most-significant limb is never zero.
+func (c *Compiler) genBignumHelpers() string {
+	return `
+#
+# Allocate a number with room for rdi limbs, which it is marked as
+# having.  Every register, except rax, is preserved.
+#
+big_alloc:
+        push rbp
+        mov rbp, rsp
+        push rcx
+        push rdx
+        push rsi
+        push rdi
+        push r8
+        push r9
+        push r10
+        push r11
+        and rsp, -16
+        lea rdi, [rdi*4 + 16]
+        call malloc
+        test rax, rax
+        jz out_of_memory
+        mov rdi, qword ptr [rbp - 32]
+        mov qword ptr [rax], 0
+        mov qword ptr [rax + 8], rdi
+        lea rsp, [rbp - 64]
+        pop r11
+        pop r10
+        pop r9
+        pop r8
+        pop rdi
+        pop rsi
+        pop rdx
+        pop rcx
+        pop rbp
+        ret
+
+#
+# Free the number in rdi.  Every register is preserved.
+#
+big_free:
+        push rbp
+        mov rbp, rsp
+        push rax
+        push rcx
+        push rdx
+        push rsi
+        push rdi
+        push r8
+        push r9
+        push r10
+        push r11
+        and rsp, -16
+        call free
+        lea rsp, [rbp - 72]
+        pop r11
+        pop r10
+        pop r9
+        pop r8
+        pop rdi
+        pop rsi
+        pop rdx
+        pop rcx
+        pop rax
+        pop rbp
+        ret
+
+#
+# This is hit when a number can't be allocated.
+#
+out_of_memory:
+        lea rdi,no_memory
+        mov rdx, 7              # exit-code
+        jmp print_msg_and_exit
+
+#
+# Remove the leading zero limbs of the number in rdi, which is returned
+# in rax.  Zero is never negative.
+#
+big_trim:
+        mov rcx, qword ptr [rdi + 8]
+big_trim_next:
+        test rcx, rcx
+        jz big_trim_zero
+        cmp dword ptr [rdi + 12 + rcx*4], 0
+        jne big_trim_done
+        dec rcx
+        jmp big_trim_next
+big_trim_zero:
+        mov qword ptr [rdi], 0
+big_trim_done:
+        mov qword ptr [rdi + 8], rcx
+        mov rax, rdi
+        ret
+
+#
+# Return a copy of the number in rdi.
+#
+big_copy:
+        mov rsi, rdi
+        mov rdi, qword ptr [rsi + 8]
+        call big_alloc
+        mov rcx, qword ptr [rsi]
+        mov qword ptr [rax], rcx
+        mov rcx, qword ptr [rsi + 8]
+big_copy_next:
+        test rcx, rcx
+        jz big_copy_done
+        mov edx, dword ptr [rsi + 12 + rcx*4]
+        mov dword ptr [rax + 12 + rcx*4], edx
+        dec rcx
+        jmp big_copy_next
+big_copy_done:
+        ret
+
+#
+# Convert the integer in rdi into a number.
+#
+big_from_int:
+        push rbx
+        mov rbx, rdi
+        mov rdi, 3
+        call big_alloc
+        mov r8, rax
+        mov rax, rbx
+        test rax, rax
+        jns big_from_int_positive
+        mov qword ptr [r8], 1
+        neg rax
+big_from_int_positive:
+        mov rcx, 1000000000
+        xor rdx, rdx
+        div rcx
+        mov dword ptr [r8 + 16], edx
+        xor rdx, rdx
+        div rcx
+        mov dword ptr [r8 + 20], edx
+        mov dword ptr [r8 + 24], eax
+        mov rdi, r8
+        call big_trim
+        pop rbx
+        ret
+
+#
+# Convert the number in rdi into an integer, which is returned in rax.
+# Numbers with more than eighteen digits overflow.
+#
+big_to_int:
+        mov rcx, qword ptr [rdi + 8]
+        cmp rcx, 2
+        ja register_overflow
+        xor rax, rax
+        test rcx, rcx
+        jz big_to_int_sign
+        mov eax, dword ptr [rdi + 16]
+        cmp rcx, 1
+        je big_to_int_sign
+        mov ecx, dword ptr [rdi + 20]
+        imul rcx, rcx, 1000000000
+        add rax, rcx
+big_to_int_sign:
+        cmp qword ptr [rdi], 0
+        je big_to_int_done
+        neg rax
+big_to_int_done:
+        ret
+
+#
+# Convert the decimal digits pointed to by rdi, with an optional sign,
+# into a number which is returned in rax.  The end of the digits is
+# stored at the location pointed to by rsi.
+#
+# If there are no digits the start of the string is stored as the end,
+# and zero is returned in rax.
+#
+big_parse:
+        push rbx
+        push r12
+        mov r12, rsi
+        mov qword ptr [r12], rdi
+        xor rbx, rbx            # the sign
+        mov r8, rdi             # the first digit
+        cmp byte ptr [r8], '-'
+        jne big_parse_plus
+        inc rbx
+        inc r8
+        jmp big_parse_digits
+big_parse_plus:
+        cmp byte ptr [r8], '+'
+        jne big_parse_digits
+        inc r8
+big_parse_digits:
+        mov r9, r8              # the end of the digits
+big_parse_count:
+        movzx eax, byte ptr [r9]
+        sub eax, '0'
+        cmp eax, 9
+        ja big_parse_counted
+        inc r9
+        jmp big_parse_count
+big_parse_counted:
+        mov rax, r9
+        sub rax, r8
+        jz big_parse_failed
+        mov qword ptr [r12], r9
+
+        # each limb holds nine digits.
+        add rax, 8
+        xor rdx, rdx
+        mov rcx, 9
+        div rcx
+        mov rdi, rax
+        call big_alloc
+        mov qword ptr [rax], rbx
+
+        # convert the digits, nine at a time, from the end.
+        xor r10, r10            # the limb
+big_parse_limb:
+        cmp r9, r8
+        jbe big_parse_done
+        lea r11, [r9 - 9]
+        cmp r11, r8
+        cmovb r11, r8
+        mov rsi, r11
+        xor edx, edx
+big_parse_digit:
+        cmp rsi, r9
+        jae big_parse_limb_done
+        imul edx, edx, 10
+        movzx ecx, byte ptr [rsi]
+        sub ecx, '0'
+        add edx, ecx
+        inc rsi
+        jmp big_parse_digit
+big_parse_limb_done:
+        mov dword ptr [rax + 16 + r10*4], edx
+        inc r10
+        mov r9, r11
+        jmp big_parse_limb
+big_parse_done:
+        mov rdi, rax
+        call big_trim
+        jmp big_parse_return
+big_parse_failed:
+        xor rax, rax
+big_parse_return:
+        pop r12
+        pop rbx
+        ret
+
+#
+# Compare the magnitudes of the numbers in rdi and rsi, returning -1, 0,
+# or 1 in rax.
+#
+mag_cmp:
+        mov rcx, qword ptr [rdi + 8]
+        cmp rcx, qword ptr [rsi + 8]
+        jne mag_cmp_differ
+mag_cmp_next:
+        test rcx, rcx
+        jz mag_cmp_equal
+        mov eax, dword ptr [rdi + 12 + rcx*4]
+        cmp eax, dword ptr [rsi + 12 + rcx*4]
+        jne mag_cmp_differ
+        dec rcx
+        jmp mag_cmp_next
+mag_cmp_equal:
+        xor rax, rax
+        ret
+mag_cmp_differ:
+        mov rax, 1
+        mov rcx, -1
+        cmovb rax, rcx
+        ret
+
+#
+# Add the magnitudes of the numbers in rdi and rsi, returning a new
+# number in rax.
+#
+mag_add:
+        push rbx
+        push r12
+        mov r11, rdi
+        mov r8, qword ptr [r11 + 8]
+        mov r9, qword ptr [rsi + 8]
+        mov r10, r8
+        cmp r9, r10
+        cmova r10, r9
+        lea rdi, [r10 + 1]
+        call big_alloc
+        mov r12, rax
+        xor rcx, rcx
+        xor rdx, rdx            # the carry
+mag_add_next:
+        cmp rcx, r10
+        jae mag_add_done
+        mov rbx, rdx
+        cmp rcx, r8
+        jae mag_add_second
+        mov eax, dword ptr [r11 + 16 + rcx*4]
+        add rbx, rax
+mag_add_second:
+        cmp rcx, r9
+        jae mag_add_store
+        mov eax, dword ptr [rsi + 16 + rcx*4]
+        add rbx, rax
+mag_add_store:
+        xor rdx, rdx
+        cmp rbx, 1000000000
+        jb mag_add_limb
+        sub rbx, 1000000000
+        inc rdx
+mag_add_limb:
+        mov dword ptr [r12 + 16 + rcx*4], ebx
+        inc rcx
+        jmp mag_add_next
+mag_add_done:
+        mov dword ptr [r12 + 16 + rcx*4], edx
+        mov rdi, r12
+        call big_trim
+        pop r12
+        pop rbx
+        ret
+
+#
+# Subtract the magnitude of the number in rdx from that of the number in
+# rsi, which must be at least as large, storing the result in the number
+# in rdi - which may be the same as rsi.  It is returned in rax.
+#
+mag_sub_into:
+        push rbx
+        mov r11, rdx
+        mov r8, qword ptr [rsi + 8]
+        mov r9, qword ptr [r11 + 8]
+        xor rcx, rcx
+        xor rdx, rdx            # the borrow
+mag_sub_next:
+        cmp rcx, r8
+        jae mag_sub_done
+        mov ebx, dword ptr [rsi + 16 + rcx*4]
+        sub rbx, rdx
+        cmp rcx, r9
+        jae mag_sub_store
+        mov eax, dword ptr [r11 + 16 + rcx*4]
+        sub rbx, rax
+mag_sub_store:
+        xor rdx, rdx
+        test rbx, rbx
+        jns mag_sub_limb
+        add rbx, 1000000000
+        inc rdx
+mag_sub_limb:
+        mov dword ptr [rdi + 16 + rcx*4], ebx
+        inc rcx
+        jmp mag_sub_next
+mag_sub_done:
+        mov qword ptr [rdi], 0
+        mov qword ptr [rdi + 8], r8
+        call big_trim
+        pop rbx
+        ret
+
+#
+# Subtract the magnitude of the number in rsi from that of the number in
+# rdi, which must be at least as large, returning a new number in rax.
+#
+mag_sub:
+        push r12
+        push r13
+        mov r12, rdi
+        mov r13, rsi
+        mov rdi, qword ptr [r12 + 8]
+        call big_alloc
+        mov rdi, rax
+        mov rsi, r12
+        mov rdx, r13
+        call mag_sub_into
+        pop r13
+        pop r12
+        ret
+
+#
+# Multiply the magnitude of the number in rdi by that of the number in
+# rsi, returning a new number in rax.
+#
+mag_mul:
+        push rbx
+        push r12
+        push r13
+        push r14
+        mov r11, rdi
+        mov r8, qword ptr [r11 + 8]
+        mov r9, qword ptr [rsi + 8]
+        lea rdi, [r8 + r9]
+        call big_alloc
+        mov r12, rax
+        xor rcx, rcx
+mag_mul_zero:
+        cmp rcx, rdi
+        jae mag_mul_rows
+        mov dword ptr [r12 + 16 + rcx*4], 0
+        inc rcx
+        jmp mag_mul_zero
+mag_mul_rows:
+        mov r10, 1000000000
+        xor r13, r13            # the limb of the first number
+mag_mul_row:
+        cmp r13, r8
+        jae mag_mul_done
+        xor r14, r14            # the limb of the second number
+        xor rbx, rbx            # the carry
+mag_mul_column:
+        cmp r14, r9
+        jae mag_mul_row_done
+        mov eax, dword ptr [r11 + 16 + r13*4]
+        mov ecx, dword ptr [rsi + 16 + r14*4]
+        mul rcx
+        add rax, rbx
+        lea rcx, [r13 + r14]
+        mov edx, dword ptr [r12 + 16 + rcx*4]
+        add rax, rdx
+        xor rdx, rdx
+        div r10
+        mov dword ptr [r12 + 16 + rcx*4], edx
+        mov rbx, rax
+        inc r14
+        jmp mag_mul_column
+mag_mul_row_done:
+        lea rcx, [r13 + r9]
+        mov dword ptr [r12 + 16 + rcx*4], ebx
+        inc r13
+        jmp mag_mul_row
+mag_mul_done:
+        mov rdi, r12
+        call big_trim
+        pop r14
+        pop r13
+        pop r12
+        pop rbx
+        ret
+
+#
+# Multiply the magnitude of the number in rsi by rdx, which must be a
+# single limb, storing the result in the number in rdi - which must have
+# room for one more limb.  It is returned in rax.
+#
+mag_mul_small:
+        push rbx
+        mov r8, rdx
+        mov r9, qword ptr [rsi + 8]
+        mov r10, 1000000000
+        xor rcx, rcx
+        xor rbx, rbx            # the carry
+mag_mul_small_next:
+        cmp rcx, r9
+        jae mag_mul_small_done
+        mov eax, dword ptr [rsi + 16 + rcx*4]
+        mul r8
+        add rax, rbx
+        xor rdx, rdx
+        div r10
+        mov dword ptr [rdi + 16 + rcx*4], edx
+        mov rbx, rax
+        inc rcx
+        jmp mag_mul_small_next
+mag_mul_small_done:
+        mov dword ptr [rdi + 16 + rcx*4], ebx
+        inc rcx
+        mov qword ptr [rdi], 0
+        mov qword ptr [rdi + 8], rcx
+        call big_trim
+        pop rbx
+        ret
+
+#
+# Divide the magnitude of the number in rdi by that of the number in
+# rsi, which mustn't be zero, returning new numbers holding the quotient
+# in rax, and the remainder in rdx.
+#
+# This is long division, one limb at a time.  Each limb of the quotient
+# is found by a binary search.
+#
+mag_divmod:
+        push rbx
+        push r12
+        push r13
+        push r14
+        push r15
+        push rbp
+        sub rsp, 24
+        mov r12, rdi            # the dividend
+        mov r13, rsi            # the divisor
+        mov rdi, qword ptr [r12 + 8]
+        call big_alloc
+        mov r14, rax            # the quotient
+        mov rdi, qword ptr [r13 + 8]
+        inc rdi
+        call big_alloc
+        mov r15, rax            # the remainder
+        mov qword ptr [r15 + 8], 0
+        call big_alloc
+        mov rbp, rax            # the divisor multiplied by a limb
+        mov rbx, qword ptr [r12 + 8]
+mag_divmod_next:
+        test rbx, rbx
+        jz mag_divmod_done
+        dec rbx
+
+        # bring the next limb of the dividend down into the remainder
+        mov rcx, qword ptr [r15 + 8]
+mag_divmod_shift:
+        test rcx, rcx
+        jz mag_divmod_shifted
+        mov eax, dword ptr [r15 + 12 + rcx*4]
+        mov dword ptr [r15 + 16 + rcx*4], eax
+        dec rcx
+        jmp mag_divmod_shift
+mag_divmod_shifted:
+        mov eax, dword ptr [r12 + 16 + rbx*4]
+        mov dword ptr [r15 + 16], eax
+        inc qword ptr [r15 + 8]
+        mov rdi, r15
+        call big_trim
+
+        # find the largest limb for which divisor * limb <= remainder
+        mov qword ptr [rsp], 0
+        mov qword ptr [rsp + 8], 999999999
+mag_divmod_search:
+        mov rax, qword ptr [rsp]
+        cmp rax, qword ptr [rsp + 8]
+        jae mag_divmod_found
+        add rax, qword ptr [rsp + 8]
+        inc rax
+        shr rax, 1
+        mov qword ptr [rsp + 16], rax
+        mov rdi, rbp
+        mov rsi, r13
+        mov rdx, rax
+        call mag_mul_small
+        mov rdi, rbp
+        mov rsi, r15
+        call mag_cmp
+        mov rcx, qword ptr [rsp + 16]
+        cmp rax, 0
+        jg mag_divmod_lower
+        mov qword ptr [rsp], rcx
+        jmp mag_divmod_search
+mag_divmod_lower:
+        dec rcx
+        mov qword ptr [rsp + 8], rcx
+        jmp mag_divmod_search
+mag_divmod_found:
+        mov dword ptr [r14 + 16 + rbx*4], eax
+        mov rdi, rbp
+        mov rsi, r13
+        mov rdx, rax
+        call mag_mul_small
+        mov rdi, r15
+        mov rsi, r15
+        mov rdx, rbp
+        call mag_sub_into
+        jmp mag_divmod_next
+
+mag_divmod_done:
+        mov rdi, rbp
+        call big_free
+        mov rdi, r14
+        call big_trim
+        mov rdx, r15
+        add rsp, 24
+        pop rbp
+        pop r15
+        pop r14
+        pop r13
+        pop r12
+        pop rbx
+        ret
+
+#
+# Return the absolute value of the number in rdi.
+#
+big_abs:
+        call big_copy
+        mov qword ptr [rax], 0
+        ret
+
+#
+# Add, or subtract, the numbers in rdi and rsi, returning the result in
+# rax.  When adding with different signs we subtract the smaller
+# magnitude from the larger.
+#
+big_add:
+        mov rdx, qword ptr [rsi]
+        jmp big_add_signed
+big_sub:
+        mov rdx, qword ptr [rsi]
+        xor rdx, 1
+big_add_signed:
+        push r12
+        push r13
+        push r14
+        mov r12, rdi
+        mov r13, rsi
+        mov r14, rdx            # the sign of the second number
+        cmp r14, qword ptr [r12]
+        jne big_add_differ
+        call mag_add
+        mov rcx, r14
+        jmp big_add_sign
+big_add_differ:
+        call mag_cmp
+        cmp rax, 0
+        jl big_add_larger
+        mov rdi, r12
+        mov rsi, r13
+        call mag_sub
+        mov rcx, qword ptr [r12]
+        jmp big_add_sign
+big_add_larger:
+        mov rdi, r13
+        mov rsi, r12
+        call mag_sub
+        mov rcx, r14
+big_add_sign:
+        mov qword ptr [rax], rcx
+        mov rdi, rax
+        call big_trim
+        pop r14
+        pop r13
+        pop r12
+        ret
+
+#
+# Multiply the numbers in rdi and rsi, returning the result in rax.
+#
+big_mul:
+        push r12
+        push r13
+        mov r12, rdi
+        mov r13, rsi
+        call mag_mul
+        mov rcx, qword ptr [r12]
+        xor rcx, qword ptr [r13]
+        mov qword ptr [rax], rcx
+        mov rdi, rax
+        call big_trim
+        pop r13
+        pop r12
+        ret
+
+#
+# Divide the number in rdi by the number in rsi, returning the quotient,
+# which is rounded towards zero, in rax and the remainder, which has the
+# sign of the dividend, in rdx.
+#
+big_divmod:
+        cmp qword ptr [rsi + 8], 0
+        je division_by_zero
+        push r12
+        push r13
+        push r14
+        mov r12, rdi
+        mov r13, rsi
+        call mag_divmod
+        mov r14, rdx
+        mov rcx, qword ptr [r12]
+        xor rcx, qword ptr [r13]
+        mov qword ptr [rax], rcx
+        mov rdi, rax
+        call big_trim
+        mov r13, rax
+        mov rcx, qword ptr [r12]
+        mov qword ptr [r14], rcx
+        mov rdi, r14
+        call big_trim
+        mov rax, r13
+        mov rdx, r14
+        pop r14
+        pop r13
+        pop r12
+        ret
+
+#
+# Return the remainder of dividing the number in rdi by the number in
+# rsi, which has the sign of the divisor.
+#
+big_floored_mod:
+        push r12
+        push r13
+        mov r13, rsi
+        call big_divmod
+        mov r12, rdx
+        mov rdi, rax
+        call big_free
+        mov rax, r12
+        cmp qword ptr [r12 + 8], 0
+        je big_floored_mod_done
+        mov rcx, qword ptr [r12]
+        cmp rcx, qword ptr [r13]
+        je big_floored_mod_done
+        mov rdi, r12
+        mov rsi, r13
+        call big_add
+        mov rdi, r12
+        call big_free
+big_floored_mod_done:
+        pop r13
+        pop r12
+        ret
+
+#
+# Raise the number in rdi to the power of the number in rsi, which mustn't
+# be negative, returning the result in rax.
+#
+big_pow:
+        push rbx
+        push r12
+        push r13
+        push r14
+        mov r12, rdi            # the base, which is squared
+        mov r14, rdi
+        mov rdi, rsi
+        call big_to_int
+        test rax, rax
+        js domain_error
+        mov rbx, rax            # the exponent
+        mov rdi, 1
+        call big_from_int
+        mov r13, rax            # the result
+big_pow_next:
+        test rbx, 1
+        jz big_pow_square
+        mov rdi, r13
+        mov rsi, r12
+        call big_mul
+        mov rdi, r13
+        call big_free
+        mov r13, rax
+big_pow_square:
+        shr rbx, 1
+        jz big_pow_done
+        mov rdi, r12
+        mov rsi, r12
+        call big_mul
+        mov rdi, r12
+        cmp rdi, r14
+        je big_pow_squared
+        call big_free
+big_pow_squared:
+        mov r12, rax
+        jmp big_pow_next
+big_pow_done:
+        cmp r12, r14
+        je big_pow_return
+        mov rdi, r12
+        call big_free
+big_pow_return:
+        mov rax, r13
+        pop r14
+        pop r13
+        pop r12
+        pop rbx
+        ret
+
+#
+# Return the factorial of the number in rdi, which mustn't be negative.
+#
+big_factorial:
+        push rbx
+        push r12
+        push r13
+        call big_to_int
+        test rax, rax
+        js domain_error
+        cmp rax, 1000000000
+        jae register_overflow
+        mov r12, rax
+        mov rdi, 1
+        call big_from_int
+        mov r13, rax            # the result
+        mov rbx, 2
+big_factorial_next:
+        cmp rbx, r12
+        ja big_factorial_done
+        mov rdi, qword ptr [r13 + 8]
+        inc rdi
+        call big_alloc
+        mov rdi, rax
+        mov rsi, r13
+        mov rdx, rbx
+        call mag_mul_small
+        mov rdi, r13
+        call big_free
+        mov r13, rax
+        inc rbx
+        jmp big_factorial_next
+big_factorial_done:
+        mov rax, r13
+        pop r13
+        pop r12
+        pop rbx
+        ret
+`
+}
@@ -19,13 +19,20 @@ package compiler
 
 import (
 	"fmt"
-	"math"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/skx/math-compiler/instructions"
 	"github.com/skx/math-compiler/lexer"
 	"github.com/skx/math-compiler/token"
+	"github.com/skx/math-compiler/units"
 )
 
+// maxInputs is the largest number of runtime inputs which a program
+// may use, so "$1" to "$1000" are valid.
+const maxInputs = 1000
+
 // Compiler holds our object-state.
 type Compiler struct {
 
@@ -59,13 +66,163 @@ type Compiler struct {
 	// Instructions is the virtual instructions we're going to compile
 	// to assembly
 	instructions []instructions.Instruction
+
+	// quotations holds the instructions of each quotation, such as
+	// "[ x sin ]", which are compiled to subroutines of their own.
+	quotations [][]instructions.Instruction
+
+	// inputs holds the names of the runtime inputs which have been
+	// declared.  The first name is an alias for "$1", the second
+	// for "$2", and so on.
+	inputs []string
+
+	// arguments holds the number of runtime inputs the generated
+	// program expects to find upon its command-line.
+	arguments int
+
+	// stream is true if the generated program should read its inputs
+	// from STDIN, one record per line, rather than from its command-line.
+	stream bool
+
+	// printAll is true if every value remaining upon the stack should
+	// be printed when the program terminates, rather than just one.
+	printAll bool
+
+	// labels holds the text of the labels used by `.label`, which are
+	// stored in the data-area much like our constants.
+	labels []string
+
+	//
+	// Output formatting.
+	//
+	// format holds a printf-format which is used to output numbers, if
+	// it is empty we use "%g", or one of the alternatives below.
+	//
+	format string
+
+	// digits holds the number of significant digits to output, if
+	// non-zero.
+	digits int
+
+	// integers is true if values which are integral should be output
+	// exactly, without an exponent.
+	integers bool
+
+	// bare is true if results should be output without the "Result"
+	// prefix.
+	bare bool
+
+	// json is true if each output value should be a JSON object.
+	json bool
+
+	// hex is true if values should be output as hexadecimal floats.
+	hex bool
+
+	// exitResult is true if the integer part of the result should be
+	// used as the exit-code of the generated program.
+	exitResult bool
+
+	// random is true if the program uses random numbers, in which case
+	// we need to seed our generator.
+	random bool
+
+	// seed holds the seed for our random number generator, if seeded
+	// is true.  Otherwise the generator is seeded from the clock.
+	seed   uint64
+	seeded bool
+
+	// fpErrors holds our floating-point error policy, one of "trap",
+	// "ieee", or "saturate".  An empty string means "trap".
+	fpErrors string
+
+	// precision holds our working precision, one of "single", "double",
+	// or "extended".  An empty string means "double".
+	precision string
+
+	// fpu holds the instructions we use for arithmetic, one of "x87"
+	// or "sse".  An empty string means "x87".
+	fpu string
+
+	// fma is true if a multiplication followed by an addition, or a
+	// subtraction, should be fused into one instruction.
+	fma bool
+
+	// math holds how the transcendental functions are calculated, either
+	// "fast", by the FPU, or "accurate", by the C library.  An empty
+	// string means "fast".
+	math string
+
+	// mode holds the kind of values we calculate with, one of "float",
+	// "int64", "bignum", "rational", "complex", "interval", or
+	// "decimal".  An empty string means "float".
+	mode string
+
+	// places holds the number of decimal places we keep, if our mode
+	// is "decimal".
+	places int
+
+	// rounding holds how the products, and quotients, of decimals are
+	// rounded, one of "half-even", "half-up", or "truncate".  An empty
+	// string means "half-even".
+	rounding string
+
+	// unit holds the unit which values are output in, such as "km/h",
+	// if any.  Otherwise values are output in SI base units.
+	unit string
+
+	// grad holds the names of the runtime inputs which our results are
+	// differentiated by, if any.
+	grad []string
+
+	// derive holds the name of the runtime input which our program is
+	// differentiated by, symbolically, if any.  The program is replaced
+	// by its derivative, which is held in formula.
+	derive  string
+	formula *node
+
+	// infix is true if our derivative should be written in infix
+	// notation, rather than reverse Polish notation.
+	infix bool
+
+	// ranges holds the ranges of values, such as "x=0:10:0.5", which
+	// our runtime inputs take, if we're printing a table of results.
+	// They're parsed into spans.
+	ranges []string
+	spans  []span
+
+	// csv is true if our table should be output as comma-separated
+	// values, rather than separated by tabs.
+	csv bool
+
+	// shapes holds the lengths of the vectors which are given to, and
+	// calculated by, each instruction of our program, if any - and
+	// resultLength the length of our result, if it is a vector.
+	shapes       map[int]shape
+	resultLength int
 }
 
 //
-// Our public API consists of the three functions:
+// Our public API consists of the constructor, New, a collection of
+// setters to change our options, and Compile:
 //  New
 //  SetDebug
-//  Compile
+//  SetInputs
+//  SetStream
+//  SetPrintAll
+//  SetFormat, SetDigits, SetIntegers, SetBare, SetJSON, SetHex
+//  SetExitResult
+//  SetSeed
+//  SetFPErrors
+//  SetPrecision
+//  SetFPU, SetFMA
+//  SetMath
+//  SetMode
+//  SetRounding
+//  SetUnit
+//  SetGrad
+//  SetDerive, SetInfix
+//  SetRanges, SetCSV
+//  Compile, Derivative
 //
 // The rest of the code is an implementation detail.
 //
@@ -81,19 +238,269 @@ func (c *Compiler) SetDebug(val bool) {
 	c.debug = val
 }
 
+// SetInputs declares the names of the runtime inputs which the expression
+// may refer to.  These are read from the command-line of the generated
+// program, in the order given.
+func (c *Compiler) SetInputs(names []string) {
+	c.inputs = names
+}
+
+// SetStream changes the generated program to read records from STDIN,
+// binding the fields of each line to the runtime inputs, and printing
+// one result per record.
+func (c *Compiler) SetStream(val bool) {
+	c.stream = val
+}
+
+// SetPrintAll changes the generated program to print every value which
+// remains upon the stack when it terminates, rather than insisting that
+// exactly one is present.
+func (c *Compiler) SetPrintAll(val bool) {
+	c.printAll = val
+}
+
+// SetFormat sets a printf-format which is used to output numbers, for
+// example "%.3f".  It must contain exactly one floating-point conversion.
+func (c *Compiler) SetFormat(format string) {
+	c.format = format
+}
+
+// SetDigits sets the number of significant digits used to output numbers.
+func (c *Compiler) SetDigits(val int) {
+	c.digits = val
+}
+
+// SetIntegers changes the output of values which are integral, such that
+// they're output exactly, rather than with an exponent.
+func (c *Compiler) SetIntegers(val bool) {
+	c.integers = val
+}
+
+// SetBare changes the output of results to omit the "Result" prefix.
+func (c *Compiler) SetBare(val bool) {
+	c.bare = val
+}
+
+// SetJSON changes the output such that each value is a JSON object.
+func (c *Compiler) SetJSON(val bool) {
+	c.json = val
+}
+
+// SetHex changes the output of numbers to use hexadecimal floats, via "%a".
+func (c *Compiler) SetHex(val bool) {
+	c.hex = val
+}
+
+// SetExitResult changes the generated program such that its exit-code is
+// the integer part of the result, modulo 256.
+func (c *Compiler) SetExitResult(val bool) {
+	c.exitResult = val
+}
+
+// SetSeed sets the seed of the random number generator, so that the
+// generated program is reproducible.  By default it is seeded from
+// the clock.
+func (c *Compiler) SetSeed(val uint64) {
+	c.seed = val
+	c.seeded = true
+}
+
+// SetFPErrors sets the policy for floating-point errors, which is one of:
+//
+//   trap     - Report invalid operations, division by zero, and overflow.
+//   ieee     - Allow infinities and NaNs to propagate, and print them.
+//   saturate - Clamp infinite results to the largest finite value.
+func (c *Compiler) SetFPErrors(policy string) {
+	c.fpErrors = policy
+}
+
+// SetPrecision sets our working precision, which is one of "single",
+// "double", or "extended".  This changes both the precision of the FPU,
+// and the size of the values upon the stack.
+func (c *Compiler) SetPrecision(precision string) {
+	c.precision = precision
+}
+
+// SetFPU sets the instructions which are used for arithmetic, either the
+// legacy "x87" instructions, or "sse".  When using SSE the transcendental
+// functions are calculated by the C library.
+func (c *Compiler) SetFPU(fpu string) {
+	c.fpu = fpu
+}
+
+// SetFMA changes the generated program such that a multiplication which
+// is followed by an addition, or subtraction, is calculated by one fused
+// multiply-add instruction.  This requires SSE.
+func (c *Compiler) SetFMA(val bool) {
+	c.fma = val
+}
+
+// SetMath sets how the transcendental functions are calculated, either
+// "fast", using the instructions of the FPU, or "accurate", calling the
+// C library.  The FPU's instructions lose accuracy for large arguments.
+func (c *Compiler) SetMath(math string) {
+	c.math = math
+}
+
+// SetMode sets the kind of values which our program calculates with,
+// either "float", "int64" for signed 64-bit integers, "bignum" for
+// integers of arbitrary size, "rational" for exact fractions, "complex"
+// for complex numbers, "interval" for bounds upon real numbers, or
+// "decimal:N" for fixed-point decimals with N decimal places.
+func (c *Compiler) SetMode(mode string) {
+	c.mode = mode
+	c.places = 0
+	if strings.HasPrefix(mode, "decimal") {
+		c.mode = "decimal"
+		c.places = -1
+		n, err := strconv.Atoi(strings.TrimPrefix(mode, "decimal:"))
+		if err == nil && strings.HasPrefix(mode, "decimal:") {
+			c.places = n
+		}
+	}
+}
+
+// SetRounding sets how the products, and quotients, of decimals are
+// rounded to the number of decimal places we keep, which is one of
+// "half-even", "half-up", or "truncate".
+func (c *Compiler) SetRounding(rounding string) {
+	c.rounding = rounding
+}
+
+// SetUnit sets the unit which values are output in, such as "km/h".
+// Every value which is output must be measured in a unit of the same
+// dimension, which is checked when compiling.
+func (c *Compiler) SetUnit(unit string) {
+	c.unit = unit
+}
+
+// SetGrad sets the names of the runtime inputs, such as "x", or "$1",
+// which our results are differentiated by.  Each result is output along
+// with its derivative with respect to each of them.
+func (c *Compiler) SetGrad(names []string) {
+	c.grad = names
+}
+
+// SetDerive sets the name of the runtime input, such as "x", which our
+// program is differentiated by, symbolically.  The program which we
+// compile calculates the derivative, rather than the expression.
+func (c *Compiler) SetDerive(name string) {
+	c.derive = name
+}
+
+// SetInfix changes the derivative which is returned by Derivative to be
+// written in infix notation, such as "2 * x", rather than as a program.
+func (c *Compiler) SetInfix(val bool) {
+	c.infix = val
+}
+
+// SetRanges sets the ranges of values which our runtime inputs take,
+// such as "x=0:10:0.5", rather than reading them from the command-line.
+// The generated program prints a table with one row for each value, or
+// for each combination of values if there are several ranges.
+func (c *Compiler) SetRanges(ranges []string) {
+	c.ranges = ranges
+}
+
+// SetCSV changes the table printed by our ranges to be comma-separated
+// values, rather than separated by tabs.
+func (c *Compiler) SetCSV(val bool) {
+	c.csv = val
+}
+
 // Compile converts the input program into a collection of
 // AMD64-assembly language.
 func (c *Compiler) Compile() (string, error) {
 
+	err := c.prepare()
+	if err != nil {
+		return "", err
+	}
+
+	//
+	// Now generate the output assembly
+	//
+	out := c.output()
+
+	return out, nil
+}
+
+// Derivative returns the derivative of the input program, with respect
+// to the runtime input given to SetDerive.  The derivative is simplified,
+// and written as a program which we can compile - or in infix notation.
+func (c *Compiler) Derivative() (string, error) {
+	if c.derive == "" {
+		return "", fmt.Errorf("no runtime input was given to differentiate by")
+	}
+
+	err := c.prepare()
+	if err != nil {
+		return "", err
+	}
+	return c.formulaText(), nil
+}
+
+// prepare parses the input program, and converts it to our internal form,
+// once we've ensured that it, and our options, are valid.
+func (c *Compiler) prepare() error {
+
+	//
+	// Ensure our options make sense, before we parse the program,
+	// as the numbers we accept depend upon our mode.
+	//
+	err := c.checkOptions()
+	if err != nil {
+		return err
+	}
+
 	//
 	// Parse the program into a series of statements, etc.
 	//
 	// At this point there might be errors.  If so report them,
 	// and terminate.
 	//
-	err := c.tokenize()
+	err = c.tokenize()
 	if err != nil {
-		return "", err
+		return err
+	}
+	err = c.checkOutput()
+	if err != nil {
+		return err
+	}
+
+	//
+	// Parse the ranges of our inputs, if we're printing a table.
+	//
+	if c.tabulated() {
+		err = c.checkRanges()
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// Find our vectors, which are written in brackets - like our
+	// quotations.
+	//
+	err = c.findVectors()
+	if err != nil {
+		return err
+	}
+
+	//
+	// Ensure each quotation is complete, and used.
+	//
+	err = c.checkQuotations()
+	if err != nil {
+		return err
+	}
+
+	//
+	// Ensure the units of our values are consistent.
+	//
+	err = c.checkUnits()
+	if err != nil {
+		return err
 	}
 
 	//
@@ -102,11 +509,20 @@ func (c *Compiler) Compile() (string, error) {
 	c.makeinternalform()
 
 	//
-	// Now generate the output assembly
+	// Ensure our vectors are given to operations which accept them.
 	//
-	out := c.output()
+	err = c.checkShapes()
+	if err != nil {
+		return err
+	}
 
-	return out, nil
+	//
+	// Replace our program with its derivative, if we're differentiating.
+	//
+	if c.derive != "" {
+		return c.differentiate()
+	}
+	return nil
 }
 
 // tokenize populates our internal list of tokens, as a result of
@@ -121,9 +537,40 @@ func (c *Compiler) tokenize() error {
 	//
 	lexed := lexer.New(c.expression)
 
+	//
+	// Ensure the lexer knows about the names of our runtime inputs.
+	//
+	for i, name := range c.inputs {
+		if !validName(name) {
+			return fmt.Errorf("invalid name for a runtime input: '%s'", name)
+		}
+		if token.LookupIdentifier(name) != token.ERROR {
+			return fmt.Errorf("the runtime input '%s' has the same name as a built-in", name)
+		}
+		if units.Valid(name) {
+			return fmt.Errorf("the runtime input '%s' has the same name as a unit", name)
+		}
+		for _, prev := range c.inputs[:i] {
+			if prev == name {
+				return fmt.Errorf("the runtime input '%s' was declared twice", name)
+			}
+		}
+		lexed.Declare(name)
+	}
+
+	//
+	// The variable of a quotation is always "x", even if there's no
+	// runtime input with that name.
+	//
+	lexed.Declare("x")
+
 	//
 	// First of all populate that `program` array with our tokens.
 	//
+	// We count the quotations we're within, since "x" is only defined
+	// inside them - unless it is also the name of a runtime input.
+	//
+	quoted := 0
 	for {
 		// Get the next token.
 		tok := lexed.NextToken()
@@ -138,16 +585,101 @@ func (c *Compiler) tokenize() error {
 			return (fmt.Errorf("error parsing input; token.ERROR returned from the lexer: %s", tok.Literal))
 		}
 
+		// "$0" would be the name of the program, not an input, and
+		// we only accept a limited number of inputs.
+		if tok.Type == token.IDENT && strings.HasPrefix(tok.Literal, "$") {
+			n, err := strconv.Atoi(tok.Literal[1:])
+			if err == nil && n < 1 {
+				return fmt.Errorf("runtime inputs are numbered from $1")
+			}
+			if err != nil || n > maxInputs {
+				return fmt.Errorf("runtime inputs are numbered up to $%d", maxInputs)
+			}
+		}
+
+		if tok.Type == token.LBRACKET {
+			quoted++
+		}
+		if tok.Type == token.RBRACKET {
+			quoted--
+		}
+		if tok.Type == token.IDENT && tok.Literal == "x" && quoted <= 0 && !c.isInput("x") {
+			return fmt.Errorf("'x' is only defined within a quotation, such as [ x sin ]")
+		}
+
+		// Not every word is supported in every mode.
+		err := c.supported(tok)
+		if err != nil {
+			return err
+		}
+
+		//
+		// Integers are written out in a canonical form, since the
+		// assembler treats a leading zero as meaning octal.
+		//
+		if tok.Type == token.NUMBER && c.mode == "int64" {
+			n, err := strconv.ParseInt(tok.Literal, 10, 64)
+			if err != nil {
+				return fmt.Errorf("the number '%s' isn't a 64-bit integer", tok.Literal)
+			}
+			tok.Literal = strconv.FormatInt(n, 10)
+		}
+		if tok.Type == token.NUMBER && c.mode == "bignum" {
+			if strings.Trim(strings.TrimPrefix(tok.Literal, "-"), "0123456789") != "" {
+				return fmt.Errorf("the number '%s' isn't an integer", tok.Literal)
+			}
+		}
+		if tok.Type == token.NUMBER && c.mode == "rational" {
+			_, _, err := rational(tok.Literal)
+			if err != nil {
+				return err
+			}
+		}
+		if tok.Type == token.NUMBER && c.mode == "interval" {
+			_, _, err := interval(tok.Literal)
+			if err != nil {
+				return err
+			}
+		}
+		if tok.Type == token.NUMBER && c.mode == "decimal" {
+			_, err := decimal(tok.Literal, c.places)
+			if err != nil {
+				return err
+			}
+		}
+
+		//
+		// Floating-point numbers must fit in our working precision,
+		// as the assembler can't store one which doesn't.
+		//
+		if tok.Type == token.NUMBER && (c.mode == "" || c.mode == "float") && c.precision != "extended" {
+			bits, name := 64, "double"
+			if c.precision == "single" {
+				bits, name = 32, "single"
+			}
+			_, err := strconv.ParseFloat(tok.Literal, bits)
+			if err != nil {
+				return fmt.Errorf("the number '%s' is out of range for %s precision", tok.Literal, name)
+			}
+		}
+
 		//
 		// We'll convert "pi" and "e" into numbers as a special case.
 		//
 		if tok.Type == token.PI {
 			tok.Type = token.NUMBER
-			tok.Literal = fmt.Sprintf("%f", math.Pi)
+			tok.Literal = "3.14159265358979323846"
 		}
 		if tok.Type == token.E {
 			tok.Type = token.NUMBER
-			tok.Literal = fmt.Sprintf("%f", math.E)
+			tok.Literal = "2.71828182845904523536"
+		}
+
+		//
+		// As is "i", which is a complex constant.
+		//
+		if tok.Type == token.I {
+			tok.Type = token.NUMBER
 		}
 
 		// Otherwise append the token to our program.
@@ -162,19 +694,40 @@ func (c *Compiler) tokenize() error {
 	}
 
 	//
-	// If the first token isn't a number we're in trouble
+	// Strings are only used to label output, so each must be
+	// immediately followed by `.label`, and vice-versa.
+	//
+	for i, tok := range c.tokens {
+		if tok.Type == token.STRING {
+			if i+1 >= len(c.tokens) || c.tokens[i+1].Type != token.LABEL {
+				return fmt.Errorf("the string \"%s\" must be followed by .label", tok.Literal)
+			}
+		}
+		if tok.Type == token.LABEL {
+			if i == 0 || c.tokens[i-1].Type != token.STRING {
+				return fmt.Errorf(".label must be preceded by a string")
+			}
+		}
+	}
+
+	//
+	// If the first token isn't a number, an input, a random number, or
+	// a quotation, we're in trouble
 	//
-	if c.tokens[0].Type != token.NUMBER {
+	switch c.tokens[0].Type {
+	case token.NUMBER, token.IDENT, token.RAND, token.RANDN, token.LBRACKET:
+	default:
 		return (fmt.Errorf("we expected the program to begin with a numeric thing"))
 	}
 
 	//
-	// Get the last token
+	// Get the last token, which can only be a number if we're printing
+	// whatever is left upon the stack.
 	//
-	if len(c.tokens) > 1 {
+	if len(c.tokens) > 1 && !c.printAll {
 		len := len(c.tokens)
 		end := c.tokens[len-1]
-		if end.Type == token.NUMBER {
+		if end.Type == token.NUMBER || end.Type == token.IDENT {
 			return fmt.Errorf("program ends with a number, which is invalid")
 		}
 	}
@@ -185,59 +738,496 @@ func (c *Compiler) tokenize() error {
 	return nil
 }
 
-// makeinternalform converts our series of tokens (i.e. the lexed expression)
-// into an an intermediary form, collecting constants as they are discovered.
-//
-// This is the middle-step before generating our assembly-language program.
-func (c *Compiler) makeinternalform() {
+// supported returns an error if the given token can't be used in our mode.
+func (c *Compiler) supported(tok token.Token) error {
+	switch tok.Type {
+	case token.AND, token.NOT, token.OR, token.POPCOUNT, token.SHL, token.SHR, token.XOR:
+		if c.mode != "int64" {
+			return fmt.Errorf("'%s' is only supported for 64-bit integers", tok.Literal)
+		}
+	case token.UNIT:
+		if c.mode != "" && c.mode != "float" {
+			return fmt.Errorf("units are only supported for floating-point numbers")
+		}
+	case token.CROSS, token.DOT, token.INTEGRATE, token.LBRACKET, token.LEN, token.NORM, token.RBRACKET, token.SOLVE, token.SUM:
+		if c.mode != "" && c.mode != "float" {
+			return fmt.Errorf("'%s' is only supported for floating-point numbers", tok.Literal)
+		}
+	case token.ARG, token.CONJ, token.I, token.IM, token.POLAR, token.RE:
+		if c.mode != "complex" {
+			return fmt.Errorf("'%s' is only supported for complex numbers", tok.Literal)
+		}
+	case token.COS, token.E, token.EXP, token.LN, token.PI, token.RAND, token.RANDN, token.SIN, token.SQRT, token.TAN:
+		if c.integral() {
+			return fmt.Errorf("'%s' isn't supported for integers", tok.Literal)
+		}
+		if c.mode == "rational" {
+			return fmt.Errorf("'%s' isn't supported for rationals", tok.Literal)
+		}
+	case token.RANDINT:
+		if c.mode == "bignum" {
+			return fmt.Errorf("'%s' isn't supported for big integers", tok.Literal)
+		}
+		if c.mode == "rational" {
+			return fmt.Errorf("'%s' isn't supported for rationals", tok.Literal)
+		}
+	}
 
 	//
-	// Walk our tokens.
+	// Decimals are exact, so they only support the operations whose
+	// results we can round to our decimal places.
 	//
-	for _, t := range c.tokens {
-
-		//
-		// Handle each kind.
-		//
-		switch t.Type {
+	if c.mode == "decimal" {
+		switch tok.Type {
+		case token.COS, token.E, token.EXP, token.FACTORIAL, token.LN, token.PI, token.RAND, token.RANDINT, token.RANDN, token.SIN, token.SQRT, token.TAN:
+			return fmt.Errorf("'%s' isn't supported for decimals", tok.Literal)
+		}
+	}
 
-		case token.ABS:
+	//
+	// Intervals only support the operations which we can bound.
+	//
+	if c.mode == "interval" {
+		switch tok.Type {
+		case token.COS, token.EXP, token.FACTORIAL, token.FLOORMOD, token.LN, token.MOD, token.RAND, token.RANDINT, token.RANDN, token.SIN, token.TAN:
+			return fmt.Errorf("'%s' isn't supported for intervals", tok.Literal)
+		}
+	}
 
-			c.instructions = append(c.instructions,
-				instructions.Instruction{Type: instructions.Abs})
+	//
+	// Complex numbers aren't ordered, so they can't be rounded, or
+	// chosen at random.
+	//
+	if c.mode == "complex" {
+		switch tok.Type {
+		case token.FACTORIAL, token.FLOORMOD, token.MOD, token.RAND, token.RANDINT, token.RANDN:
+			return fmt.Errorf("'%s' isn't supported for complex numbers", tok.Literal)
+		}
+	}
 
-		case token.ASTERISK:
+	//
+	// The factorial of a real number has no simple derivative.
+	//
+	if c.gradient() && tok.Type == token.FACTORIAL {
+		return fmt.Errorf("'%s' can't be differentiated", tok.Literal)
+	}
 
-			c.instructions = append(c.instructions,
-				instructions.Instruction{Type: instructions.Multiply})
+	//
+	// Nor do integrals, or roots, have derivatives we calculate.
+	//
+	if c.gradient() && (tok.Type == token.INTEGRATE || tok.Type == token.SOLVE) {
+		return fmt.Errorf("'%s' can't be differentiated", tok.Literal)
+	}
+	return nil
+}
 
-		case token.FACTORIAL:
+// checkOptions ensures that the options which control the calculations
+// made by the generated program are valid.
+func (c *Compiler) checkOptions() error {
 
-			c.instructions = append(c.instructions,
-				instructions.Instruction{Type: instructions.Factorial})
+	switch c.fpErrors {
+	case "", "trap", "ieee", "saturate":
+	default:
+		return fmt.Errorf("unknown floating-point error policy '%s'", c.fpErrors)
+	}
 
-		case token.COS:
+	switch c.precision {
+	case "", "single", "double", "extended":
+	default:
+		return fmt.Errorf("unknown precision '%s'", c.precision)
+	}
 
-			c.instructions = append(c.instructions,
-				instructions.Instruction{Type: instructions.Cos})
+	switch c.fpu {
+	case "", "x87":
+	case "sse":
+		if c.precision == "extended" {
+			return fmt.Errorf("SSE doesn't support extended precision")
+		}
+	default:
+		return fmt.Errorf("unknown FPU '%s'", c.fpu)
+	}
 
-		case token.DUP:
+	if c.fma && c.fpu != "sse" {
+		return fmt.Errorf("fused multiply-add requires SSE")
+	}
 
-			c.instructions = append(c.instructions,
-				instructions.Instruction{Type: instructions.Dup})
+	switch c.math {
+	case "", "fast", "accurate":
+	default:
+		return fmt.Errorf("unknown math mode '%s'", c.math)
+	}
 
-		case token.NUMBER:
+	switch c.mode {
+	case "", "float":
+	case "int64", "bignum", "rational", "decimal":
+		//
+		// The options for floating-point numbers don't apply, but
+		// we allow their defaults to be given.
+		//
+		if c.precision != "" && c.precision != "double" {
+			return fmt.Errorf("%s values don't have a precision", c.mode)
+		}
+		if c.fpu == "sse" || c.fma || c.math == "accurate" {
+			return fmt.Errorf("%s values are calculated with neither SSE, nor the C library", c.mode)
+		}
+		if c.fpErrors != "" && c.fpErrors != "trap" {
+			return fmt.Errorf("errors with %s values are always trapped", c.mode)
+		}
 
-			// Mark the constant as having been used.
-			c.constants[t.Literal] = true
+		//
+		// Decimals must keep between none, and as many decimal
+		// places as a 64-bit integer can hold.
+		//
+		if c.mode == "decimal" && (c.places < 0 || c.places > 18) {
+			return fmt.Errorf("decimals must keep between 0 and 18 places, for example 'decimal:2'")
+		}
+	case "complex":
+		//
+		// Both parts are doubles, calculated by SSE and the C library.
+		//
+		if c.precision != "" && c.precision != "double" {
+			return fmt.Errorf("complex numbers are always calculated at double precision")
+		}
+		if c.fma {
+			return fmt.Errorf("complex numbers can't use fused multiply-add")
+		}
+		if c.fpErrors == "saturate" {
+			return fmt.Errorf("complex numbers can't be saturated")
+		}
+	case "interval":
+		//
+		// Both bounds are doubles, calculated by the FPU with
+		// directed rounding.
+		//
+		if c.precision != "" && c.precision != "double" {
+			return fmt.Errorf("intervals are always calculated at double precision")
+		}
+		if c.fpu == "sse" || c.fma || c.math == "accurate" {
+			return fmt.Errorf("intervals are calculated by the x87 FPU")
+		}
+		if c.fpErrors == "saturate" {
+			return fmt.Errorf("intervals can't be saturated")
+		}
+	default:
+		return fmt.Errorf("unknown mode '%s'", c.mode)
+	}
 
-			// add the instruction
-			c.instructions = append(c.instructions,
-				instructions.Instruction{Type: instructions.Push, Value: t.Literal})
+	switch c.rounding {
+	case "", "half-even", "half-up", "truncate":
+	default:
+		return fmt.Errorf("unknown rounding '%s'", c.rounding)
+	}
+	if c.rounding != "" && c.mode != "decimal" {
+		return fmt.Errorf("rounding can only be chosen for decimals")
+	}
 
-		case token.MOD:
+	//
+	// Symbolic derivatives are of real functions.
+	//
+	if c.derive != "" {
+		if c.mode != "" && c.mode != "float" {
+			return fmt.Errorf("only floating-point programs can be differentiated")
+		}
+		if !c.isInput(c.derive) {
+			return fmt.Errorf("'%s' isn't a runtime input, so it can't be differentiated by", c.derive)
+		}
+	}
+	if c.infix && c.derive == "" {
+		return fmt.Errorf("only derivatives are written in infix notation")
+	}
 
-			c.instructions = append(c.instructions,
+	//
+	// Derivatives are calculated alongside doubles.
+	//
+	if c.gradient() {
+		if c.mode != "" && c.mode != "float" {
+			return fmt.Errorf("derivatives are only calculated for floating-point numbers")
+		}
+		if c.precision != "" && c.precision != "double" {
+			return fmt.Errorf("derivatives are always calculated at double precision")
+		}
+		if c.fma {
+			return fmt.Errorf("derivatives can't be calculated with fused multiply-add")
+		}
+		if c.fpErrors == "saturate" {
+			return fmt.Errorf("derivatives can't be saturated")
+		}
+		return c.checkGrad()
+	}
+	return nil
+}
+
+// checkOutput ensures that the options which control the output of our
+// results are valid, and don't conflict with each other.
+func (c *Compiler) checkOutput() error {
+
+	if c.digits < 0 {
+		return fmt.Errorf("the number of digits must be positive")
+	}
+	if c.mode == "decimal" && (c.digits != 0 || c.format != "" || c.hex || c.integers) {
+		return fmt.Errorf("decimals are always output with every decimal place")
+	}
+	if c.digits != 0 && c.integral() {
+		return fmt.Errorf("integers are always output with every digit")
+	}
+	if c.mode == "bignum" && (c.format != "" || c.hex) {
+		return fmt.Errorf("big integers are only output in decimal")
+	}
+	if c.mode == "rational" && (c.format != "" || c.hex || c.integers) {
+		return fmt.Errorf("rationals are only output as fractions")
+	}
+	if c.mode == "complex" && c.integers {
+		return fmt.Errorf("complex numbers can't be output as integers")
+	}
+	if c.mode == "interval" && c.integers {
+		return fmt.Errorf("intervals can't be output as integers")
+	}
+	if c.gradient() && c.integers {
+		return fmt.Errorf("derivatives can't be output as integers")
+	}
+	if c.json && c.bare {
+		return fmt.Errorf("JSON output cannot be bare")
+	}
+	if c.unit != "" && c.mode != "" && c.mode != "float" {
+		return fmt.Errorf("units are only supported for floating-point numbers")
+	}
+	if c.unit != "" && !units.Valid(c.unit) {
+		return fmt.Errorf("unknown output unit '%s'", c.unit)
+	}
+	if c.exitResult && (c.stream || c.printAll) {
+		return fmt.Errorf("the exit-code can only be set from a single result")
+	}
+	if c.tabulated() && (c.printAll || c.exitResult) {
+		return fmt.Errorf("a table can only contain a single result per row")
+	}
+	if c.tabulated() && c.json {
+		return fmt.Errorf("tables can't be written as JSON")
+	}
+	if c.csv && !c.tabulated() {
+		return fmt.Errorf("only tables can be output as CSV")
+	}
+	if c.csv && c.gradient() {
+		return fmt.Errorf("derivatives can't be output as CSV")
+	}
+
+	if c.format == "" {
+		return nil
+	}
+
+	if c.json {
+		return fmt.Errorf("a custom format cannot be used for JSON output")
+	}
+	if c.digits != 0 || c.hex {
+		return fmt.Errorf("a custom format cannot be combined with digits, or hex output")
+	}
+
+	//
+	// There must be exactly one conversion in the format, and
+	// it must be for a double - or an integer.
+	//
+	pos, err := conversions(c.format, c.mode == "int64")
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return fmt.Errorf("the format '%s' must contain exactly one conversion", c.format)
+	}
+	return nil
+}
+
+// conversions returns the position of the conversion-character of each
+// conversion in the given printf-format, for example the "f" of "%.3f".
+//
+// An error is returned if any conversion isn't for a floating-point number,
+// or an integer if integer is true.
+func conversions(format string, integer bool) ([]int, error) {
+	kinds := "aAeEfFgG"
+	kind := "a floating-point number"
+	if integer {
+		kinds = "diouxX"
+		kind = "an integer"
+	}
+
+	pos := []int{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+
+		// "%%" is a literal percent-sign.
+		if i < len(format) && format[i] == '%' {
+			continue
+		}
+
+		// skip flags, width, and precision
+		for i < len(format) && strings.ContainsRune("-+ #0123456789.", rune(format[i])) {
+			i++
+		}
+
+		// "%lf" is the same as "%f", and "%ld" is what we use for "%d".
+		if i < len(format) && format[i] == 'l' {
+			i++
+		}
+
+		if i >= len(format) || !strings.ContainsRune(kinds, rune(format[i])) {
+			return nil, fmt.Errorf("the format '%s' contains a conversion which isn't for %s", format, kind)
+		}
+		pos = append(pos, i)
+	}
+	return pos, nil
+}
+
+// makeinternalform converts our series of tokens (i.e. the lexed expression)
+// into an an intermediary form, collecting constants as they are discovered.
+//
+// This is the middle-step before generating our assembly-language program.
+func (c *Compiler) makeinternalform() {
+
+	//
+	// The instructions of a quotation are collected in place of those
+	// of the program, or quotation, which encloses it - so we keep
+	// those here, along with the quotations which are yet to be used.
+	//
+	outer := [][]instructions.Instruction{}
+	pending := []int{}
+
+	//
+	// Walk our tokens.
+	//
+	for i, t := range c.tokens {
+
+		//
+		// Handle each kind.
+		//
+		switch t.Type {
+
+		case token.ABS:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Abs})
+
+		case token.AND:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.And})
+
+		case token.ARG:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Arg})
+
+		case token.ASTERISK:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Multiply})
+
+		case token.FACTORIAL:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Factorial})
+
+		case token.CONJ:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Conj})
+
+		case token.COS:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Cos})
+
+		case token.CROSS:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Cross})
+
+		case token.DOT:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Dot})
+
+		case token.DUP:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Dup})
+
+		case token.EXP:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Exp})
+
+		case token.NUMBER:
+
+			// Mark the constant as having been used.
+			c.constants[t.Literal] = true
+
+			// add the instruction
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Push, Value: t.Literal})
+
+		case token.FLOORMOD:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.FlooredModulus})
+
+		case token.IDENT:
+
+			// Within a quotation "x" is its variable.
+			if t.Literal == "x" && len(outer) > 0 {
+				c.instructions = append(c.instructions,
+					instructions.Instruction{Type: instructions.Variable})
+				break
+			}
+
+			// Work out which argument this is.
+			slot := c.inputSlot(t.Literal)
+			if slot > c.arguments {
+				c.arguments = slot
+			}
+
+			// add the instruction
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Input, Value: fmt.Sprintf("%d", slot)})
+
+		case token.IM:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Imaginary})
+
+		case token.INTEGRATE:
+
+			// We integrate the most recent quotation.
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Integrate, Value: fmt.Sprintf("%d", pending[len(pending)-1])})
+			pending = pending[:len(pending)-1]
+
+		case token.LABEL:
+
+			// The label is the string which preceded us, which
+			// we'll store in the data-area.
+			c.labelID(c.tokens[i-1].Literal)
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Label, Value: c.tokens[i-1].Literal})
+
+		case token.LBRACKET:
+
+			outer = append(outer, c.instructions)
+			c.instructions = nil
+
+		case token.LEN:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Length})
+
+		case token.LN:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Ln})
+
+		case token.MOD:
+
+			c.instructions = append(c.instructions,
 				instructions.Instruction{Type: instructions.Modulus})
 
 		case token.MINUS:
@@ -245,17 +1235,92 @@ func (c *Compiler) makeinternalform() {
 			c.instructions = append(c.instructions,
 				instructions.Instruction{Type: instructions.Minus})
 
+		case token.NORM:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Norm})
+
+		case token.NOT:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Not})
+
+		case token.OR:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Or})
+
 		case token.PLUS:
 
 			c.instructions = append(c.instructions,
 				instructions.Instruction{Type: instructions.Plus})
 
+		case token.PRINT:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Print})
+
+		case token.PRINTSTACK:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.PrintStack})
+
+		case token.POLAR:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Polar})
+
+		case token.POPCOUNT:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.PopCount})
+
 		case token.POWER:
 
 			// add the instruction
 			c.instructions = append(c.instructions,
 				instructions.Instruction{Type: instructions.Power})
 
+		case token.RAND:
+
+			c.random = true
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Rand})
+
+		case token.RANDINT:
+
+			c.random = true
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.RandInt})
+
+		case token.RANDN:
+
+			c.random = true
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.RandNormal})
+
+		case token.RBRACKET:
+
+			c.quotations = append(c.quotations, c.instructions)
+			pending = append(pending, len(c.quotations)-1)
+			c.instructions = outer[len(outer)-1]
+			outer = outer[:len(outer)-1]
+
+		case token.RE:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Real})
+
+		case token.SHL:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.ShiftLeft})
+
+		case token.SHR:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.ShiftRight})
+
 		case token.SIN:
 
 			c.instructions = append(c.instructions,
@@ -266,79 +1331,438 @@ func (c *Compiler) makeinternalform() {
 			c.instructions = append(c.instructions,
 				instructions.Instruction{Type: instructions.Divide})
 
+		case token.SOLVE:
+
+			// We solve the most recent quotation.
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Solve, Value: fmt.Sprintf("%d", pending[len(pending)-1])})
+			pending = pending[:len(pending)-1]
+
 		case token.SQRT:
 
 			c.instructions = append(c.instructions,
 				instructions.Instruction{Type: instructions.Sqrt})
 
+		case token.SUM:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Sum})
+
 		case token.SWAP:
 
 			c.instructions = append(c.instructions,
 				instructions.Instruction{Type: instructions.Swap})
 
-		case token.TAN:
+		case token.TAN:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Tan})
+
+		case token.UNIT:
+
+			// The value before us is converted to SI base units,
+			// by multiplying it by the size of the unit.
+			scale := unitScale(t.Literal)
+			if scale != "1" {
+				c.constants[scale] = true
+				c.instructions = append(c.instructions,
+					instructions.Instruction{Type: instructions.Push, Value: scale},
+					instructions.Instruction{Type: instructions.Multiply})
+			}
+
+		case token.VECTOR:
+
+			// The elements are stored along with the instruction.
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Vector, Value: t.Literal})
+
+		case token.XOR:
+
+			c.instructions = append(c.instructions,
+				instructions.Instruction{Type: instructions.Xor})
+
+		}
+	}
+
+	//
+	// Fuse multiplications with the additions, or subtractions, which
+	// follow them.
+	//
+	if c.fma {
+		c.instructions = fuse(c.instructions)
+		for i, quotation := range c.quotations {
+			c.quotations[i] = fuse(quotation)
+		}
+	}
+}
+
+// fuse replaces each multiplication which is immediately followed by an
+// addition, or a subtraction, with a single fused instruction.
+func fuse(program []instructions.Instruction) []instructions.Instruction {
+	fused := []instructions.Instruction{}
+
+	for i := 0; i < len(program); i++ {
+		if program[i].Type == instructions.Multiply && i+1 < len(program) {
+			switch program[i+1].Type {
+			case instructions.Plus:
+				fused = append(fused, instructions.Instruction{Type: instructions.FusedMultiplyAdd})
+				i++
+				continue
+			case instructions.Minus:
+				fused = append(fused, instructions.Instruction{Type: instructions.FusedMultiplySubtract})
+				i++
+				continue
+			}
+		}
+		fused = append(fused, program[i])
+	}
+	return fused
+}
+
+// inputSlot returns the (one-based) position upon the command-line of
+// the runtime input with the given name.
+//
+// The name will either be of the form "$N", or one that was declared
+// via SetInputs - the lexer won't give us anything else.
+func (c *Compiler) inputSlot(name string) int {
+	if strings.HasPrefix(name, "$") {
+		n, _ := strconv.Atoi(name[1:])
+		return n
+	}
+	for i, known := range c.inputs {
+		if known == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// isInput returns true if the given name is that of a runtime input,
+// either "$N", or one that was declared via SetInputs.
+func (c *Compiler) isInput(name string) bool {
+	for _, input := range c.inputs {
+		if input == name {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, "$") && len(name) > 1 && strings.Trim(name[1:], "0123456789") == "" && c.inputSlot(name) > 0 && c.inputSlot(name) <= maxInputs
+}
+
+// inputName returns the name that should be shown, in the usage-message of
+// the generated program, for the runtime input at the given position.
+func (c *Compiler) inputName(slot int) string {
+	if slot <= len(c.inputs) {
+		return c.inputs[slot-1]
+	}
+	return fmt.Sprintf("$%d", slot)
+}
+
+// labelID returns the identifier of the given label, which is used to
+// find its text in our data-area.
+func (c *Compiler) labelID(text string) int {
+	for i, known := range c.labels {
+		if known == text {
+			return i
+		}
+	}
+	c.labels = append(c.labels, text)
+	return len(c.labels) - 1
+}
+
+// escapeString escapes the given text so that it may be used within
+// a string in our generated assembly.
+//
+// Control characters are written as escapes, in octal unless they've a
+// shorter form, as they'd otherwise end the line the string is on.
+func escapeString(text string) string {
+	out := ""
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case ch == '\\' || ch == '"':
+			out += "\\" + text[i:i+1]
+		case ch == '\n':
+			out += "\\n"
+		case ch == '\t':
+			out += "\\t"
+		case ch < ' ' || ch == 0x7F:
+			out += fmt.Sprintf("\\%03o", ch)
+		default:
+			out += text[i : i+1]
+		}
+	}
+	return out
+}
+
+// validName returns true if the given string is suitable for use as the
+// name of a runtime input.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, ch := range name {
+		if ch == '_' || unicode.IsLetter(ch) {
+			continue
+		}
+		if i > 0 && unicode.IsDigit(ch) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// output generates the output, joining a header, a footer, and the
+// writes our program to stdout
+func (c *Compiler) output() string {
+
+	//
+	// The header.
+	//
+	header := `
+#
+# This assembly file was created by math-compiler.
+#
+# We're going to use Intel Syntax, because that is what I grew up with.
+#
+.intel_syntax noprefix
+.global main
+
+# Data-section:
+#
+# This contains data for the program at run-time.
+#
+#    int: used to convert values to, and from, integers.
+#
+#      a: used as an argument for functions that require one/two operands.
+#
+#      b: used as an argument for functions that require two operands.
+#
+#  depth: used to keep track of stack-depth.
+#
+# status: the exit-code of the program, if it runs to completion.
+#
+#   half: the constant 0.5, which is used when raising to a power.
+#
+# fpu_cw: the FPU control-word, which sets our working precision.
+#
+# fpu_ext: the FPU control-word for extended precision, which is used
+#          to raise values to whole powers.
+#
+#  mxcsr: used to access the SSE control/status register.
+#
+# The strings are used for various error-reports.
+#
+.data
+          a: #SCRATCH
+          b: #SCRATCH
+      depth: .double 0.0
+        int: .double 0.0
+     status: .quad 0
+       half: .double 0.5
+     fpu_cw: .word #FPU_CW
+    fpu_ext: .word 0x037F
+      mxcsr: .long 0
+
+   div_zero: .asciz "Attempted division by zero.  Aborting\n"
+   overflow: .asciz "Overflow - value out of range.  Aborting\n"
+ domain_err: .asciz "Domain error - invalid argument.  Aborting\n"
+  stack_err: .asciz "Insufficient entries on the stack.  Aborting\n"
+ stack_full: .asciz "Too many entries remaining on the stack.  Aborting\n"
+`
+
+	//
+	// The FPU control-word masks every exception, and sets the
+	// precision-control bits to match our working precision.
+	//
+	// Integers are only converted by the FPU when generating random
+	// numbers, which requires every bit of their precision.
+	//
+	switch {
+	case c.precision == "single":
+		header = strings.Replace(header, "#FPU_CW", "0x007F", -1)
+	case c.precision == "extended" || c.mode == "int64":
+		header = strings.Replace(header, "#FPU_CW", "0x037F", -1)
+	default:
+		header = strings.Replace(header, "#FPU_CW", "0x027F", -1)
+	}
+
+	//
+	// The scratch values hold a value from our stack, along with its
+	// derivatives, if any.
+	//
+	scratch := ".octa 0"
+	if c.gradient() {
+		scratch = fmt.Sprintf(".fill %d, 8, 0", len(c.grad)+1)
+	}
+	header = strings.Replace(header, "#SCRATCH", scratch, -1)
+
+	//
+	// The largest finite value, which infinite results are clamped to.
+	//
+	if c.fpErrors == "saturate" {
+		switch c.precision {
+		case "single":
+			header += "     fp_max: .long 0x7F7FFFFF\n"
+		case "extended":
+			header += "     fp_max: .quad 0xFFFFFFFFFFFFFFFF\n             .short 0x7FFE\n"
+		default:
+			header += "     fp_max: .quad 0x7FEFFFFFFFFFFFFF\n"
+		}
+	}
+
+	//
+	// The size of our output unit, which values are divided by.
+	//
+	if c.unit != "" {
+		header += fmt.Sprintf(" unit_scale: .double %s\n", unitScale(c.unit))
+	}
+
+	//
+	// The strings used to print our results.
+	//
+	header += c.genOutputData()
+
+	//
+	// Output each of our discovered constants.
+	//
+	// Big integers are stored as their digits, which are converted
+	// when they're pushed, rationals as their numerator followed
+	// by their denominator, complex numbers as their real part
+	// followed by their imaginary part, intervals as their bounds, and
+	// decimals as scaled integers.
+	//
+	for v := range c.constants {
+		if c.mode == "bignum" {
+			header += fmt.Sprintf("%s: .asciz \"%s\"\n",
+				c.escapeConstant(v), v)
+			continue
+		}
+		if c.mode == "rational" {
+			n, d, _ := rational(v)
+			header += fmt.Sprintf("%s: .quad %d, %d\n",
+				c.escapeConstant(v), n, d)
+			continue
+		}
+		if c.mode == "complex" {
+			header += c.complexConstant(v)
+			continue
+		}
+		if c.mode == "interval" {
+			header += c.intervalConstant(v)
+			continue
+		}
+		if c.mode == "decimal" {
+			n, _ := decimal(v, c.places)
+			header += fmt.Sprintf("%s: .quad %d\n",
+				c.escapeConstant(v), n)
+			continue
+		}
+		header += fmt.Sprintf("%s: #REAL %s\n",
+			c.escapeConstant(v), v)
+
+		// Constants don't depend upon our inputs.
+		if c.gradient() {
+			header += fmt.Sprintf("             .fill %d, 8, 0\n", len(c.grad))
+		}
+	}
+
+	//
+	// The strings used by the runtime for big integers.
+	//
+	if c.mode == "bignum" {
+		header += c.genBignumData()
+	}
+
+	//
+	// The storage used by the runtime for rationals.
+	//
+	if c.mode == "rational" {
+		header += c.genRationalData()
+	}
+
+	//
+	// The storage used by the runtime for complex numbers.
+	//
+	if c.mode == "complex" {
+		header += c.genComplexData()
+	}
+
+	//
+	// The storage used by the runtime for intervals.
+	//
+	if c.mode == "interval" {
+		header += c.genIntervalData()
+	}
+
+	//
+	// The strings used to print decimals.
+	//
+	if c.mode == "decimal" {
+		header += c.genDecimalData()
+	}
 
-			c.instructions = append(c.instructions,
-				instructions.Instruction{Type: instructions.Tan})
+	//
+	// The storage used to calculate, and print, derivatives.
+	//
+	if c.gradient() {
+		header += c.genDualData()
+	}
 
-		}
+	//
+	// The constants used to integrate, and solve, our quotations.
+	//
+	if len(c.quotations) > 0 {
+		header += c.genQuotationData()
 	}
 
-}
+	//
+	// Output the text of each of our labels.
+	//
+	for i, v := range c.labels {
 
-// output generates the output, joining a header, a footer, and the
-// writes our program to stdout
-func (c *Compiler) output() string {
+		// Labels are embedded in JSON strings, which need escaping.
+		if c.json {
+			v = strings.Replace(v, "\\", "\\\\", -1)
+		}
+		header += fmt.Sprintf("label_%d: .asciz \"%s\"\n", i, escapeString(v))
+	}
 
 	//
-	// The header.
+	// The state of our random number generator.
 	//
-	header := `
-#
-# This assembly file was created by math-compiler.
-#
-# We're going to use Intel Syntax, because that is what I grew up with.
-#
-.intel_syntax noprefix
-.global main
+	if c.random {
+		header += c.genRandomData()
+	}
 
-# Data-section:
-#
-# This contains data for the program at run-time.
-#
-#    int: is used to push values onto the stack.
-#
-#      a: used as an argument for functions that require one/two operands.
-#
-#      b: used as an argument for functions that require two operands.
-#
-#  depth: used to keep track of stack-depth.
-#
-#    fmt: Used to output the result of the calculation, later strings are for
-#         various error-reports.
-#
-.data
-          a: .double 0.0
-          b: .double 0.0
-      depth: .double 0.0
-        int: .double 0.0
+	//
+	// If we have runtime inputs we need somewhere to store them, along
+	// with the messages to show if they're missing or bogus.
+	//
+	if c.arguments > 0 || c.stream {
+		header += c.genInputData()
+	}
 
-        fmt: .asciz "Result %g\n"
-   div_zero: .asciz "Attempted division by zero.  Aborting\n"
-   overflow: .asciz "Overflow - value out of range.  Aborting\n"
-  stack_err: .asciz "Insufficient entries on the stack.  Aborting\n"
- stack_full: .asciz "Too many entries remaining on the stack.  Aborting\n"
-`
+	//
+	// The ranges of our inputs, if we're printing a table.
+	//
+	if c.tabulated() {
+		header += c.genTableData()
+	}
 
 	//
-	// Output each of our discovered constants.
+	// The elements of our vectors.
 	//
-	for v := range c.constants {
-		header += fmt.Sprintf("%s: .double %s\n",
-			c.escapeConstant(v), v)
+	if c.vectors() {
+		header += c.genVectorData()
 	}
 
 	header += `
+#
+# Code-section:
+#
+.text
+
 #
 # Main is our entry-point.
 #
@@ -357,12 +1781,39 @@ main:
         #
         mov qword ptr [depth], 0
 
+        # Set the precision of the FPU.
+        fldcw word ptr [fpu_cw]
+
 `
 	if c.debug {
 		header += "        # Debug-break\n"
 		header += "        int 03\n"
 	}
 
+	//
+	// Seed our random number generator, if we need it.
+	//
+	if c.random {
+		header += c.genSeed()
+	}
+
+	//
+	// Read the runtime inputs, if any, from the command-line - or
+	// start reading records from STDIN.
+	//
+	if c.stream {
+		header += c.genStream()
+	} else if c.arguments > 0 {
+		header += c.genArguments()
+	}
+
+	//
+	// Begin each row of our table, if we're printing one.
+	//
+	if c.tabulated() {
+		header += c.genTable()
+	}
+
 	//
 	// The body of the program
 	//
@@ -371,75 +1822,124 @@ main:
 	// Now we walk over our internal-representation, and output
 	// a chunk of assembly for each of our operator-types.
 	for i, opr := range c.instructions {
-
-		//
-		// One-handler for each type: Alphabetical order.
-		//
-		switch opr.Type {
-
-		case instructions.Abs:
-			body += c.genAbs()
-
-		case instructions.Cos:
-			body += c.genCos()
-
-		case instructions.Divide:
-			body += c.genDivide()
-
-		case instructions.Dup:
-			body += c.genDup()
-
-		case instructions.Factorial:
-			body += c.genFactorial(i)
-
-		case instructions.Minus:
-			body += c.genMinus()
-
-		case instructions.Modulus:
-			body += c.genModulus()
-
-		case instructions.Multiply:
-			body += c.genMultiply()
-
-		case instructions.Plus:
-			body += c.genPlus()
-
-		case instructions.Power:
-			body += c.genPower(i)
-
-		case instructions.Push:
-			body += c.genPush(opr.Value)
-
-		case instructions.Sin:
-			body += c.genSin()
-
-		case instructions.Sqrt:
-			body += c.genSqrt()
-
-		case instructions.Swap:
-			body += c.genSwap()
-
-		case instructions.Tan:
-			body += c.genTan()
-
-		}
+		body += c.genInstruction(opr, i)
 	}
 
 	footer := `
         # [PRINT]
-        # ensure there is only one remaining argument upon the stack
+        # If the program printed everything itself there's nothing left.
         mov rax, qword ptr [depth]
+        cmp rax, 0
+        je print_done
+`
+
+	if c.printAll {
+		footer += c.genPrintAll()
+	} else if c.resultLength > 0 {
+		footer += fmt.Sprintf(`
+        # ensure there is only one remaining argument upon the stack
         cmp rax, 1
         jne stack_too_full      # should be only one entry.
-        # print the result
+        # print the result, which is a vector
         pop rax
-        mov qword ptr [a], rax
-        lea rdi,fmt             # format string
-        movq xmm0, [a]          # argument
-        movq rax, 1             # argument count
-        call printf
-        pop rbp
-        xor rax,rax
+        mov rcx, %d
+        lea rsi,result
+        call print_vector
+`, c.resultLength)
+	} else {
+		footer += `
+        # ensure there is only one remaining argument upon the stack
+        cmp rax, 1
+        jne stack_too_full      # should be only one entry.
+        # print the result
+        #POP a
+`
+		if c.exitResult && c.mode == "bignum" {
+			footer += `
+        # the result is our exit-code
+        mov rdi, qword ptr [a]
+        call big_to_int
+        mov qword ptr [status], rax
+`
+		} else if c.exitResult && c.mode == "rational" {
+			footer += `
+        # the integer part of the result is our exit-code
+        mov rax, qword ptr [a]
+        cqo
+        idiv qword ptr [a + 8]
+        mov qword ptr [status], rax
+`
+		} else if c.exitResult && c.mode == "decimal" {
+			footer += fmt.Sprintf(`
+        # the integer part of the result is our exit-code
+        mov rax, qword ptr [a]
+        cqo
+        mov rcx, %d
+        idiv rcx
+        mov qword ptr [status], rax
+`, c.scale())
+		} else if c.exitResult && c.mode == "int64" {
+			footer += `
+        # the result is our exit-code
+        mov rax, qword ptr [a]
+        mov qword ptr [status], rax
+`
+		} else if c.exitResult && c.unit != "" {
+			footer += `
+        # the integer part of the result, in our output unit, is our
+        # exit-code
+        fld #PTR [a]
+        fdiv qword ptr [unit_scale]
+        fisttp qword ptr [int]
+        mov rax, qword ptr [int]
+        mov qword ptr [status], rax
+`
+		} else if c.exitResult {
+			footer += `
+        # the integer part of the result is our exit-code
+        fld #PTR [a]
+        fisttp qword ptr [int]
+        mov rax, qword ptr [int]
+        mov qword ptr [status], rax
+`
+		}
+		footer += `
+        #LOAD [a]
+        lea rsi,result
+        call print_value
+`
+	}
+
+	footer += `
+print_done:
+`
+
+	//
+	// If we're printing a table we go on to its next row, until every
+	// value of our ranges has been used.
+	//
+	if c.tabulated() {
+		footer += c.genNextRow()
+	}
+
+	//
+	// If we're reading records we go on to process the next one,
+	// rather than terminating.
+	//
+	if c.stream {
+		footer += `
+        # process the next record
+        jmp next_record
+
+#
+# This is hit when there are no more records to process.
+#
+end_of_input:
+`
+	}
+
+	footer += `        pop rbp
+        mov rax, qword ptr [status]
         ret
 
 
@@ -448,6 +1948,7 @@ main:
 #
 division_by_zero:
         lea rdi,div_zero
+        mov rdx, 2              # exit-code
         jmp print_msg_and_exit
 
 #
@@ -455,8 +1956,28 @@ division_by_zero:
 #
 register_overflow:
         lea rdi,overflow
+        mov rdx, 3              # exit-code
+        jmp print_msg_and_exit
+
+#
+# This is hit when an operation is given a value it can't handle, such
+# as the square-root of a negative number.
+#
+domain_error:
+        lea rdi,domain_err
+        mov rdx, 6              # exit-code
         jmp print_msg_and_exit
 
+#
+# This is hit when a floating-point exception is raised, the status-word
+# is in ax.  We report it as the matching error.
+#
+fp_exception:
+        test al, 4              # zero-divide
+        jnz division_by_zero
+        test al, 8              # overflow
+        jnz register_overflow
+        jmp domain_error        # invalid operation
 
 #
 # This point is hit when the program is due to terminate, but the
@@ -464,6 +1985,7 @@ register_overflow:
 #
 stack_too_full:
         lea rdi,stack_full
+        mov rdx, 5              # exit-code
         jmp print_msg_and_exit
 
 #
@@ -473,21 +1995,292 @@ stack_too_full:
 #
 stack_error:
         lea rdi,stack_err
+        mov rdx, 4              # exit-code
         # jmp print_msg_and_exit - JMP is unnecessary here.
 
 #
-# Print a message and terminate.
+# Print a message to STDERR and terminate.
+#
+# On entry rdi points to the message, rsi contains any argument it
+# requires, and rdx contains the exit-code.
 #
 # NOTE: We call 'exit' here to allow stdout to be flushed, and also to ensure
-#       we don't need to balance our stack.
+#       we don't need to balance our stack - which we align for fprintf.
 #
 print_msg_and_exit:
+#ROW_FAILED
+        mov rbx, rdx            # exit-code
+        mov rdx, rsi            # argument
+        mov rsi, rdi            # message
+        mov rdi, qword ptr [stderr]
+        and rsp, -16
         xor rax,rax
-        call printf
-        mov rdi,0
+        call fprintf
+        mov rdi, rbx
         call exit
 
 `
 
-	return header + body + footer
+	//
+	// Once our table has begun an error only ends the row which hit it.
+	//
+	failed := ""
+	if c.tabulated() {
+		failed = `        # once our table has begun, a failure only ends its row
+        cmp qword ptr [row_rsp], 0
+        jne row_failed
+`
+	}
+	footer = strings.Replace(footer, "#ROW_FAILED\n", failed, -1)
+
+	//
+	// The helpers for printing values.
+	//
+	footer += c.genPrintHelpers()
+
+	//
+	// The helpers for calling the C library.
+	//
+	if c.useLibrary() || c.gradient() {
+		footer += c.genLibraryHelpers()
+	}
+
+	//
+	// The runtime for big integers.
+	//
+	if c.mode == "bignum" {
+		footer += c.genBignumHelpers()
+	}
+
+	//
+	// The runtime for rationals.
+	//
+	if c.mode == "rational" {
+		footer += c.genRationalHelpers()
+	}
+
+	//
+	// The runtime for complex numbers.
+	//
+	if c.mode == "complex" {
+		footer += c.genComplexHelpers()
+	}
+
+	//
+	// The runtime for intervals.
+	//
+	if c.mode == "interval" {
+		footer += c.genIntervalHelpers()
+	}
+
+	//
+	// The runtime for decimals.
+	//
+	if c.mode == "decimal" {
+		footer += c.genDecimalHelpers()
+	}
+
+	//
+	// The helpers for printing derivatives.
+	//
+	if c.gradient() {
+		footer += c.genDualHelpers()
+	}
+
+	//
+	// Our quotations, and the drivers which call them.
+	//
+	if len(c.quotations) > 0 {
+		footer += c.genQuotations(len(c.instructions))
+		footer += c.genQuotationHelpers()
+	}
+
+	//
+	// The helper for printing the columns of our table.
+	//
+	if c.tabulated() {
+		footer += c.genTableHelpers()
+	}
+
+	//
+	// The helpers for printing, and calculating, vectors.
+	//
+	if c.vectors() {
+		footer += c.genVectorHelpers()
+	}
+
+	//
+	// The helper for clamping infinite results.
+	//
+	if c.fpErrors == "saturate" {
+		footer += c.genSaturateHelpers()
+	}
+
+	//
+	// The helpers for generating random numbers.
+	//
+	if c.random {
+		footer += c.genRandomHelpers()
+	}
+
+	//
+	// The helpers for reading our runtime inputs.
+	//
+	if c.stream {
+		footer += c.genStreamHelpers()
+	} else if c.arguments > 0 {
+		footer += c.genInputHelpers()
+	}
+
+	return c.precise(header + body + footer)
+}
+
+// genInstruction generates the assembly code for a single instruction of
+// our program, or of a quotation.  The index i is unique to the
+// instruction, so that the labels it uses are too.
+func (c *Compiler) genInstruction(opr instructions.Instruction, i int) string {
+	text := ""
+
+	//
+	// Integers have their own handlers.
+	//
+	if c.mode == "int64" {
+		return c.genInteger(opr, i)
+	}
+	if c.mode == "bignum" {
+		return c.genBignum(opr, i)
+	}
+	if c.mode == "decimal" {
+		return c.genDecimal(opr, i)
+	}
+
+	//
+	// As do rationals.
+	//
+	if c.mode == "rational" {
+		return c.genRational(opr)
+	}
+	if c.mode == "complex" {
+		return c.genComplex(opr)
+	}
+	if c.mode == "interval" {
+		return c.genInterval(opr)
+	}
+
+	//
+	// Vectors are calculated with packed instructions.
+	//
+	if c.vectored(opr, i) {
+		return c.genVector(opr, i)
+	}
+
+	//
+	// Derivatives are calculated after each value, from a copy
+	// of its operands.
+	//
+	if c.gradient() {
+		text += c.genOperands(opr)
+	}
+
+	//
+	// One-handler for each type: Alphabetical order.
+	//
+	switch opr.Type {
+
+	case instructions.Abs:
+		text += c.genAbs()
+
+	case instructions.Cos:
+		text += c.genCos()
+
+	case instructions.Divide:
+		text += c.genDivide()
+
+	case instructions.Dup:
+		text += c.genDup()
+
+	case instructions.Exp:
+		text += c.genExp()
+
+	case instructions.Factorial:
+		text += c.genFactorial(i)
+
+	case instructions.FusedMultiplyAdd:
+		text += c.genFusedMultiply("add")
+
+	case instructions.FusedMultiplySubtract:
+		text += c.genFusedMultiply("subtract")
+
+	case instructions.Input:
+		text += c.genInput(opr.Value)
+
+	case instructions.Integrate:
+		text += c.genDriver("integrate", opr.Value)
+
+	case instructions.Label:
+		text += c.genLabel(opr.Value)
+
+	case instructions.Ln:
+		text += c.genLn()
+
+	case instructions.Minus:
+		text += c.genMinus()
+
+	case instructions.FlooredModulus:
+		text += c.genFlooredModulus(i)
+
+	case instructions.Modulus:
+		text += c.genModulus(i)
+
+	case instructions.Multiply:
+		text += c.genMultiply()
+
+	case instructions.Plus:
+		text += c.genPlus()
+
+	case instructions.Power:
+		text += c.genPower(i)
+
+	case instructions.Print:
+		text += c.genPrint()
+
+	case instructions.PrintStack:
+		text += c.genPrintStack()
+
+	case instructions.Push:
+		text += c.genPush(opr.Value)
+
+	case instructions.Rand:
+		text += c.genRand()
+
+	case instructions.RandInt:
+		text += c.genRandInt()
+
+	case instructions.RandNormal:
+		text += c.genRandNormal()
+
+	case instructions.Sin:
+		text += c.genSin()
+
+	case instructions.Solve:
+		text += c.genDriver("solve", opr.Value)
+
+	case instructions.Sqrt:
+		text += c.genSqrt()
+
+	case instructions.Swap:
+		text += c.genSwap()
+
+	case instructions.Tan:
+		text += c.genTan()
+
+	case instructions.Variable:
+		text += c.genVariable()
+
+	}
+
+	if c.gradient() {
+		text += c.genDual(opr)
+	}
+	return text
 }
@@ -1,6 +1,12 @@
 package compiler
 
 import (
+	"bytes"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -24,6 +30,12 @@ func TestBogusInput(t *testing.T) {
 
 		// Again
 		"3 4 + 3",
+
+		// strings are only valid as labels
+		"3 \"area\"",
+		"3 \"area\" +",
+		"3 .label",
+		"\"area\" .label",
 	}
 
 	for _, test := range tests {
@@ -44,7 +56,10 @@ func TestValidPrograms(t *testing.T) {
 		"5 7 *",
 		"9 3 /",
 		"10 5 %",
+		"-10 3 mod",
 		"2 8 ^",
+		"2 0.5 ^",
+		"-2 -3 ^",
 		"3 sin",
 		"4 cos",
 		"5 tan",
@@ -83,7 +98,10 @@ func TestValidProgramsShortcut(t *testing.T) {
 		"5 7 *",
 		"9 3 /",
 		"10 5 %",
+		"-10 3 mod",
 		"2 8 ^",
+		"2 0.5 ^",
+		"-2 -3 ^",
 		"3 sin",
 		"4 cos",
 		"5 tan",
@@ -181,3 +199,1286 @@ func TestDebug(t *testing.T) {
 		t.Errorf("Debug trap not found!")
 	}
 }
+
+// Test that runtime inputs are handled.
+func TestInputs(t *testing.T) {
+
+	tests := []struct {
+		input     string
+		names     []string
+		arguments int
+	}{
+		{"$1 2 *", nil, 1},
+		{"$3 $1 +", nil, 3},
+		{"x y +", []string{"x", "y"}, 2},
+		{"y 2 *", []string{"x", "y"}, 2},
+		{"x1 $3 -", []string{"x1"}, 3},
+	}
+
+	for _, test := range tests {
+
+		c := New(test.input)
+		c.SetInputs(test.names)
+
+		out, err := c.Compile()
+		if err != nil {
+			t.Errorf("Unexpected error compiling program: %s", err.Error())
+		}
+		if c.arguments != test.arguments {
+			t.Errorf("Expected %d arguments for '%s', got %d", test.arguments, test.input, c.arguments)
+		}
+		if !strings.Contains(out, "call strtod") {
+			t.Errorf("Our generated program doesn't parse its inputs")
+		}
+	}
+}
+
+// Test that bogus runtime inputs are rejected.
+func TestBogusInputs(t *testing.T) {
+
+	tests := []struct {
+		input string
+		names []string
+	}{
+		// undeclared
+		{"x 2 *", nil},
+		{"x y +", []string{"y"}},
+
+		// zero isn't an input, however it is written
+		{"$0 2 *", nil},
+		{"$00 2 +", nil},
+
+		// nor are inputs beyond our limit
+		{"$1001 1 +", nil},
+		{"$99999999999999999999 1 +", nil},
+
+		// invalid names
+		{"1 2 +", []string{"sin"}},
+		{"1 2 +", []string{"m"}},
+		{"1 2 +", []string{"1x"}},
+		{"1 2 +", []string{""}},
+		{"1 2 +", []string{"x", "x"}},
+
+		// programs can't end with an input
+		{"1 2 + x", []string{"x"}},
+	}
+
+	for _, test := range tests {
+		c := New(test.input)
+		c.SetInputs(test.names)
+		err := c.tokenize()
+		if err == nil {
+			t.Errorf("We expected an error handling '%s', but got none!", test.input)
+		}
+	}
+}
+
+// Test that streaming-mode reads records from STDIN.
+func TestStream(t *testing.T) {
+
+	c := New("$1 $2 +")
+	c.SetStream(true)
+
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling program: %s", err.Error())
+	}
+	if !strings.Contains(out, "call getline") {
+		t.Errorf("Our generated program doesn't read STDIN")
+	}
+	if strings.Contains(out, "usage_error") {
+		t.Errorf("Our generated program reads its command-line")
+	}
+	if !strings.Contains(out, "blank_record") {
+		t.Errorf("Our generated program doesn't skip blank records")
+	}
+}
+
+// Test that the printing words are handled.
+func TestPrinting(t *testing.T) {
+
+	tests := []string{
+		"3 .",
+		"1 2 .s +",
+		"3 4 * \"area\" .label",
+		"3 \"a\" .label 4 \"b\" .label 5 \"a\" .label",
+	}
+
+	for _, test := range tests {
+
+		c := New(test)
+		out, err := c.Compile()
+		if err != nil {
+			t.Errorf("Unexpected error compiling program: %s", err.Error())
+		}
+		if !strings.Contains(out, "call print_") {
+			t.Errorf("Our generated program doesn't print anything")
+		}
+	}
+
+	// Labels are only stored once.
+	c := New("3 \"a\" .label 4 \"b\" .label 5 \"a\" .label")
+	_, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling program: %s", err.Error())
+	}
+	if len(c.labels) != 2 {
+		t.Errorf("Expected two labels, got %d", len(c.labels))
+	}
+
+	// Control characters in labels are escaped.
+	c = New("3 \"a\nb\tc\x01\" .label")
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling program: %s", err.Error())
+	}
+	if !strings.Contains(out, `.asciz "a\nb\tc\001"`) {
+		t.Errorf("Our label wasn't escaped")
+	}
+}
+
+// Test that printing all remaining values doesn't fail.
+func TestPrintAll(t *testing.T) {
+
+	c := New("1 2 3 4 +")
+	c.SetPrintAll(true)
+
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling program: %s", err.Error())
+	}
+	if !strings.Contains(out, "print_all_next") {
+		t.Errorf("Our generated program doesn't print all values")
+	}
+
+	// The program may leave numbers upon the stack, without an operation.
+	c = New("1 2 3")
+	c.SetPrintAll(true)
+	_, err = c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling program: %s", err.Error())
+	}
+}
+
+// Test the options which control our output.
+func TestOutputFormats(t *testing.T) {
+
+	tests := []struct {
+		setup    func(c *Compiler)
+		expected string
+	}{
+		{func(c *Compiler) {}, "%g"},
+		{func(c *Compiler) { c.SetDigits(17) }, "%.17g"},
+		{func(c *Compiler) { c.SetHex(true) }, "%a"},
+		{func(c *Compiler) { c.SetHex(true); c.SetDigits(3) }, "%.3a"},
+		{func(c *Compiler) { c.SetJSON(true) }, "%.17g"},
+		{func(c *Compiler) { c.SetJSON(true); c.SetHex(true) }, "\"%a\""},
+		{func(c *Compiler) { c.SetFormat("%.3f") }, "%.3f"},
+		{func(c *Compiler) { c.SetFormat("%lf%%") }, "%lf%%"},
+	}
+
+	for _, test := range tests {
+
+		c := New("1 2 +")
+		test.setup(c)
+
+		_, err := c.Compile()
+		if err != nil {
+			t.Errorf("Unexpected error compiling program: %s", err.Error())
+		}
+		if c.numberFormat() != test.expected {
+			t.Errorf("Expected format '%s', got '%s'", test.expected, c.numberFormat())
+		}
+	}
+}
+
+// Test that bogus output options are rejected.
+func TestBogusOutputFormats(t *testing.T) {
+
+	tests := []func(c *Compiler){
+		func(c *Compiler) { c.SetDigits(-1) },
+		func(c *Compiler) { c.SetJSON(true); c.SetBare(true) },
+		func(c *Compiler) { c.SetJSON(true); c.SetFormat("%f") },
+		func(c *Compiler) { c.SetHex(true); c.SetFormat("%f") },
+		func(c *Compiler) { c.SetFormat("%d") },
+		func(c *Compiler) { c.SetFormat("%s") },
+		func(c *Compiler) { c.SetFormat("%f %g") },
+		func(c *Compiler) { c.SetFormat("none") },
+		func(c *Compiler) { c.SetFormat("%") },
+		func(c *Compiler) { c.SetExitResult(true); c.SetStream(true) },
+		func(c *Compiler) { c.SetExitResult(true); c.SetPrintAll(true) },
+	}
+
+	for i, setup := range tests {
+
+		c := New("1 2 +")
+		setup(c)
+
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error with options %d, but got none", i)
+		}
+	}
+}
+
+// Test that the result may be used as the exit-code.
+func TestExitResult(t *testing.T) {
+
+	c := New("6 7 *")
+	c.SetExitResult(true)
+
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling program: %s", err.Error())
+	}
+	if !strings.Contains(out, "mov qword ptr [status], rax") {
+		t.Errorf("Our generated program doesn't set its exit-code")
+	}
+}
+
+// Test that random numbers are seeded appropriately.
+func TestRandom(t *testing.T) {
+
+	// By default we're seeded from the clock
+	c := New("rand randn + 1 6 randint +")
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling program: %s", err.Error())
+	}
+	if !strings.Contains(out, "call clock_gettime") {
+		t.Errorf("Our generated program isn't seeded from the clock")
+	}
+
+	// But we can set a seed
+	c = New("rand")
+	c.SetSeed(42)
+	out, err = c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling program: %s", err.Error())
+	}
+	if strings.Contains(out, "call clock_gettime") {
+		t.Errorf("Our generated program is seeded from the clock")
+	}
+	if !strings.Contains(out, "mov rdi, 42") {
+		t.Errorf("Our generated program isn't seeded with our value")
+	}
+
+	// Programs which don't use random numbers don't need a seed
+	c = New("3 4 +")
+	out, err = c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling program: %s", err.Error())
+	}
+	if strings.Contains(out, "rand_seed") {
+		t.Errorf("Our generated program was seeded needlessly")
+	}
+}
+
+func TestFPErrors(t *testing.T) {
+
+	tests := []struct {
+		policy string
+		has    string
+		hasnt  string
+	}{
+		{"", "jnz fp_exception", "call fp_saturate"},
+		{"trap", "jnz fp_exception", "call fp_saturate"},
+		{"ieee", "fdiv", "jnz fp_exception"},
+		{"saturate", "call fp_saturate", "jnz fp_exception"},
+	}
+
+	for _, test := range tests {
+		c := New("1 -0 / 3 *")
+		c.SetFPErrors(test.policy)
+		out, err := c.Compile()
+		if err != nil {
+			t.Errorf("Unexpected error compiling with policy '%s': %s", test.policy, err.Error())
+		}
+		if !strings.Contains(out, test.has) {
+			t.Errorf("Policy '%s' didn't generate '%s'", test.policy, test.has)
+		}
+		if strings.Contains(out, test.hasnt) {
+			t.Errorf("Policy '%s' generated '%s'", test.policy, test.hasnt)
+		}
+
+		// only trapping detects a zero divisor
+		if strings.Contains(out, "je division_by_zero") != (test.policy == "" || test.policy == "trap") {
+			t.Errorf("Policy '%s' had an unexpected zero-check", test.policy)
+		}
+	}
+
+	c := New("1 2 +")
+	c.SetFPErrors("ignore")
+	_, err := c.Compile()
+	if err == nil {
+		t.Errorf("Expected an error with a bogus policy")
+	}
+}
+
+func TestPrecision(t *testing.T) {
+
+	tests := []struct {
+		precision string
+		has       []string
+	}{
+		{"", []string{"qword ptr [a]", ".word 0x027F", "const_3: .double 3", "\"%g\""}},
+		{"single", []string{"dword ptr [a]", ".word 0x007F", "const_3: .float 3", "call strtod", "fstp dword ptr [a]"}},
+		{"double", []string{"qword ptr [a]", ".word 0x027F", "const_3: .double 3", "call strtod"}},
+		{"extended", []string{"tbyte ptr [a]", ".word 0x037F", "const_3: .tfloat 3", "call strtold", "\"%Lg\"", "\"%.0Lf\""}},
+	}
+
+	for _, test := range tests {
+		c := New("x 3 *")
+		c.SetInputs([]string{"x"})
+		c.SetPrecision(test.precision)
+		out, err := c.Compile()
+		if err != nil {
+			t.Errorf("Unexpected error compiling with precision '%s': %s", test.precision, err.Error())
+		}
+		for _, has := range test.has {
+			if !strings.Contains(out, has) {
+				t.Errorf("Precision '%s' didn't generate '%s'", test.precision, has)
+			}
+		}
+		if strings.Contains(out, "#POP") || strings.Contains(out, "#PUSH") || strings.Contains(out, "#PTR") {
+			t.Errorf("Precision '%s' left a placeholder in our output", test.precision)
+		}
+	}
+
+	// A custom format is changed to expect a long double
+	c := New("1 3 /")
+	c.SetPrecision("extended")
+	c.SetFormat("%.3lf units")
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling program: %s", err.Error())
+	}
+	if !strings.Contains(out, "\"%.3Lf units\"") {
+		t.Errorf("Our custom format wasn't changed to expect a long double")
+	}
+
+	c = New("1 2 +")
+	c.SetPrecision("quad")
+	_, err = c.Compile()
+	if err == nil {
+		t.Errorf("Expected an error with a bogus precision")
+	}
+
+	// Constants must fit in our working precision
+	for _, test := range []struct {
+		program   string
+		precision string
+	}{
+		{"1e39 2 *", "single"},
+		{"1e300 1e300 *", "single"},
+		{"1e400 2 *", ""},
+	} {
+		c = New(test.program)
+		c.SetPrecision(test.precision)
+		_, err = c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error with the out of range constants of '%s'", test.program)
+		}
+	}
+	c = New("1e400 2 *")
+	c.SetPrecision("extended")
+	_, err = c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling a large extended constant: %s", err.Error())
+	}
+}
+
+func TestFPU(t *testing.T) {
+
+	tests := []struct {
+		fpu       string
+		precision string
+		has       []string
+		hasnt     []string
+	}{
+		{"", "", []string{"faddp", "fsin", "fsqrt"}, []string{"addsd", "call_aligned"}},
+		{"x87", "", []string{"faddp", "fsin", "fsqrt"}, []string{"addsd", "call_aligned"}},
+		{"sse", "", []string{"addsd", "lea rax, sin", "sqrtsd", "lea rax, pow", "stmxcsr"}, []string{"faddp", "fsin", "fsqrt"}},
+		{"sse", "single", []string{"addss", "lea rax, sinf", "sqrtss", "lea rax, powf"}, []string{"faddp", "fsin", "fsqrt"}},
+	}
+
+	for _, test := range tests {
+		c := New("1 2 + sin sqrt 3 ^")
+		c.SetFPU(test.fpu)
+		c.SetPrecision(test.precision)
+		out, err := c.Compile()
+		if err != nil {
+			t.Errorf("Unexpected error compiling for '%s': %s", test.fpu, err.Error())
+		}
+		for _, has := range test.has {
+			if !strings.Contains(out, has) {
+				t.Errorf("FPU '%s' didn't generate '%s'", test.fpu, has)
+			}
+		}
+		for _, hasnt := range test.hasnt {
+			if strings.Contains(out, hasnt) {
+				t.Errorf("FPU '%s' generated '%s'", test.fpu, hasnt)
+			}
+		}
+	}
+
+	// Multiplications are fused with the additions, and subtractions,
+	// which follow them.
+	c := New("1 2 3 * + 4 5 * - 6 *")
+	c.SetFPU("sse")
+	c.SetFMA(true)
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling program: %s", err.Error())
+	}
+	if !strings.Contains(out, "vfmadd231sd") || !strings.Contains(out, "vfnmadd231sd") {
+		t.Errorf("Our multiplications weren't fused")
+	}
+	if strings.Count(out, "[MULTIPLY]") != 1 {
+		t.Errorf("Expected one multiplication to remain")
+	}
+
+	bogus := []func(c *Compiler){
+		func(c *Compiler) { c.SetFPU("avx") },
+		func(c *Compiler) { c.SetFPU("sse"); c.SetPrecision("extended") },
+		func(c *Compiler) { c.SetFMA(true) },
+	}
+	for i, setup := range bogus {
+		c := New("1 2 +")
+		setup(c)
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error with options %d, but got none", i)
+		}
+	}
+}
+
+// TestMath ensures the functions are calculated by the C library when
+// our math is accurate.
+func TestMath(t *testing.T) {
+
+	tests := []struct {
+		math      string
+		precision string
+		has       []string
+		hasnt     []string
+	}{
+		{"", "", []string{"fsin", "fsqrt", "f2xm1", "fyl2x"}, []string{"call_aligned"}},
+		{"fast", "", []string{"fsin", "fsqrt", "f2xm1", "fyl2x"}, []string{"call_aligned"}},
+		{"accurate", "", []string{"lea rax, sin", "lea rax, sqrt", "lea rax, pow", "lea rax, exp", "lea rax, log", "stmxcsr"}, []string{"fsin", "fsqrt", "fyl2x"}},
+		{"accurate", "single", []string{"lea rax, sinf", "lea rax, sqrtf", "lea rax, powf", "lea rax, expf", "lea rax, logf"}, []string{"fsin", "fsqrt"}},
+		{"accurate", "extended", []string{"lea rax, sinl", "lea rax, sqrtl", "lea rax, powl", "lea rax, expl", "lea rax, logl", "call call_long"}, []string{"fsin", "fsqrt"}},
+	}
+
+	for _, test := range tests {
+		c := New("1 2 + sin sqrt 3 ^ exp ln")
+		c.SetMath(test.math)
+		c.SetPrecision(test.precision)
+		out, err := c.Compile()
+		if err != nil {
+			t.Errorf("Unexpected error compiling for '%s': %s", test.math, err.Error())
+		}
+		for _, has := range test.has {
+			if !strings.Contains(out, has) {
+				t.Errorf("Math '%s' didn't generate '%s'", test.math, has)
+			}
+		}
+		for _, hasnt := range test.hasnt {
+			if strings.Contains(out, hasnt) {
+				t.Errorf("Math '%s' generated '%s'", test.math, hasnt)
+			}
+		}
+	}
+
+	c := New("1 2 +")
+	c.SetMath("exact")
+	_, err := c.Compile()
+	if err == nil {
+		t.Errorf("Expected an error with a bogus math mode, but got none")
+	}
+}
+
+// TestAccurateMath compiles, and runs, programs with arguments which the
+// FPU handles poorly, comparing the results against Go's math package.
+func TestAccurateMath(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc isn't available")
+	}
+
+	tests := []struct {
+		program  string
+		fpu      string
+		expected float64
+	}{
+		{"1e22 sin", "x87", math.Sin(1e22)},
+		{"1e22 cos", "x87", math.Cos(1e22)},
+		{"1e22 tan", "x87", math.Tan(1e22)},
+		{"1e22 sin", "sse", math.Sin(1e22)},
+		{"3.141592653589793 sin", "x87", math.Sin(math.Pi)},
+		{"1.5707963267948966 tan", "x87", math.Tan(math.Pi / 2)},
+		{"1e300 cos", "x87", math.Cos(1e300)},
+		{"2 sqrt", "x87", math.Sqrt(2)},
+		{"2 0.5 ^", "x87", math.Pow(2, 0.5)},
+		{"10 0.3 ^", "sse", math.Pow(10, 0.3)},
+	}
+
+	dir := t.TempDir()
+
+	for _, test := range tests {
+
+		c := New(test.program)
+		c.SetMath("accurate")
+		c.SetFPU(test.fpu)
+		c.SetDigits(17)
+		c.SetBare(true)
+		out, err := c.Compile()
+		if err != nil {
+			t.Fatalf("Unexpected error compiling '%s': %s", test.program, err.Error())
+		}
+
+		source := filepath.Join(dir, "prog.s")
+		binary := filepath.Join(dir, "prog")
+		err = os.WriteFile(source, []byte(out), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write program: %s", err.Error())
+		}
+		gcc := exec.Command("gcc", "-static", "-o", binary, source, "-lm")
+		var stderr bytes.Buffer
+		gcc.Stderr = &stderr
+		err = gcc.Run()
+		if err != nil {
+			t.Fatalf("Failed to compile '%s': %s\n%s", test.program, err.Error(), stderr.String())
+		}
+
+		res, err := exec.Command(binary).Output()
+		if err != nil {
+			t.Fatalf("Failed to run '%s': %s", test.program, err.Error())
+		}
+		got, err := strconv.ParseFloat(strings.TrimSpace(string(res)), 64)
+		if err != nil {
+			t.Fatalf("Failed to parse the output of '%s': %s", test.program, err.Error())
+		}
+
+		// Go's math package, and the C library, might differ in
+		// their last digits.
+		if math.Abs(got-test.expected) > 1e-13*math.Abs(test.expected) {
+			t.Errorf("'%s' gave %v, expected %v", test.program, got, test.expected)
+		}
+	}
+}
+
+// TestIntegers ensures we can compile programs which operate upon integers.
+func TestIntegers(t *testing.T) {
+
+	c := New("1 2 + 3 * 4 - 5 / 6 % 7 mod 2 ^ ! abs 3 and 4 or 5 xor not 1 shl 2 shr popcount 1 9 randint + dup swap .s . 0 \"x\" .label")
+	c.SetMode("int64")
+	c.SetPrecision("double")
+	c.SetFPErrors("trap")
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"idiv rcx", "popcnt rax, rax", "jo register_overflow", "number_fmt: .asciz \"%ld\""} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Integer program didn't contain '%s'", has)
+		}
+	}
+	for _, hasnt := range []string{"faddp", "fld qword ptr [a]", "fstp qword ptr [a]"} {
+		if strings.Contains(out, hasnt) {
+			t.Errorf("Integer program contained '%s'", hasnt)
+		}
+	}
+
+	// Literals are written in a canonical form.
+	c = New("010 1 +")
+	c.SetMode("int64")
+	out, err = c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	if !strings.Contains(out, ".quad 10\n") {
+		t.Errorf("The literal 010 wasn't converted to 10")
+	}
+
+	// Formats need integer conversions, which are made long.
+	c = New("1 2 +")
+	c.SetMode("int64")
+	c.SetFormat("%08x")
+	out, err = c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	if !strings.Contains(out, "%08lx") {
+		t.Errorf("The format wasn't made long")
+	}
+
+	bogus := []struct {
+		mode    string
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"float", "1 2 and", nil},
+		{"", "1 popcount", nil},
+		{"int64", "1 sin", nil},
+		{"int64", "pi 2 *", nil},
+		{"int64", "1.5 2 *", nil},
+		{"int64", "99999999999999999999 2 *", nil},
+		{"int64", "1 2 +", func(c *Compiler) { c.SetPrecision("extended") }},
+		{"int64", "1 2 +", func(c *Compiler) { c.SetFPU("sse") }},
+		{"int64", "1 2 +", func(c *Compiler) { c.SetMath("accurate") }},
+		{"int64", "1 2 +", func(c *Compiler) { c.SetFPErrors("ieee") }},
+		{"int64", "1 2 +", func(c *Compiler) { c.SetDigits(3) }},
+		{"int64", "1 2 +", func(c *Compiler) { c.SetFormat("%.3f") }},
+		{"int128", "1 2 +", nil},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		c.SetMode(test.mode)
+		if test.setup != nil {
+			test.setup(c)
+		}
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s' in mode '%s', but got none", test.program, test.mode)
+		}
+	}
+}
+
+// TestBignum ensures we can compile programs which operate upon integers
+// of arbitrary size.
+func TestBignum(t *testing.T) {
+
+	c := New("123456789012345678901234567890 2 + 3 * 4 - 5 / 6 % 7 mod 2 ^ ! abs dup swap .s . -1 \"x\" .label")
+	c.SetMode("bignum")
+	c.SetExitResult(true)
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"call big_add", "call big_divmod", "call big_factorial", "big_first:", "const_123456789012345678901234567890: .asciz \"123456789012345678901234567890\""} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Big integer program didn't contain '%s'", has)
+		}
+	}
+
+	bogus := []struct {
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"1.5 2 *", nil},
+		{"1e3 2 *", nil},
+		{"2 sqrt", nil},
+		{"1 2 xor", nil},
+		{"1 6 randint", nil},
+		{"1 2 +", func(c *Compiler) { c.SetHex(true) }},
+		{"1 2 +", func(c *Compiler) { c.SetFormat("%d") }},
+		{"1 2 +", func(c *Compiler) { c.SetFPU("sse") }},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		c.SetMode("bignum")
+		if test.setup != nil {
+			test.setup(c)
+		}
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s', but got none", test.program)
+		}
+	}
+}
+
+func TestRational(t *testing.T) {
+
+	c := New("1 3 / 0.25 + 2 - 3 * 4 ^ 5 % 6 mod abs 3 ! dup swap .s . -1 \"x\" .label")
+	c.SetMode("rational")
+	c.SetDigits(6)
+	c.SetExitResult(true)
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"call rat_add", "call rat_pow", "call rat_floored_mod", "call print_approximation", "const_0_25: .quad 1, 4"} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Rational program didn't contain '%s'", has)
+		}
+	}
+
+	bogus := []struct {
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"1e30 2 *", nil},
+		{"2 sqrt", nil},
+		{"pi 2 *", nil},
+		{"1 2 xor", nil},
+		{"1 6 randint", nil},
+		{"1 2 +", func(c *Compiler) { c.SetHex(true) }},
+		{"1 2 +", func(c *Compiler) { c.SetFormat("%d") }},
+		{"1 2 +", func(c *Compiler) { c.SetFPErrors("ieee") }},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		c.SetMode("rational")
+		if test.setup != nil {
+			test.setup(c)
+		}
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s', but got none", test.program)
+		}
+	}
+}
+
+func TestComplex(t *testing.T) {
+
+	c := New("-1 sqrt i + 2 - 3 * 4 / 5 ^ abs arg conj re im exp ln sin cos tan 2 polar dup swap .s . -1 \"x\" .label")
+	c.SetMode("complex")
+	c.SetExitResult(true)
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"lea rax, csqrt", "lea rax, __muldc3", "lea rax, __divdc3", "call complex_pow", "lea rax, cexp", "const_i: .double 0.0, 1.0", "const_neg_1: .double -1, 0.0"} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Complex program didn't contain '%s'", has)
+		}
+	}
+
+	bogus := []struct {
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"2 3 %", nil},
+		{"2 3 mod", nil},
+		{"3 !", nil},
+		{"rand 2 *", nil},
+		{"1 2 xor", nil},
+		{"1 2 +", func(c *Compiler) { c.SetPrecision("extended") }},
+		{"1 2 +", func(c *Compiler) { c.SetFPErrors("saturate") }},
+		{"1 2 +", func(c *Compiler) { c.SetIntegers(true) }},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		c.SetMode("complex")
+		if test.setup != nil {
+			test.setup(c)
+		}
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s', but got none", test.program)
+		}
+	}
+
+	// The words for complex numbers aren't available otherwise.
+	for _, program := range []string{"i 2 *", "2 re", "2 im", "2 conj", "2 arg", "2 3 polar"} {
+		c := New(program)
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s', but got none", program)
+		}
+	}
+}
+
+func TestInterval(t *testing.T) {
+
+	c := New("0.1 2 + 3 - 4 * 5 / 2 ^ sqrt abs pi e + dup swap .s . -1 \"x\" .label")
+	c.SetMode("interval")
+	c.SetExitResult(true)
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"call interval_add", "call interval_div", "call interval_pow", "fpu_down:", "const_0_1: .quad 0x3FB9999999999999, 0x3FB999999999999A"} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Interval program didn't contain '%s'", has)
+		}
+	}
+
+	bogus := []struct {
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"2 sin", nil},
+		{"2 exp", nil},
+		{"2 3 %", nil},
+		{"3 !", nil},
+		{"rand 2 *", nil},
+		{"1e999 2 +", nil},
+		{"1 2 +", func(c *Compiler) { c.SetPrecision("extended") }},
+		{"1 2 +", func(c *Compiler) { c.SetFPU("sse") }},
+		{"1 2 +", func(c *Compiler) { c.SetFPErrors("saturate") }},
+		{"1 2 +", func(c *Compiler) { c.SetIntegers(true) }},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		c.SetMode("interval")
+		if test.setup != nil {
+			test.setup(c)
+		}
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s', but got none", test.program)
+		}
+	}
+}
+
+func TestDecimal(t *testing.T) {
+
+	c := New("0.1 0.2 + 3 - 4 * 5 / 2 ^ 6 % 7 mod abs dup swap .s . -1 \"x\" .label")
+	c.SetMode("decimal:2")
+	c.SetRounding("half-up")
+	c.SetExitResult(true)
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"call decimal_mul", "call decimal_div", "call decimal_pow", "mov r9, 100", "const_0_1: .quad 10", "number_fmt: .asciz \"%s%lu.%02lu\""} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Decimal program didn't contain '%s'", has)
+		}
+	}
+
+	bogus := []struct {
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"0.125 2 *", nil},
+		{"1e30 2 *", nil},
+		{"2 sqrt", nil},
+		{"pi 2 *", nil},
+		{"3 !", nil},
+		{"1 2 xor", nil},
+		{"1 6 randint", nil},
+		{"1 2 +", func(c *Compiler) { c.SetMode("decimal") }},
+		{"1 2 +", func(c *Compiler) { c.SetMode("decimal:19") }},
+		{"1 2 +", func(c *Compiler) { c.SetRounding("up") }},
+		{"1 2 +", func(c *Compiler) { c.SetDigits(3) }},
+		{"1 2 +", func(c *Compiler) { c.SetFormat("%d") }},
+		{"1 2 +", func(c *Compiler) { c.SetFPU("sse") }},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		c.SetMode("decimal:2")
+		if test.setup != nil {
+			test.setup(c)
+		}
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s', but got none", test.program)
+		}
+	}
+
+	// Rounding may only be chosen for decimals.
+	c = New("1 2 +")
+	c.SetRounding("truncate")
+	_, err = c.Compile()
+	if err == nil {
+		t.Errorf("Expected an error choosing the rounding of floats, but got none")
+	}
+}
+
+func TestUnits(t *testing.T) {
+
+	c := New("3 km 200 m + 2 min / dup swap .s . 1 km/h \"x\" .label 2 ha sqrt 3 ^ 1 m^3 -")
+	c.SetUnit("km/h")
+	c.SetPrintAll(true)
+	_, err := c.Compile()
+	if err == nil {
+		t.Errorf("Expected an error outputting a volume in km/h, but got none")
+	}
+
+	c = New("3 km 200 m + 2 min / dup swap .s . 1 km/h \"x\" .label 2 ha sqrt 1 h /")
+	c.SetUnit("km/h")
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"const_1000: .double 1000", "const_60: .double 60", "unit_scale: .double 0.2777777777777778", "fdiv qword ptr [unit_scale]", " km/h\\n"} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Program with units didn't contain '%s'", has)
+		}
+	}
+
+	bogus := []struct {
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"3 m 2 s +", nil},
+		{"3 m 2 s -", nil},
+		{"3 m 2 s %", nil},
+		{"3 m 2 m ^", nil},
+		{"3 m $1 ^", nil},
+		{"3 m 0.5 ^", nil},
+		{"3 m sqrt", nil},
+		{"3 m sin", nil},
+		{"3 m exp", nil},
+		{"3 m", func(c *Compiler) { c.SetUnit("s") }},
+		{"3 m", func(c *Compiler) { c.SetUnit("furlong") }},
+		{"3", func(c *Compiler) { c.SetUnit("m") }},
+		{"3 m .", func(c *Compiler) { c.SetUnit("s") }},
+		{"3 m .s 1 +", func(c *Compiler) { c.SetUnit("s") }},
+		{"3 m", func(c *Compiler) { c.SetMode("int64") }},
+		{"3", func(c *Compiler) { c.SetMode("complex"); c.SetUnit("m") }},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		if test.setup != nil {
+			test.setup(c)
+		}
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s', but got none", test.program)
+		}
+	}
+}
+
+// Test calculating derivatives.
+func TestGrad(t *testing.T) {
+
+	c := New("x y * x sin + y 2 ^ / rand -")
+	c.SetInputs([]string{"x", "y"})
+	c.SetGrad([]string{"x", "$2"})
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"inputs: .double 0.0, 1.0, 0.0", ".double 0.0, 0.0, 1.0", ".fill 2, 8, 0", "[DERIVATIVE OF MULTIPLY]", "lea rax, cos", "lea rax, log", "mov qword ptr [rsp + 16], 0", " (d/dx ", ", d/d$2 "} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Program with derivatives didn't contain '%s'", has)
+		}
+	}
+
+	// Derivatives are checked like values, unless errors are ignored.
+	if !strings.Contains(out, "call dual_check") {
+		t.Errorf("Program with derivatives didn't check them")
+	}
+	c = New("x sqrt")
+	c.SetInputs([]string{"x"})
+	c.SetGrad([]string{"x"})
+	c.SetFPErrors("ieee")
+	out, err = c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	if strings.Contains(out, "call dual_check") {
+		t.Errorf("Program with derivatives checked them, despite our policy")
+	}
+
+	bogus := []struct {
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"x 2 *", func(c *Compiler) { c.SetGrad([]string{"x"}) }},
+		{"x 2 *", func(c *Compiler) { c.SetInputs([]string{"x"}); c.SetGrad([]string{"y"}) }},
+		{"x 2 *", func(c *Compiler) { c.SetInputs([]string{"x"}); c.SetGrad([]string{"x", "$1"}) }},
+		{"$1 2 *", func(c *Compiler) { c.SetGrad([]string{"$0"}) }},
+		{"$1 !", func(c *Compiler) { c.SetGrad([]string{"$1"}) }},
+		{"$1 2 *", func(c *Compiler) { c.SetGrad([]string{"$1"}); c.SetMode("int64") }},
+		{"$1 2 *", func(c *Compiler) { c.SetGrad([]string{"$1"}); c.SetPrecision("single") }},
+		{"$1 2 *", func(c *Compiler) { c.SetGrad([]string{"$1"}); c.SetFPErrors("saturate") }},
+		{"$1 2 *", func(c *Compiler) { c.SetGrad([]string{"$1"}); c.SetIntegers(true) }},
+		{"$1 2 * 3 +", func(c *Compiler) { c.SetGrad([]string{"$1"}); c.SetFPU("sse"); c.SetFMA(true) }},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		if test.setup != nil {
+			test.setup(c)
+		}
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s', but got none", test.program)
+		}
+	}
+}
+
+// Test differentiating programs symbolically.
+func TestDerive(t *testing.T) {
+
+	tests := []struct {
+		program string
+		rpn     string
+		infix   string
+	}{
+		{"x 2 ^", "2 x *", "2 * x"},
+		{"x 2 ^ 3 * x 4 * - 5 +", "6 x * 4 -", "6 * x - 4"},
+		{"x sin x *", "x cos x * x sin +", "cos(x) * x + sin(x)"},
+		{"x cos y *", "-1 x sin y * *", "-(sin(x) * y)"},
+		{"1 x /", "-1 x 2 ^ /", "-1 / x^2"},
+		{"x sqrt", "1 2 x sqrt * /", "1 / (2 * sqrt(x))"},
+		{"2 x ^", "2 x ^ 2 ln *", "2^x * ln(2)"},
+		{"x x ^", "x x ^ x ln 1 + *", "x^x * (ln(x) + 1)"},
+		{"x y - $2 /", "1 y /", "1 / y"},
+		{"x dup *", "2 x *", "2 * x"},
+		{"y 3 * 2 ^", "0", "0"},
+		{"x 0.5 * 0.25 *", "0.125", "0.125"},
+		{"x 2 3 ^ ^", "8 x 7 ^ *", "8 * x^7"},
+		{"x 1 3 / ^", "1 3 / x -2 3 / ^ *", "1 / 3 * x^(-2 / 3)"},
+	}
+
+	for _, test := range tests {
+		for _, infix := range []bool{false, true} {
+			c := New(test.program)
+			c.SetInputs([]string{"x", "y"})
+			c.SetDerive("x")
+			c.SetInfix(infix)
+			out, err := c.Derivative()
+			if err != nil {
+				t.Errorf("Unexpected error differentiating '%s': %s", test.program, err.Error())
+				continue
+			}
+			expected := test.rpn
+			if infix {
+				expected = test.infix
+			}
+			if out != expected {
+				t.Errorf("The derivative of '%s' was '%s', not '%s'", test.program, out, expected)
+			}
+		}
+	}
+
+	// The derivative is what we compile.
+	c := New("x sin")
+	c.SetInputs([]string{"x"})
+	c.SetDerive("x")
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	if !strings.Contains(out, "[COS]") || strings.Contains(out, "[SIN]") {
+		t.Errorf("The derivative of 'x sin' wasn't compiled")
+	}
+
+	bogus := []struct {
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"x 2 *", func(c *Compiler) { c.SetDerive("y") }},
+		{"x !", nil},
+		{"x rand *", nil},
+		{"x 2 * .", nil},
+		{"x 2", nil},
+		{"x km", nil},
+		{"x 2 *", func(c *Compiler) { c.SetMode("complex") }},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		c.SetInputs([]string{"x"})
+		c.SetDerive("x")
+		if test.setup != nil {
+			test.setup(c)
+		}
+		_, err := c.Derivative()
+		if err == nil {
+			t.Errorf("Expected an error differentiating '%s', but got none", test.program)
+		}
+	}
+
+	// Infix notation is only for derivatives.
+	c = New("x 2 *")
+	c.SetInputs([]string{"x"})
+	c.SetInfix(true)
+	_, err = c.Compile()
+	if err == nil {
+		t.Errorf("Expected an error with infix notation, but got none")
+	}
+}
+
+// Test integrating, and solving, quotations.
+func TestQuotations(t *testing.T) {
+
+	c := New("[ x sin x * ] 0 pi integrate [ [ x cos x - ] 0 x solve x * ] 1 2 integrate +")
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"quotation_0:", "quotation_1:", "quotation_2:", "[VARIABLE]", "[INTEGRATE]", "[SOLVE]", "lea rdi, quotation_2", "call integrate", "call solve", "simpson_tol: .double 1e-12", "simpson_min: .double 1e-6"} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Program with quotations didn't contain '%s'", has)
+		}
+	}
+
+	// "x" may also be a runtime input, outside our quotations.
+	c = New("[x x *] 0 x integrate")
+	c.SetInputs([]string{"x"})
+	out, err = c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	if !strings.Contains(out, "[INPUT]") {
+		t.Errorf("Program with an input named x didn't read it")
+	}
+
+	bogus := []struct {
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"x 2 *", nil},
+		{"[ x sin ]", nil},
+		{"[ x sin 0 1 integrate", nil},
+		{"1 ] 0 1 integrate", nil},
+		{"0 1 solve", nil},
+		{"[ + ] 0 1 integrate", nil},
+		{"[ x x ] 0 1 integrate", nil},
+		{"[ x . ] 0 1 integrate", nil},
+		{"[ x [ x ] ] 0 1 integrate", nil},
+		{"[ x ] 0 1 integrate 3 km *", nil},
+		{"[ x ] 0 1 integrate", func(c *Compiler) { c.SetUnit("m") }},
+		{"[ x ] 0 1 integrate", func(c *Compiler) { c.SetMode("int64") }},
+		{"[ x ] 0 $1 integrate", func(c *Compiler) { c.SetGrad([]string{"$1"}) }},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		if test.setup != nil {
+			test.setup(c)
+		}
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s', but got none", test.program)
+		}
+	}
+}
+
+// Test that ranges of inputs produce a table of results.
+func TestRanges(t *testing.T) {
+
+	c := New("x y * z +")
+	c.SetInputs([]string{"x", "y", "z"})
+	c.SetRanges([]string{"x=0:10:0.5", "z=1:3"})
+	c.SetCSV(true)
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"range_0_step: .double 0.5", "range_1_from: .double 1", `table_head: .asciz "x,z,result\n"`, "next_row:", "mov rax, 21", "mov rax, 3", "We expect 1 input(s)", "cmp rdi, 2", "rax + 8]", `Usage: %s y\n`, "jne row_failed", "mov qword ptr [row_rsp], rsp"} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Program with ranges didn't contain '%s'", has)
+		}
+	}
+
+	// Integers are stepped exactly.
+	c = New("n 2 *")
+	c.SetInputs([]string{"n"})
+	c.SetMode("int64")
+	c.SetRanges([]string{"n=1:10:3"})
+	out, err = c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"range_0_step: .quad 3", "mov rax, 4", `column_fmt: .asciz "%ld\t"`} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Program with an integer range didn't contain '%s'", has)
+		}
+	}
+
+	bogus := []struct {
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"x 1 +", func(c *Compiler) { c.SetRanges([]string{"y=0:1"}) }},
+		{"x 1 +", func(c *Compiler) { c.SetRanges([]string{"x"}) }},
+		{"x 1 +", func(c *Compiler) { c.SetRanges([]string{"x=0:1:0"}) }},
+		{"x 1 +", func(c *Compiler) { c.SetRanges([]string{"x=1:0"}) }},
+		{"x 1 +", func(c *Compiler) { c.SetRanges([]string{"x=0:one"}) }},
+		{"x 1 +", func(c *Compiler) { c.SetRanges([]string{"x=0:1", "x=2:3"}) }},
+		{"x 1 +", func(c *Compiler) { c.SetRanges([]string{"x=0:1"}); c.SetStream(true) }},
+		{"x 1 +", func(c *Compiler) { c.SetRanges([]string{"x=0:1"}); c.SetJSON(true) }},
+		{"x 1 +", func(c *Compiler) { c.SetRanges([]string{"x=0:1"}); c.SetMode("bignum") }},
+		{"x 1 +", func(c *Compiler) { c.SetRanges([]string{"x=0:0.5"}); c.SetMode("int64") }},
+		{"x 1 + .", func(c *Compiler) { c.SetRanges([]string{"x=0:1"}) }},
+		{"x 1 +", func(c *Compiler) { c.SetCSV(true) }},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		c.SetInputs([]string{"x"})
+		test.setup(c)
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s', but got none", test.program)
+		}
+	}
+
+	// Tables can't be written as JSON, which has an error of its own.
+	c = New("x 1 +")
+	c.SetInputs([]string{"x"})
+	c.SetRanges([]string{"x=0:1"})
+	c.SetJSON(true)
+	_, err = c.Compile()
+	if err == nil || !strings.Contains(err.Error(), "JSON") {
+		t.Errorf("Expected an error about JSON, got %v", err)
+	}
+}
+
+// Test vectors, which are calculated elementwise.
+func TestVectors(t *testing.T) {
+
+	c := New("[1 2 3] 2 * [4 5 6] + [1 0 0] cross dup dot 3 [x sin] 0 1 integrate *")
+	out, err := c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"vector_0: .double 1, 2, 3", "vector_2: .fill 3, 8, 0", "unpcklpd xmm3, xmm3", "mulpd xmm0, xmm1", "mulsd xmm0, xmm1", "addpd xmm0, xmm1", "unpckhpd xmm1, xmm1", "print_vector:", "vector_map:"} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Program with vectors didn't contain '%s'", has)
+		}
+	}
+
+	// A vector result is printed with its elements.
+	c = New("[1 4 9] sqrt [1 2 3] sin +")
+	out, err = c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"sqrtpd xmm0, xmm0", "sqrtsd xmm0, xmm0", "lea rax, sin", "call vector_map", "mov rcx, 3\n        lea rsi,result\n        call print_vector"} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Program with a vector result didn't contain '%s'", has)
+		}
+	}
+
+	// A stack holding vectors is printed with their lengths.
+	c = New("[1 2] 3 .s *")
+	out, err = c.Compile()
+	if err != nil {
+		t.Errorf("Unexpected error compiling: %s", err.Error())
+	}
+	for _, has := range []string{"stack_shape_2: .quad 0, 2", "lea rsi,stack_shape_2\n        call print_vector_stack", "print_elements:"} {
+		if !strings.Contains(out, has) {
+			t.Errorf("Program printing a stack of vectors didn't contain '%s'", has)
+		}
+	}
+
+	bogus := []struct {
+		program string
+		setup   func(c *Compiler)
+	}{
+		{"[1 2] [1 2 3] +", func(c *Compiler) {}},
+		{"[1 2] [1 2 3] dot", func(c *Compiler) {}},
+		{"[1 2] [3 4] cross", func(c *Compiler) {}},
+		{"3 norm", func(c *Compiler) {}},
+		{"3 4 dot", func(c *Compiler) {}},
+		{"[1 2] !", func(c *Compiler) {}},
+		{"[1 2] 3 randint", func(c *Compiler) {}},
+		{"[$1 $1]", func(c *Compiler) {}},
+		{"[x] 1 +", func(c *Compiler) { c.SetInputs([]string{"x"}) }},
+		{"[ [1 2] x * ] 0 1 integrate", func(c *Compiler) {}},
+		{"[1 2] 2 *", func(c *Compiler) { c.SetPrecision("single") }},
+		{"[1 2] 2 *", func(c *Compiler) { c.SetMode("complex") }},
+		{"[1 2] 2 *", func(c *Compiler) { c.SetPrintAll(true) }},
+		{"[1 2] 2 *", func(c *Compiler) { c.SetExitResult(true) }},
+		{"[1 2] 2 *", func(c *Compiler) { c.SetUnit("m") }},
+		{"[1 2] 2 *", func(c *Compiler) { c.SetFPErrors("saturate") }},
+		{"[1 2] x *", func(c *Compiler) { c.SetInputs([]string{"x"}); c.SetGrad([]string{"x"}) }},
+		{"[1 2] x *", func(c *Compiler) { c.SetInputs([]string{"x"}); c.SetRanges([]string{"x=0:1"}) }},
+	}
+	for _, test := range bogus {
+		c := New(test.program)
+		test.setup(c)
+		_, err := c.Compile()
+		if err == nil {
+			t.Errorf("Expected an error compiling '%s', but got none", test.program)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+// complex.go contains the code for emitting instructions which operate
+// upon complex numbers.
+
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skx/math-compiler/instructions"
+)
+
+// storeComplex stores the complex number returned by the C library, in
+// xmm0 and xmm1, in [a].
+const storeComplex = `        movsd qword ptr [a], xmm0
+        movsd qword ptr [a + 8], xmm1
+`
+
+// storeReal stores the real number returned by the C library, in xmm0,
+// in [a].
+const storeReal = `        movsd qword ptr [a], xmm0
+        mov qword ptr [a + 8], 0
+`
+
+// complexConstant returns the declaration of the given constant, which
+// is either a real number, or "i".
+func (c *Compiler) complexConstant(value string) string {
+	if value == "i" {
+		return fmt.Sprintf("%s: .double 0.0, 1.0\n", c.escapeConstant(value))
+	}
+	return fmt.Sprintf("%s: .double %s, 0.0\n", c.escapeConstant(value), value)
+}
+
+// genComplex generates the assembly code for the given instruction when
+// working with complex numbers.
+//
+// Each entry upon our stack is a real part followed by an imaginary part,
+// both of which are doubles.  Functions are calculated by the C library,
+// on their principal branch.
+func (c *Compiler) genComplex(opr instructions.Instruction) string {
+
+	switch opr.Type {
+
+	case instructions.Abs:
+		return c.complexUnary("ABS", complexCall("cabs", 1)+storeReal)
+
+	case instructions.Arg:
+		return c.complexUnary("ARG", complexCall("carg", 1)+storeReal)
+
+	case instructions.Conj:
+		return c.complexUnary("CONJ", `        btc qword ptr [a + 8], 63
+`)
+
+	case instructions.Cos:
+		return c.complexUnary("COS", complexCall("ccos", 1)+storeComplex)
+
+	case instructions.Divide:
+		calc := complexCall("__divdc3", 2) + storeComplex
+		if c.trapping() {
+			calc = `        # the divisor mustn't be zero
+        movupd xmm0, xmmword ptr [a]
+        xorpd xmm1, xmm1
+        cmpneqpd xmm0, xmm1
+        movmskpd eax, xmm0
+        test eax, eax
+        jz division_by_zero
+` + calc
+		}
+		return c.complexBinary("DIVIDE", calc)
+
+	case instructions.Dup:
+		return c.genDup()
+
+	case instructions.Exp:
+		return c.complexUnary("EXP", complexCall("cexp", 1)+storeComplex)
+
+	case instructions.Imaginary:
+		return c.complexUnary("IM", `        mov rax, qword ptr [a + 8]
+        mov qword ptr [a], rax
+        mov qword ptr [a + 8], 0
+`)
+
+	case instructions.Input:
+		return c.genInput(opr.Value)
+
+	case instructions.Label:
+		return c.genLabel(opr.Value)
+
+	case instructions.Ln:
+		return c.complexUnary("LN", complexCall("clog", 1)+storeComplex)
+
+	case instructions.Minus:
+		return c.complexBinary("MINUS", `        movupd xmm0, xmmword ptr [b]
+        movupd xmm1, xmmword ptr [a]
+        subpd xmm0, xmm1
+        movupd xmmword ptr [a], xmm0
+`)
+
+	case instructions.Multiply:
+		return c.complexBinary("MULTIPLY", complexCall("__muldc3", 2)+storeComplex)
+
+	case instructions.Plus:
+		return c.complexBinary("PLUS", `        movupd xmm0, xmmword ptr [b]
+        movupd xmm1, xmmword ptr [a]
+        addpd xmm0, xmm1
+        movupd xmmword ptr [a], xmm0
+`)
+
+	case instructions.Polar:
+		return c.complexBinary("POLAR", `        # the magnitude, multiplied by e^(i * angle)
+        xorpd xmm0, xmm0
+        movsd xmm1, qword ptr [a]
+        lea rax, cexp
+        call call_aligned
+        movsd xmm2, qword ptr [b]
+        mulsd xmm0, xmm2
+        mulsd xmm1, xmm2
+`+storeComplex)
+
+	case instructions.Power:
+		return c.complexBinary("POWER", `        call complex_pow
+`)
+
+	case instructions.Print:
+		return c.genPrint()
+
+	case instructions.PrintStack:
+		return c.genPrintStack()
+
+	case instructions.Push:
+		return c.genPush(opr.Value)
+
+	case instructions.Real:
+		return c.complexUnary("RE", `        mov qword ptr [a + 8], 0
+`)
+
+	case instructions.Sin:
+		return c.complexUnary("SIN", complexCall("csin", 1)+storeComplex)
+
+	case instructions.Sqrt:
+		return c.complexUnary("SQRT", complexCall("csqrt", 1)+storeComplex)
+
+	case instructions.Swap:
+		return c.genSwap()
+
+	case instructions.Tan:
+		return c.complexUnary("TAN", complexCall("ctan", 1)+storeComplex)
+
+	}
+	return ""
+}
+
+// complexCall returns the assembly code which calls the named function,
+// with [a] as its argument.  If the function takes two arguments then
+// they are [b] and [a], in that order.
+//
+// Each argument is passed as a pair of doubles, in xmm registers.
+func complexCall(name string, args int) string {
+	text := `        movsd xmm0, qword ptr [a]
+        movsd xmm1, qword ptr [a + 8]
+`
+	if args > 1 {
+		text = `        movsd xmm0, qword ptr [b]
+        movsd xmm1, qword ptr [b + 8]
+        movsd xmm2, qword ptr [a]
+        movsd xmm3, qword ptr [a + 8]
+`
+	}
+	text += `        lea rax, NAME
+        call call_aligned
+`
+	return strings.Replace(text, "NAME", name, -1)
+}
+
+// complexBinary returns the assembly code which pops two complex numbers
+// from the stack, and pushes the result of the given calculation.
+//
+// The calculation works upon [b] and [a], and stores its result in [a].
+func (c *Compiler) complexBinary(name string, calc string) string {
+	text := `
+        # [#NAME]
+        # ensure there are at least two arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 2
+        jb stack_error
+
+        # pop two values
+        #POP a
+        #POP b
+
+        #CLEAR
+#CALC
+        #CHECK
+
+        # push the result back onto the stack
+        #PUSH a
+
+        # we took two values from the stack, but added one
+        # so the net result is the stack shrunk by one.
+        dec qword ptr [depth]
+`
+	text = strings.Replace(text, "#NAME", name, -1)
+	return c.fpChecks(strings.Replace(text, "#CALC\n", calc, -1))
+}
+
+// complexUnary returns the assembly code which pops a complex number
+// from the stack, and pushes the result of the given calculation.
+//
+// The calculation works upon [a], and stores its result there.
+func (c *Compiler) complexUnary(name string, calc string) string {
+	text := `
+        # [#NAME]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # pop one value
+        #POP a
+
+        #CLEAR
+#CALC
+        #CHECK
+
+        # push result onto stack
+        #PUSH a
+
+        # stack size didn't change; popped one, pushed one.
+`
+	text = strings.Replace(text, "#NAME", name, -1)
+	return c.fpChecks(strings.Replace(text, "#CALC\n", calc, -1))
+}
+
+// genComplexData generates the data-area entries which are used to print
+// complex numbers, and by our runtime.
+func (c *Compiler) genComplexData() string {
+	text := `
+#
+# Complex numbers.
+#
+#  complex_plus: printed between the real and imaginary parts.
+#
+# complex_minus: printed before a negative imaginary part.
+#
+#     complex_i: printed after the imaginary part.
+#
+#  complex_json: used to print both parts, in JSON.
+#
+#  complex_base: used to hold the base, when raising to a power.
+#
+# complex_result: used to hold the result, when raising to a power.
+#
+  complex_plus: .asciz "+"
+ complex_minus: .asciz "-"
+     complex_i: .asciz "i"
+  complex_json: .asciz "#JSON"
+  complex_base: .octa 0
+complex_result: .octa 0
+`
+	format := c.numberFormat()
+	json := `{\"re\": ` + format + `, \"im\": ` + format + `}`
+	return strings.Replace(text, "#JSON", json, -1)
+}
+
+// genComplexHelpers generates the runtime which raises complex numbers to
+// a power, and reads them from strings.
+func (c *Compiler) genComplexHelpers() string {
+	return `
+#
+# [a] = [b] ^ [a], on the principal branch.
+#
+# Whole exponents are calculated by repeated squaring, so that i^2 is
+# exactly -1.  Zero can be raised to a power whose real part is positive,
+# and everything else is left to the C library.
+#
+complex_pow:
+        push rbx
+
+        # is the exponent a real whole number, of a reasonable size?
+        xorpd xmm1, xmm1
+        movsd xmm0, qword ptr [a + 8]
+        ucomisd xmm0, xmm1
+        jp complex_pow_general
+        jne complex_pow_general
+        mov rax, qword ptr [a]
+        btr rax, 63
+        movq xmm0, rax
+        mov rax, 0x43D0000000000000     # 2^62
+        movq xmm1, rax
+        ucomisd xmm0, xmm1
+        jp complex_pow_general
+        jae complex_pow_general
+        movsd xmm0, qword ptr [a]
+        cvttsd2si rbx, xmm0
+        cvtsi2sd xmm1, rbx
+        ucomisd xmm0, xmm1
+        jne complex_pow_general
+
+        # the base, which is inverted for a negative exponent
+        movupd xmm0, xmmword ptr [b]
+        movupd xmmword ptr [complex_base], xmm0
+        test rbx, rbx
+        jns complex_pow_whole
+        neg rbx
+        xorpd xmm1, xmm1
+        cmpneqpd xmm0, xmm1
+        movmskpd eax, xmm0
+        test eax, eax
+        jz division_by_zero
+        mov rax, 0x3FF0000000000000     # 1.0
+        movq xmm0, rax
+        xorpd xmm1, xmm1
+        movsd xmm2, qword ptr [b]
+        movsd xmm3, qword ptr [b + 8]
+        lea rax, __divdc3
+        call call_aligned
+        movsd qword ptr [complex_base], xmm0
+        movsd qword ptr [complex_base + 8], xmm1
+
+complex_pow_whole:
+        mov rax, 0x3FF0000000000000     # 1.0
+        mov qword ptr [complex_result], rax
+        mov qword ptr [complex_result + 8], 0
+complex_pow_next:
+        test rbx, 1
+        jz complex_pow_square
+        movsd xmm0, qword ptr [complex_result]
+        movsd xmm1, qword ptr [complex_result + 8]
+        movsd xmm2, qword ptr [complex_base]
+        movsd xmm3, qword ptr [complex_base + 8]
+        lea rax, __muldc3
+        call call_aligned
+        movsd qword ptr [complex_result], xmm0
+        movsd qword ptr [complex_result + 8], xmm1
+complex_pow_square:
+        shr rbx, 1
+        jz complex_pow_done
+        movsd xmm0, qword ptr [complex_base]
+        movsd xmm1, qword ptr [complex_base + 8]
+        movapd xmm2, xmm0
+        movapd xmm3, xmm1
+        lea rax, __muldc3
+        call call_aligned
+        movsd qword ptr [complex_base], xmm0
+        movsd qword ptr [complex_base + 8], xmm1
+        jmp complex_pow_next
+complex_pow_done:
+        movupd xmm0, xmmword ptr [complex_result]
+        movupd xmmword ptr [a], xmm0
+        pop rbx
+        ret
+
+complex_pow_general:
+        # is the base zero?
+        movupd xmm0, xmmword ptr [b]
+        xorpd xmm1, xmm1
+        cmpneqpd xmm0, xmm1
+        movmskpd eax, xmm0
+        test eax, eax
+        jnz complex_pow_library
+        movsd xmm0, qword ptr [a]
+        ucomisd xmm0, xmm1
+        jbe domain_error
+        movupd xmmword ptr [a], xmm1
+        pop rbx
+        ret
+
+complex_pow_library:
+        movsd xmm0, qword ptr [b]
+        movsd xmm1, qword ptr [b + 8]
+        movsd xmm2, qword ptr [a]
+        movsd xmm3, qword ptr [a + 8]
+        lea rax, cpow
+        call call_aligned
+        movsd qword ptr [a], xmm0
+        movsd qword ptr [a + 8], xmm1
+        pop rbx
+        ret
+
+#
+# Convert the string pointed to by rdi into a complex number, which is
+# stored in [a].  The end of the number is stored at the location pointed
+# to by rsi.
+#
+# We accept real numbers, imaginary numbers such as "2i", or "-i", and
+# both together, such as "3+4i".
+#
+complex_parse:
+        push rbx
+        push r12
+        push r13
+        mov rbx, rdi
+        mov r12, rsi
+        xor eax, eax
+        mov qword ptr [a], rax
+        mov qword ptr [a + 8], rax
+
+        # the first number, which may be either part
+        mov rdi, rbx
+        mov rsi, r12
+        call strtod
+        mov r13, qword ptr [r12]
+        cmp r13, rbx
+        jne complex_parse_first
+
+        # perhaps a lone "i"
+        mov rdi, rbx
+        call complex_unit
+        mov r13, rax
+        movsd qword ptr [a + 8], xmm0
+        jmp complex_parse_done
+
+complex_parse_first:
+        cmp byte ptr [r13], 'i'
+        jne complex_parse_real
+        movsd qword ptr [a + 8], xmm0
+        inc r13
+        jmp complex_parse_done
+
+complex_parse_real:
+        movsd qword ptr [a], xmm0
+
+        # the imaginary part, which must be signed, might follow
+        movzx eax, byte ptr [r13]
+        cmp eax, '+'
+        je complex_parse_imaginary
+        cmp eax, '-'
+        jne complex_parse_done
+complex_parse_imaginary:
+        mov rdi, r13
+        call complex_unit
+        cmp rax, r13
+        jne complex_parse_second
+        mov rdi, r13
+        mov rsi, r12
+        call strtod
+        mov rax, qword ptr [r12]
+        cmp byte ptr [rax], 'i'
+        jne complex_parse_done
+        inc rax
+complex_parse_second:
+        movsd qword ptr [a + 8], xmm0
+        mov r13, rax
+
+complex_parse_done:
+        mov qword ptr [r12], r13
+        pop r13
+        pop r12
+        pop rbx
+        ret
+
+#
+# If the string pointed to by rdi is "i", "+i", or "-i", return the
+# coefficient in xmm0, and the end of the string in rax.  Otherwise rax
+# is the start of the string.
+#
+complex_unit:
+        mov rax, rdi
+        mov rcx, 0x3FF0000000000000     # 1.0
+        movzx edx, byte ptr [rax]
+        cmp edx, '+'
+        je complex_unit_signed
+        cmp edx, '-'
+        jne complex_unit_i
+        mov rcx, 0xBFF0000000000000     # -1.0
+complex_unit_signed:
+        inc rax
+complex_unit_i:
+        cmp byte ptr [rax], 'i'
+        jne complex_unit_none
+        inc rax
+        movq xmm0, rcx
+        ret
+complex_unit_none:
+        mov rax, rdi
+        xorpd xmm0, xmm0
+        ret
+`
+}
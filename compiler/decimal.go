@@ -0,0 +1,319 @@
+// decimal.go contains the code for emitting instructions which operate
+// upon fixed-point decimals, along with the runtime which implements
+// their multiplication and division.
+
+package compiler
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/skx/math-compiler/instructions"
+)
+
+// decimal converts the given literal, such as "0.25", into a 64-bit
+// integer which is scaled by ten to the power of places.
+//
+// The literal mustn't have more decimal places than we keep, as we'd
+// have to round it, and a decimal is intended to be exact.
+func decimal(literal string, places int) (int64, error) {
+	r, ok := new(big.Rat).SetString(literal)
+	if !ok {
+		return 0, fmt.Errorf("the number '%s' isn't a decimal", literal)
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil)
+	r.Mul(r, new(big.Rat).SetInt(scale))
+	if !r.IsInt() {
+		return 0, fmt.Errorf("the number '%s' has more than %d decimal places", literal, places)
+	}
+	if !r.Num().IsInt64() {
+		return 0, fmt.Errorf("the number '%s' is too large for a decimal with %d places", literal, places)
+	}
+	return r.Num().Int64(), nil
+}
+
+// scale returns the value which our decimals are scaled by, that is ten
+// to the power of the number of decimal places we keep.
+func (c *Compiler) scale() int64 {
+	scale := int64(1)
+	for i := 0; i < c.places; i++ {
+		scale *= 10
+	}
+	return scale
+}
+
+// genDecimal generates the assembly code for the given instruction, which
+// is the i'th of our program, when working with decimals.
+//
+// Each entry upon our stack is a 64-bit integer, scaled by the number of
+// decimal places we keep, so most instructions are shared with integers;
+// the sum of two scaled values is the scaled sum, for example.  Products,
+// and quotients, must be rescaled, which is where rounding happens.
+func (c *Compiler) genDecimal(opr instructions.Instruction, i int) string {
+
+	switch opr.Type {
+
+	case instructions.Divide:
+		return c.integerBinary("DIVIDE", `        call decimal_div
+`)
+
+	case instructions.Multiply:
+		return c.integerBinary("MULTIPLY", `        call decimal_mul
+`)
+
+	case instructions.Power:
+		return c.integerBinary("POWER", `        call decimal_pow
+`)
+
+	}
+	return c.genInteger(opr, i)
+}
+
+// genDecimalData generates the data-area entries which are used by our
+// runtime.
+func (c *Compiler) genDecimalData() string {
+	return `
+#
+# Fixed-point decimals.
+#
+#  decimal_plus: printed before a value which isn't negative.
+#
+# decimal_minus: printed before a value which is negative.
+#
+ decimal_plus: .asciz ""
+decimal_minus: .asciz "-"
+`
+}
+
+// genDecimalHelpers generates the runtime which implements the
+// multiplication, and division, of our decimals.
+//
+// The product, or the dividend, is calculated with 128 bits, so that it
+// can't overflow before it is rescaled, and the rescaled result is
+// rounded according to our rounding mode.
+func (c *Compiler) genDecimalHelpers() string {
+	text := `
+#
+# Multiply rax by rcx, leaving the result in rax.
+#
+decimal_mul:
+        mov r8, rax
+        xor r8, rcx             # the sign of the result
+        mov rdx, rax
+        neg rax
+        cmovs rax, rdx
+        mov rdx, rcx
+        neg rcx
+        cmovs rcx, rdx
+        mul rcx
+        mov r9, #SCALE
+        jmp decimal_round
+
+#
+# Divide rax by rcx, leaving the result in rax.
+#
+decimal_div:
+        test rcx, rcx
+        jz division_by_zero
+        mov r8, rax
+        xor r8, rcx             # the sign of the result
+        mov rdx, rax
+        neg rax
+        cmovs rax, rdx
+        mov rdx, rcx
+        neg rcx
+        cmovs rcx, rdx
+        mov r9, rcx
+        mov rcx, #SCALE
+        mul rcx
+        jmp decimal_round
+
+#
+# Divide the 128-bit magnitude in rdx:rax by r9, rounding the quotient
+# and giving it the sign of r8.  The result is left in rax.
+#
+decimal_round:
+        # the quotient must fit in 64 bits, else div would fault
+        cmp rdx, r9
+        jae register_overflow
+        div r9
+#ROUND
+        test rax, rax
+        js register_overflow
+        test r8, r8
+        jns decimal_rounded
+        neg rax
+decimal_rounded:
+        ret
+
+#
+# Raise rax to the power of rcx, which must be a whole number, leaving
+# the result in rax.
+#
+# The result is calculated by repeated squaring, with each product being
+# rounded, and a negative exponent gives the reciprocal.
+#
+decimal_pow:
+        mov r11, rax            # the base
+        mov rax, rcx
+        cqo
+        mov r9, #SCALE
+        idiv r9
+        test rdx, rdx
+        jnz domain_error
+        mov r10, rax            # the exponent
+        xor rdi, rdi            # is the exponent negative?
+        test r10, r10
+        jns decimal_pow_positive
+        neg r10
+        inc rdi
+decimal_pow_positive:
+        mov rsi, #SCALE         # the result, initially one
+decimal_pow_loop:
+        test r10, 1
+        jz decimal_pow_skip
+        mov rax, rsi
+        mov rcx, r11
+        call decimal_mul
+        mov rsi, rax
+decimal_pow_skip:
+        shr r10, 1
+        jz decimal_pow_done
+        mov rax, r11
+        mov rcx, r11
+        call decimal_mul
+        mov r11, rax
+        jmp decimal_pow_loop
+decimal_pow_done:
+        mov rax, rsi
+        test rdi, rdi
+        jz decimal_pow_return
+        mov rcx, rax
+        mov rax, #SCALE
+        call decimal_div
+decimal_pow_return:
+        ret
+
+#
+# Convert the string pointed to by rdi into a decimal, which is stored
+# in [int].  The end of the number is stored at the location pointed to
+# by rsi.
+#
+# We accept integers, and numbers with no more than #PLACES decimal places.
+# If the string isn't a number, or is out of range, the start of the
+# string is stored as its end.
+#
+decimal_parse:
+        mov qword ptr [rsi], rdi
+        mov r8, rdi
+        xor r11, r11            # is the number negative?
+        cmp byte ptr [r8], '-'
+        jne decimal_parse_plus
+        inc r11
+        inc r8
+        jmp decimal_parse_integer
+decimal_parse_plus:
+        cmp byte ptr [r8], '+'
+        jne decimal_parse_integer
+        inc r8
+
+decimal_parse_integer:
+        xor rax, rax
+        mov r10, r8
+decimal_parse_digit:
+        movzx ecx, byte ptr [r8]
+        sub ecx, '0'
+        cmp ecx, 9
+        ja decimal_parse_point
+        imul rax, rax, 10
+        jo decimal_parse_failed
+        add rax, rcx
+        jo decimal_parse_failed
+        inc r8
+        jmp decimal_parse_digit
+
+decimal_parse_point:
+        cmp r8, r10
+        je decimal_parse_failed
+        mov r9, #PLACES         # the decimal places remaining
+        cmp byte ptr [r8], '.'
+        jne decimal_parse_scale
+        inc r8
+        mov r10, r8
+decimal_parse_fraction:
+        test r9, r9
+        jz decimal_parse_fractioned
+        movzx ecx, byte ptr [r8]
+        sub ecx, '0'
+        cmp ecx, 9
+        ja decimal_parse_fractioned
+        imul rax, rax, 10
+        jo decimal_parse_failed
+        add rax, rcx
+        jo decimal_parse_failed
+        dec r9
+        inc r8
+        jmp decimal_parse_fraction
+decimal_parse_fractioned:
+        cmp r8, r10
+        je decimal_parse_failed
+
+decimal_parse_scale:
+        test r9, r9
+        jz decimal_parse_done
+        imul rax, rax, 10
+        jo decimal_parse_failed
+        dec r9
+        jmp decimal_parse_scale
+
+decimal_parse_done:
+        test r11, r11
+        jz decimal_parse_store
+        neg rax
+decimal_parse_store:
+        mov qword ptr [rsi], r8
+        mov qword ptr [int], rax
+decimal_parse_failed:
+        ret
+`
+	text = strings.Replace(text, "#ROUND\n", c.decimalRounding(), -1)
+	text = strings.Replace(text, "#SCALE", fmt.Sprintf("%d", c.scale()), -1)
+	return strings.Replace(text, "#PLACES", fmt.Sprintf("%d", c.places), -1)
+}
+
+// decimalRounding returns the assembly code which rounds the quotient in
+// rax, given the remainder in rdx and the divisor in r9, according to our
+// rounding mode.
+//
+// Halves are rounded away from zero by "half-up", and to the nearest
+// even quotient by "half-even".
+func (c *Compiler) decimalRounding() string {
+	switch c.rounding {
+	case "truncate":
+		return `        # the quotient is rounded towards zero
+`
+	case "half-up":
+		return `        # the quotient is rounded to the nearest, with halves rounded up
+        add rdx, rdx
+        cmp rdx, r9
+        jb decimal_round_done
+        add rax, 1
+        jc register_overflow
+decimal_round_done:
+`
+	}
+	return `        # the quotient is rounded to the nearest, with halves rounded
+        # to the nearest even quotient
+        add rdx, rdx
+        cmp rdx, r9
+        jb decimal_round_done
+        ja decimal_round_up
+        test rax, 1
+        jz decimal_round_done
+decimal_round_up:
+        add rax, 1
+        jc register_overflow
+decimal_round_done:
+`
+}
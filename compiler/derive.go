@@ -0,0 +1,642 @@
+// derive.go contains the code which differentiates our program
+// symbolically, replacing its instructions with those of its derivative.
+
+package compiler
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/skx/math-compiler/instructions"
+	"github.com/skx/math-compiler/token"
+)
+
+// node is a part of an expression, which is either a constant, a runtime
+// input, or an operation upon the nodes which are its arguments.
+type node struct {
+
+	// op is the instruction which calculates this node.
+	op instructions.InstructionType
+
+	// value holds the literal of a constant, or the slot of a runtime
+	// input.
+	value string
+
+	// args holds the arguments of an operation, in the order they
+	// were pushed.
+	args []*node
+}
+
+// operators holds the word which is used to write each operation which
+// we can differentiate.
+var operators = map[instructions.InstructionType]string{
+	instructions.Plus:           token.PLUS,
+	instructions.Minus:          token.MINUS,
+	instructions.Multiply:       token.ASTERISK,
+	instructions.Divide:         token.SLASH,
+	instructions.Power:          token.POWER,
+	instructions.Modulus:        token.MOD,
+	instructions.FlooredModulus: token.FLOORMOD,
+	instructions.Abs:            token.ABS,
+	instructions.Cos:            token.COS,
+	instructions.Exp:            token.EXP,
+	instructions.Ln:             token.LN,
+	instructions.Sin:            token.SIN,
+	instructions.Sqrt:           token.SQRT,
+	instructions.Tan:            token.TAN,
+}
+
+// differentiate replaces our instructions with those which calculate the
+// derivative of our program, with respect to the runtime input we're
+// differentiating by.
+//
+// The program must calculate a single result, which is rebuilt as an
+// expression, so that it can be differentiated, and then simplified.
+func (c *Compiler) differentiate() error {
+
+	//
+	// The derivative of a value measured in a unit would be measured
+	// in another.
+	//
+	for _, tok := range c.tokens {
+		if tok.Type == token.UNIT {
+			return fmt.Errorf("values measured in units can't be differentiated")
+		}
+	}
+
+	stack := []*node{}
+	pop := func(n int) ([]*node, error) {
+		if len(stack) < n {
+			return nil, fmt.Errorf("there are insufficient values upon the stack to differentiate")
+		}
+		args := stack[len(stack)-n:]
+		stack = stack[:len(stack)-n]
+		return args, nil
+	}
+
+	for _, opr := range c.instructions {
+
+		switch opr.Type {
+
+		case instructions.Push, instructions.Input:
+			stack = append(stack, &node{op: opr.Type, value: opr.Value})
+
+		case instructions.Dup:
+			args, err := pop(1)
+			if err != nil {
+				return err
+			}
+			stack = append(stack, args[0], args[0])
+
+		case instructions.Swap:
+			args, err := pop(2)
+			if err != nil {
+				return err
+			}
+			stack = append(stack, args[1], args[0])
+
+		case instructions.Abs, instructions.Cos, instructions.Exp, instructions.Ln, instructions.Sin, instructions.Sqrt, instructions.Tan:
+			args, err := pop(1)
+			if err != nil {
+				return err
+			}
+			stack = append(stack, &node{op: opr.Type, args: []*node{args[0]}})
+
+		case instructions.Divide, instructions.FlooredModulus, instructions.Minus, instructions.Modulus, instructions.Multiply, instructions.Plus, instructions.Power:
+			args, err := pop(2)
+			if err != nil {
+				return err
+			}
+
+			// An operation upon constants is itself a constant.
+			if r := fold(opr.Type, args[0], args[1]); r != nil {
+				stack = append(stack, r)
+				continue
+			}
+			stack = append(stack, &node{op: opr.Type, args: []*node{args[0], args[1]}})
+
+		case instructions.FusedMultiplyAdd, instructions.FusedMultiplySubtract:
+			args, err := pop(3)
+			if err != nil {
+				return err
+			}
+			op := instructions.Plus
+			if opr.Type == instructions.FusedMultiplySubtract {
+				op = instructions.Minus
+			}
+			product := &node{op: instructions.Multiply, args: []*node{args[1], args[2]}}
+			stack = append(stack, &node{op: op, args: []*node{args[0], product}})
+
+		case instructions.Factorial:
+			return fmt.Errorf("'!' can't be differentiated")
+
+		case instructions.Rand, instructions.RandInt, instructions.RandNormal:
+			return fmt.Errorf("random numbers can't be differentiated")
+
+		case instructions.Integrate, instructions.Solve:
+			return fmt.Errorf("integrals, and roots, can't be differentiated")
+
+		default:
+			return fmt.Errorf("only a program which calculates a single result can be differentiated")
+		}
+	}
+
+	if len(stack) != 1 {
+		return fmt.Errorf("only a program which calculates a single result can be differentiated")
+	}
+
+	//
+	// Replace our program with its derivative, and its constants with
+	// those which the derivative uses.
+	//
+	c.formula = derivative(stack[0], fmt.Sprintf("%d", c.inputSlot(c.derive)))
+	c.instructions = nil
+	c.constants = make(map[string]bool)
+	c.flatten(c.formula)
+
+	if c.fma {
+		c.instructions = fuse(c.instructions)
+	}
+	return nil
+}
+
+// flatten appends the instructions which calculate the given expression
+// to our program.
+func (c *Compiler) flatten(n *node) {
+	for _, arg := range n.args {
+		c.flatten(arg)
+	}
+	if n.op == instructions.Push {
+		c.constants[n.value] = true
+	}
+	c.instructions = append(c.instructions,
+		instructions.Instruction{Type: n.op, Value: n.value})
+}
+
+// derivative returns the derivative of the given expression with respect
+// to the runtime input in the given slot.
+//
+// A derivative which doesn't exist everywhere, such as that of abs at
+// zero, is calculated wherever it does.
+func derivative(n *node, slot string) *node {
+
+	switch n.op {
+
+	case instructions.Push:
+		return constant("0")
+
+	case instructions.Input:
+		if n.value == slot {
+			return constant("1")
+		}
+		return constant("0")
+	}
+
+	// The derivative of a function of its first argument, u, and
+	// its second, v.
+	u := n.args[0]
+	du := derivative(u, slot)
+	var v, dv *node
+	if len(n.args) > 1 {
+		v = n.args[1]
+		dv = derivative(v, slot)
+	}
+
+	switch n.op {
+
+	case instructions.Abs:
+		// u' * u / abs(u)
+		return div(mul(du, u), n)
+
+	case instructions.Cos:
+		// -sin(u) * u'
+		return mul(constant("-1"), mul(function(instructions.Sin, u), du))
+
+	case instructions.Divide:
+		// u' / v, less u * v' / v^2
+		if isConstant(dv, "0") {
+			return div(du, v)
+		}
+		return div(sub(mul(du, v), mul(u, dv)), raise(v, constant("2")))
+
+	case instructions.Exp:
+		// exp(u) * u'
+		return mul(n, du)
+
+	case instructions.FlooredModulus, instructions.Modulus:
+		// u' - v' * q, where q is the quotient which was rounded
+		q := div(sub(u, n), v)
+		return sub(du, mul(dv, q))
+
+	case instructions.Ln:
+		// u' / u
+		return div(du, u)
+
+	case instructions.Minus:
+		return sub(du, dv)
+
+	case instructions.Multiply:
+		return add(mul(du, v), mul(u, dv))
+
+	case instructions.Plus:
+		return add(du, dv)
+
+	case instructions.Power:
+		// v * u^(v - 1) * u', for a constant exponent
+		if isConstant(dv, "0") {
+			return mul(mul(v, raise(u, sub(v, constant("1")))), du)
+		}
+		// u^v * ln(u) * v', for a constant base
+		if isConstant(du, "0") {
+			return mul(mul(n, function(instructions.Ln, u)), dv)
+		}
+		// u^v * (v' * ln(u) + v * u' / u)
+		return mul(n, add(mul(dv, function(instructions.Ln, u)), div(mul(v, du), u)))
+
+	case instructions.Sin:
+		// cos(u) * u'
+		return mul(function(instructions.Cos, u), du)
+
+	case instructions.Sqrt:
+		// u' / (2 * sqrt(u))
+		return div(du, mul(constant("2"), n))
+
+	case instructions.Tan:
+		// u' / cos(u)^2
+		return div(du, raise(function(instructions.Cos, u), constant("2")))
+	}
+	return nil
+}
+
+//
+// The constructors which follow simplify the expressions they build, by
+// folding constants and removing identities, such as adding zero.
+//
+
+// constant returns the constant with the given literal.
+func constant(value string) *node {
+	return &node{op: instructions.Push, value: value}
+}
+
+// function returns the application of the given function to u.
+func function(op instructions.InstructionType, u *node) *node {
+	return &node{op: op, args: []*node{u}}
+}
+
+// add returns the simplified sum of u and v.
+func add(u, v *node) *node {
+	switch {
+	case isConstant(u, "0"):
+		return v
+	case isConstant(v, "0"):
+		return u
+	case isNegative(v):
+		return sub(u, negate(v))
+	case same(u, v):
+		return mul(constant("2"), u)
+	}
+	if r := fold(instructions.Plus, u, v); r != nil {
+		return r
+	}
+	return &node{op: instructions.Plus, args: []*node{u, v}}
+}
+
+// sub returns the simplified difference of u and v.
+func sub(u, v *node) *node {
+	switch {
+	case isConstant(v, "0"):
+		return u
+	case isConstant(u, "0"):
+		return negate(v)
+	case isNegative(v):
+		return add(u, negate(v))
+	case same(u, v):
+		return constant("0")
+	}
+	if r := fold(instructions.Minus, u, v); r != nil {
+		return r
+	}
+	return &node{op: instructions.Minus, args: []*node{u, v}}
+}
+
+// mul returns the simplified product of u and v.
+//
+// Constant factors are moved to the front, where they're combined.
+func mul(u, v *node) *node {
+	switch {
+	case isConstant(u, "0") || isConstant(v, "0"):
+		return constant("0")
+	case isConstant(u, "1"):
+		return v
+	case isConstant(v, "1"):
+		return u
+	case same(u, v):
+		return raise(u, constant("2"))
+	}
+	if r := fold(instructions.Multiply, u, v); r != nil {
+		return r
+	}
+	if v.op == instructions.Push {
+		return mul(v, u)
+	}
+	if v.op == instructions.Multiply {
+		if f := fold(instructions.Multiply, u, v.args[0]); f != nil {
+			return mul(f, v.args[1])
+		}
+		if v.args[0].op == instructions.Push {
+			return mul(v.args[0], mul(u, v.args[1]))
+		}
+	}
+	if u.op == instructions.Multiply && u.args[0].op == instructions.Push {
+		return mul(u.args[0], mul(u.args[1], v))
+	}
+	return &node{op: instructions.Multiply, args: []*node{u, v}}
+}
+
+// div returns the simplified quotient of u and v.
+func div(u, v *node) *node {
+	switch {
+	case isConstant(u, "0"):
+		return constant("0")
+	case isConstant(v, "1"):
+		return u
+	case same(u, v):
+		return constant("1")
+	}
+	if r := fold(instructions.Divide, u, v); r != nil {
+		return r
+	}
+	return &node{op: instructions.Divide, args: []*node{u, v}}
+}
+
+// raise returns u raised to the simplified power v.
+func raise(u, v *node) *node {
+	switch {
+	case isConstant(v, "0") || isConstant(u, "1"):
+		return constant("1")
+	case isConstant(v, "1"):
+		return u
+	}
+	if r := fold(instructions.Power, u, v); r != nil {
+		return r
+	}
+	return &node{op: instructions.Power, args: []*node{u, v}}
+}
+
+// negate returns the simplified negation of u.
+func negate(u *node) *node {
+	return mul(constant("-1"), u)
+}
+
+// isConstant returns true if the given node is a constant, equal to the
+// given value.
+func isConstant(n *node, value string) bool {
+	if n.op != instructions.Push {
+		return false
+	}
+	r, ok := new(big.Rat).SetString(n.value)
+	want, _ := new(big.Rat).SetString(value)
+	return ok && r.Cmp(want) == 0
+}
+
+// same returns true if the given nodes are the same expression, allowing
+// for the arguments of a sum, or a product, to be given in either order.
+func same(u, v *node) bool {
+	if u.rpn(nil) == v.rpn(nil) {
+		return true
+	}
+	commutative := u.op == v.op && (u.op == instructions.Plus || u.op == instructions.Multiply)
+	return commutative && same(u.args[0], v.args[1]) && same(u.args[1], v.args[0])
+}
+
+// isNegative returns true if the given node is a negative constant, or
+// the negation of a value.
+func isNegative(n *node) bool {
+	if n.op == instructions.Multiply {
+		return isConstant(n.args[0], "-1")
+	}
+	if n.op != instructions.Push {
+		return false
+	}
+	r, ok := new(big.Rat).SetString(n.value)
+	return ok && r.Sign() < 0
+}
+
+// fold returns the constant result of the given operation upon u and v,
+// if both are constants, or fractions of constants.  Otherwise it
+// returns nil.
+//
+// A result which can't be written exactly as a decimal is written as a
+// fraction.
+func fold(op instructions.InstructionType, u, v *node) *node {
+	a, ok := fraction(u)
+	if !ok {
+		return nil
+	}
+	b, ok := fraction(v)
+	if !ok {
+		return nil
+	}
+
+	r := new(big.Rat)
+	switch op {
+	case instructions.Plus:
+		r.Add(a, b)
+	case instructions.Minus:
+		r.Sub(a, b)
+	case instructions.Multiply:
+		r.Mul(a, b)
+	case instructions.Divide:
+		if b.Sign() == 0 {
+			return nil
+		}
+		r.Quo(a, b)
+	case instructions.Power:
+		// only small, whole, powers are folded
+		if !b.IsInt() || !b.Num().IsInt64() || b.Num().Int64() > 64 || b.Num().Int64() < -64 {
+			return nil
+		}
+		n := b.Num().Int64()
+		if n < 0 && a.Sign() == 0 {
+			return nil
+		}
+		r.SetInt64(1)
+		for i := int64(0); i < n || i < -n; i++ {
+			r.Mul(r, a)
+		}
+		if n < 0 {
+			r.Inv(r)
+		}
+	default:
+		return nil
+	}
+
+	text, ok := exact(r)
+	if !ok {
+		return &node{op: instructions.Divide, args: []*node{constant(r.Num().String()), constant(r.Denom().String())}}
+	}
+	return constant(text)
+}
+
+// fraction returns the value of the given node, if it is a constant, or
+// the quotient of two integers.
+func fraction(n *node) (*big.Rat, bool) {
+	if n.op == instructions.Push {
+		return new(big.Rat).SetString(n.value)
+	}
+	if n.op != instructions.Divide || n.args[0].op != instructions.Push || n.args[1].op != instructions.Push {
+		return nil, false
+	}
+	a, ok := new(big.Rat).SetString(n.args[0].value)
+	if !ok || !a.IsInt() {
+		return nil, false
+	}
+	b, ok := new(big.Rat).SetString(n.args[1].value)
+	if !ok || !b.IsInt() || b.Sign() == 0 {
+		return nil, false
+	}
+	return a.Quo(a, b), true
+}
+
+// exact returns the given number as a decimal, if it can be written with
+// a finite number of decimal places.
+func exact(r *big.Rat) (string, bool) {
+	if r.IsInt() {
+		return r.Num().String(), true
+	}
+
+	// The denominator must only have the factors two and five.
+	d := new(big.Int).Set(r.Denom())
+	places := 0
+	for _, factor := range []int64{2, 5} {
+		n := 0
+		f := big.NewInt(factor)
+		m := new(big.Int)
+		for {
+			q, rem := new(big.Int).QuoRem(d, f, m)
+			if rem.Sign() != 0 {
+				break
+			}
+			d = q
+			n++
+		}
+		if n > places {
+			places = n
+		}
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return "", false
+	}
+	return r.FloatString(places), true
+}
+
+// rpn returns the expression in reverse Polish notation, which our
+// compiler accepts, naming each runtime input with the given function.
+func (n *node) rpn(name func(slot string) string) string {
+	switch n.op {
+	case instructions.Push:
+		return n.value
+	case instructions.Input:
+		if name == nil {
+			return "$" + n.value
+		}
+		return name(n.value)
+	}
+
+	words := []string{}
+	for _, arg := range n.args {
+		words = append(words, arg.rpn(name))
+	}
+	return strings.Join(append(words, operators[n.op]), " ")
+}
+
+// infix returns the expression in infix notation, naming each runtime
+// input with the given function, along with the precedence of its
+// outermost operation.
+//
+// Parentheses are only used where they're required, and "-1 * u" is
+// written as "-u".
+func (n *node) infix(name func(slot string) string) (string, int) {
+
+	// The precedence of each kind of node, from lowest to highest.
+	const (
+		sum = iota + 1
+		product
+		negation
+		exponent
+		atom
+	)
+
+	switch n.op {
+	case instructions.Push:
+		if isNegative(n) {
+			return n.value, negation
+		}
+		return n.value, atom
+	case instructions.Input:
+		return name(n.value), atom
+	case instructions.Plus, instructions.Minus:
+		return n.binary(name, sum, " "+operators[n.op]+" ")
+	case instructions.Divide, instructions.FlooredModulus, instructions.Modulus:
+		return n.binary(name, product, " "+operators[n.op]+" ")
+	case instructions.Multiply:
+		if isConstant(n.args[0], "-1") {
+			text, p := n.args[1].infix(name)
+			if p <= negation {
+				text = "(" + text + ")"
+			}
+			return "-" + text, negation
+		}
+		return n.binary(name, product, " * ")
+	case instructions.Power:
+		// "^" is right-associative.
+		left, lp := n.args[0].infix(name)
+		right, rp := n.args[1].infix(name)
+		if lp <= exponent {
+			left = "(" + left + ")"
+		}
+		if rp < exponent {
+			right = "(" + right + ")"
+		}
+		return left + "^" + right, exponent
+	}
+
+	text, _ := n.args[0].infix(name)
+	return operators[n.op] + "(" + text + ")", atom
+}
+
+// binary returns the infix notation of a binary operation, with the given
+// precedence, which is written as the given operator.
+//
+// Operations of the same precedence are calculated from left to right, so
+// the operand on the right is parenthesized unless it's the same sum, or
+// product, as the operation itself.
+func (n *node) binary(name func(slot string) string, precedence int, operator string) (string, int) {
+	left, lp := n.args[0].infix(name)
+	right, rp := n.args[1].infix(name)
+	if lp < precedence {
+		left = "(" + left + ")"
+	}
+	same := n.op == n.args[1].op && (n.op == instructions.Plus || n.op == instructions.Multiply)
+	if rp < precedence || (rp == precedence && !same) {
+		right = "(" + right + ")"
+	}
+	return left + operator + right, precedence
+}
+
+// formulaText returns the expression which our program calculates, once
+// it has been differentiated, either in reverse Polish notation or in
+// infix notation.
+func (c *Compiler) formulaText() string {
+	name := func(slot string) string {
+		n, _ := strconv.Atoi(slot)
+		return c.inputName(n)
+	}
+	if c.infix {
+		text, _ := c.formula.infix(name)
+		return text
+	}
+	return c.formula.rpn(name)
+}
@@ -0,0 +1,482 @@
+// dual.go contains the code for calculating derivatives, alongside our
+// values, with dual numbers.
+
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skx/math-compiler/instructions"
+)
+
+// gradient returns true if we're calculating derivatives, in which case
+// each entry upon our stack is a value followed by its derivative with
+// respect to each of the inputs we differentiate by.
+func (c *Compiler) gradient() bool {
+	return len(c.grad) > 0
+}
+
+// checkGrad ensures that each of the inputs we differentiate by is a
+// runtime input, which is given at most once.
+func (c *Compiler) checkGrad() error {
+	for i, name := range c.grad {
+		if !c.isInput(name) {
+			return fmt.Errorf("'%s' isn't a runtime input, so it can't be differentiated by", name)
+		}
+		for _, prev := range c.grad[:i] {
+			if c.inputSlot(prev) == c.inputSlot(name) {
+				return fmt.Errorf("the input '%s' was differentiated by twice", name)
+			}
+		}
+	}
+	return nil
+}
+
+// genOperands generates the assembly code which keeps a copy of the
+// operand(s) of the given instruction, along with their derivatives,
+// before its value is calculated.
+//
+// The topmost operand is copied to [dual_a], and the one beneath it to
+// [dual_b].
+func (c *Compiler) genOperands(opr instructions.Instruction) string {
+	args := 0
+	switch opr.Type {
+	case instructions.Abs, instructions.Cos, instructions.Exp, instructions.Ln, instructions.Sin, instructions.Sqrt, instructions.Tan:
+		args = 1
+	case instructions.Divide, instructions.FlooredModulus, instructions.Minus, instructions.Modulus, instructions.Multiply, instructions.Plus, instructions.Power:
+		args = 2
+	default:
+		return ""
+	}
+
+	text := fmt.Sprintf(`
+        # [OPERANDS]
+        # keep a copy of the operand(s), and their derivatives
+        mov rax, qword ptr [depth]
+        cmp rax, %d
+        jb stack_error
+`, args)
+	for k := 0; k <= len(c.grad); k++ {
+		text += fmt.Sprintf(`        mov rax, qword ptr [rsp + %d]
+        mov qword ptr [dual_a + %d], rax
+`, 8*k, 8*k)
+		if args > 1 {
+			text += fmt.Sprintf(`        mov rax, qword ptr [rsp + %d]
+        mov qword ptr [dual_b + %d], rax
+`, c.slotSize()+8*k, 8*k)
+		}
+	}
+	return text
+}
+
+// genDual generates the assembly code which calculates the derivatives of
+// the result of the given instruction, which is upon the top of our stack,
+// by the chain rule.
+//
+// Each rule gives the partial derivatives of the result, with respect to
+// its operands, which are stored in [partial_a] and [partial_b].
+func (c *Compiler) genDual(opr instructions.Instruction) string {
+
+	switch opr.Type {
+
+	case instructions.Abs:
+		return c.dualUnary("ABS", `        # the sign of the operand
+        mov rax, 0x3FF0000000000000
+        mov rcx, qword ptr [dual_a]
+        shr rcx, 63
+        shl rcx, 63
+        or rax, rcx
+        mov qword ptr [partial_a], rax
+`)
+
+	case instructions.Cos:
+		return c.dualUnary("COS", `        # -sin(a)
+        movsd xmm0, qword ptr [dual_a]
+        lea rax, sin
+        call call_aligned
+        movsd qword ptr [partial_a], xmm0
+        btc qword ptr [partial_a], 63
+`)
+
+	case instructions.Divide:
+		return c.dualBinary("DIVIDE", `        # 1 / a, and -result / a
+        mov rax, 0x3FF0000000000000
+        movq xmm0, rax
+        divsd xmm0, qword ptr [dual_a]
+        movsd qword ptr [partial_b], xmm0
+        mulsd xmm0, qword ptr [rsp]
+        movsd qword ptr [partial_a], xmm0
+        btc qword ptr [partial_a], 63
+`)
+
+	case instructions.Exp:
+		return c.dualUnary("EXP", `        # the result
+        mov rax, qword ptr [rsp]
+        mov qword ptr [partial_a], rax
+`)
+
+	case instructions.FlooredModulus, instructions.Modulus:
+		return c.dualBinary("MODULUS", `        # 1, and minus the quotient which was rounded
+        mov rax, 0x3FF0000000000000
+        mov qword ptr [partial_b], rax
+        movsd xmm0, qword ptr [dual_b]
+        subsd xmm0, qword ptr [rsp]
+        divsd xmm0, qword ptr [dual_a]
+        movsd qword ptr [partial_a], xmm0
+        fld qword ptr [partial_a]
+        frndint
+        fchs
+        fstp qword ptr [partial_a]
+`)
+
+	case instructions.Ln:
+		return c.dualUnary("LN", `        # 1 / a
+        mov rax, 0x3FF0000000000000
+        movq xmm0, rax
+        divsd xmm0, qword ptr [dual_a]
+        movsd qword ptr [partial_a], xmm0
+`)
+
+	case instructions.Minus:
+		return c.dualBinary("MINUS", `        # 1, and -1
+        mov rax, 0x3FF0000000000000
+        mov qword ptr [partial_b], rax
+        mov rax, 0xBFF0000000000000
+        mov qword ptr [partial_a], rax
+`)
+
+	case instructions.Multiply:
+		return c.dualBinary("MULTIPLY", `        # a, and b
+        mov rax, qword ptr [dual_a]
+        mov qword ptr [partial_b], rax
+        mov rax, qword ptr [dual_b]
+        mov qword ptr [partial_a], rax
+`)
+
+	case instructions.Plus:
+		return c.dualBinary("PLUS", `        # 1, and 1
+        mov rax, 0x3FF0000000000000
+        mov qword ptr [partial_b], rax
+        mov qword ptr [partial_a], rax
+`)
+
+	case instructions.Power:
+		return c.dualBinary("POWER", `        # a * b^(a - 1), and result * ln(b)
+        movsd xmm0, qword ptr [dual_b]
+        movsd xmm1, qword ptr [dual_a]
+        mov rax, 0x3FF0000000000000
+        movq xmm2, rax
+
+        # if a is zero so is the first, even if b^(a - 1) isn't finite,
+        # so we use a base of one instead
+        xorpd xmm3, xmm3
+        cmpeqsd xmm3, xmm1
+        movapd xmm4, xmm3
+        andpd xmm4, xmm2
+        andnpd xmm3, xmm0
+        orpd xmm3, xmm4
+        movapd xmm0, xmm3
+
+        subsd xmm1, xmm2
+        lea rax, pow
+        call call_aligned
+        mulsd xmm0, qword ptr [dual_a]
+        movsd qword ptr [partial_b], xmm0
+        movsd xmm0, qword ptr [dual_b]
+        lea rax, log
+        call call_aligned
+        mulsd xmm0, qword ptr [rsp]
+        movsd qword ptr [partial_a], xmm0
+`)
+
+	case instructions.Rand, instructions.RandInt, instructions.RandNormal:
+		text := `
+        # [DERIVATIVE]
+        # a random number doesn't depend upon our inputs
+`
+		for k := 1; k <= len(c.grad); k++ {
+			text += fmt.Sprintf("        mov qword ptr [rsp + %d], 0\n", 8*k)
+		}
+		return text
+
+	case instructions.Sin:
+		return c.dualUnary("SIN", `        # cos(a)
+        movsd xmm0, qword ptr [dual_a]
+        lea rax, cos
+        call call_aligned
+        movsd qword ptr [partial_a], xmm0
+`)
+
+	case instructions.Sqrt:
+		return c.dualUnary("SQRT", `        # 0.5 / result
+        movsd xmm0, qword ptr [half]
+        divsd xmm0, qword ptr [rsp]
+        movsd qword ptr [partial_a], xmm0
+`)
+
+	case instructions.Tan:
+		return c.dualUnary("TAN", `        # 1 + result^2
+        movsd xmm0, qword ptr [rsp]
+        mulsd xmm0, xmm0
+        mov rax, 0x3FF0000000000000
+        movq xmm1, rax
+        addsd xmm0, xmm1
+        movsd qword ptr [partial_a], xmm0
+`)
+
+	}
+	return ""
+}
+
+// dualUnary returns the assembly code which calculates the derivatives
+// of the result of a function, given the calculation of its partial
+// derivative with respect to [dual_a].
+func (c *Compiler) dualUnary(name string, partial string) string {
+	return c.dualChain(name, partial, false)
+}
+
+// dualBinary returns the assembly code which calculates the derivatives
+// of the result of an operation upon [dual_b] and [dual_a], given the
+// calculation of its partial derivatives with respect to both.
+func (c *Compiler) dualBinary(name string, partial string) string {
+	return c.dualChain(name, partial, true)
+}
+
+// dualChain returns the assembly code which calculates the partial
+// derivatives of a result, and applies the chain rule to find each of its
+// derivatives.
+//
+// A derivative of an operand which is zero contributes nothing, even if
+// the partial derivative is infinite or not a number; so constants don't
+// make the derivative of a result undefined, although a derivative that
+// doesn't exist, such as that of sqrt at zero, is infinite or not a number.
+//
+// The value has been checked for errors by this point, and its derivatives
+// are checked in the same way, by our floating-point error policy.
+func (c *Compiler) dualChain(name string, partial string, binary bool) string {
+	text := `
+        # [DERIVATIVE OF #NAME]
+        # the partial derivative(s) of the result
+#PARTIAL
+        # the derivatives of the result, by the chain rule
+        xorpd xmm7, xmm7
+        movsd xmm6, qword ptr [partial_a]
+        movsd xmm5, qword ptr [partial_b]
+`
+	text = strings.Replace(text, "#NAME", name, -1)
+	text = strings.Replace(text, "#PARTIAL\n", partial, -1)
+
+	for k := 1; k <= len(c.grad); k++ {
+		chain := `        movsd xmm0, qword ptr [dual_a + #D]
+        movapd xmm1, xmm0
+        cmpneqsd xmm1, xmm7
+        mulsd xmm0, xmm6
+        andpd xmm0, xmm1
+`
+		if binary {
+			chain += `        movsd xmm2, qword ptr [dual_b + #D]
+        movapd xmm3, xmm2
+        cmpneqsd xmm3, xmm7
+        mulsd xmm2, xmm5
+        andpd xmm2, xmm3
+        addsd xmm0, xmm2
+`
+		}
+		chain += `        movsd qword ptr [rsp + #D], xmm0
+`
+		text += strings.Replace(chain, "#D", fmt.Sprintf("%d", 8*k), -1)
+	}
+	return text + c.dualChecks()
+}
+
+// dualChecks returns the assembly code which applies our floating-point
+// error policy to the derivatives upon the top of our stack.
+//
+// Derivatives can't be saturated, so they're either left alone, or
+// reported as errors.
+func (c *Compiler) dualChecks() string {
+	if c.fpErrors == "ieee" {
+		return ""
+	}
+
+	text := `        # check the derivatives, as we did the value
+`
+	for k := 1; k <= len(c.grad); k++ {
+		text += fmt.Sprintf(`        mov rax, qword ptr [rsp + %d]
+        call dual_check
+`, 8*k)
+	}
+	return text
+}
+
+// dualInputs returns the declaration of the storage for our runtime
+// inputs, in which the derivative of each input we differentiate by,
+// with respect to itself, is one.
+func (c *Compiler) dualInputs() string {
+	text := ""
+	for i := 1; i <= c.arguments; i++ {
+		parts := []string{"0.0"}
+		for _, name := range c.grad {
+			if c.inputSlot(name) == i {
+				parts = append(parts, "1.0")
+			} else {
+				parts = append(parts, "0.0")
+			}
+		}
+		label := "            "
+		if i == 1 {
+			label = "     inputs:"
+		}
+		text += fmt.Sprintf("%s .double %s\n", label, strings.Join(parts, ", "))
+	}
+	return text
+}
+
+// genDualData generates the data-area entries which are used to print
+// derivatives, and to calculate them.
+func (c *Compiler) genDualData() string {
+	text := fmt.Sprintf(`
+#
+# Derivatives.
+#
+#     dual_a: a copy of the topmost operand, and its derivatives.
+#
+#     dual_b: a copy of the operand beneath it, and its derivatives.
+#
+#  partial_a: the partial derivative of a result, with respect to dual_a.
+#
+#  partial_b: the partial derivative of a result, with respect to dual_b.
+#
+# dual_start: printed before a value.
+#
+#     dual_N: printed before the derivative with respect to the N'th
+#             input we differentiate by.
+#
+#   dual_end: printed after the derivatives.
+#
+     dual_a: .fill %d, 8, 0
+     dual_b: .fill %d, 8, 0
+  partial_a: .double 0.0
+  partial_b: .double 0.0
+`, len(c.grad)+1, len(c.grad)+1)
+
+	start := ""
+	end := ")"
+	if c.json {
+		start = `{\"value\": `
+		end = "}}"
+	}
+	text += fmt.Sprintf("dual_start: .asciz \"%s\"\n", start)
+	for k, name := range c.grad {
+		label := fmt.Sprintf(", d/d%s ", name)
+		if k == 0 {
+			label = fmt.Sprintf(" (d/d%s ", name)
+		}
+		if c.json {
+			label = fmt.Sprintf(`, \"%s\": `, name)
+			if k == 0 {
+				label = fmt.Sprintf(`, \"gradient\": {\"%s\": `, name)
+			}
+		}
+		text += fmt.Sprintf("    dual_%d: .asciz \"%s\"\n", k, label)
+	}
+	text += fmt.Sprintf("  dual_end: .asciz \"%s\"\n", end)
+	return text
+}
+
+// genDualHelpers generates the subroutines which print a value, along
+// with its derivatives.
+func (c *Compiler) genDualHelpers() string {
+	text := `
+#
+# Print the value pointed to by rax, followed by its derivatives, without
+# a label or a newline.
+#
+print_number:
+        push rbp
+        mov rbp, rsp
+        push rbx
+        and rsp, -16
+        mov rbx, rax
+        lea rdi,dual_start
+        xor rax, rax
+        call printf
+        movsd xmm0, qword ptr [rbx]
+        call print_component
+`
+	for k := range c.grad {
+		text += fmt.Sprintf(`        lea rdi,dual_%d
+        xor rax, rax
+        call printf
+        movsd xmm0, qword ptr [rbx + %d]
+        call print_component
+`, k, 8*(k+1))
+	}
+	text += `        lea rdi,dual_end
+        xor rax, rax
+        call printf
+        mov rbx, qword ptr [rbp - 8]
+        mov rsp, rbp
+        pop rbp
+        ret
+
+#
+# Report a derivative in rax which is infinite, or not a number, as an
+# error - as fp_exception would for a value.
+#
+dual_check:
+        btr rax, 63
+        mov rcx, 0x7FF0000000000000
+        cmp rax, rcx
+        ja domain_error         # NaN
+        je register_overflow    # infinity
+        ret
+
+#
+# Print the value, or derivative, in xmm0.
+#
+print_component:
+        push rbp
+        mov rbp, rsp
+        and rsp, -16
+#UNIT
+        lea rdi,number_fmt
+        movq rax, xmm0
+        btr rax, 63
+        mov rcx, 0x7FF0000000000000
+        cmp rax, rcx
+#NONFINITE
+print_component_now:
+        mov rax, 1
+        call printf
+        mov rsp, rbp
+        pop rbp
+        ret
+`
+
+	//
+	// Values are measured in SI base units, which we might convert.
+	//
+	unit := ""
+	if c.unit != "" {
+		unit = `        # convert the value to our output unit
+        divsd xmm0, qword ptr [unit_scale]
+`
+	}
+	text = strings.Replace(text, "#UNIT\n", unit, -1)
+
+	//
+	// A NaN is printed without a sign, and JSON can't represent values
+	// which aren't finite.
+	//
+	nonfinite := `        jbe print_component_now
+        movq xmm0, rax
+`
+	if c.json {
+		nonfinite = `        jb print_component_now
+        lea rdi,null_fmt
+`
+	}
+	return strings.Replace(text, "#NONFINITE\n", nonfinite, -1)
+}
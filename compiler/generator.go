@@ -25,11 +25,300 @@ func (c *Compiler) escapeConstant(input string) string {
 
 	// remove periods
 	val = strings.Replace(val, ".", "_", -1)
+	// exponents might be signed, and "1e+5" must differ from "1e5"
+	val = strings.Replace(val, "e-", "e_neg_", -1)
+	val = strings.Replace(val, "E-", "E_neg_", -1)
+	val = strings.Replace(val, "+", "_pos_", -1)
 	// remove minus-signs
 	val = strings.Replace(val, "-", "", -1)
 	return val
 }
 
+// fpChecks applies our floating-point error policy to the given
+// assembly code, by replacing the "#CLEAR" and "#CHECK" lines.
+//
+// "#CLEAR" comes before a calculation, and "#CHECK" follows the store
+// of its result to [a].
+func (c *Compiler) fpChecks(text string) string {
+	clear := ""
+	check := ""
+
+	switch c.fpErrors {
+	case "ieee":
+		// infinities and NaNs are left alone.
+	case "saturate":
+		check = `        # an infinite result is clamped to the largest finite value
+        call fp_saturate
+`
+	default:
+		clear = `        # discard any pending floating-point exceptions
+        fclex
+`
+		check = `        # report invalid operations, zero-divides, and overflows
+        fstsw ax
+        test al, 0x0D
+        jnz fp_exception
+`
+
+		//
+		// SSE, and so the C library, reports the same exceptions,
+		// in the same bits, of the MXCSR register.
+		//
+		if c.useLibrary() {
+			clear = `        # discard any pending floating-point exceptions
+        fclex
+        stmxcsr dword ptr [mxcsr]
+        and dword ptr [mxcsr], -64
+        ldmxcsr dword ptr [mxcsr]
+`
+			check = `        # report invalid operations, zero-divides, and overflows
+        fstsw ax
+        stmxcsr dword ptr [mxcsr]
+        or al, byte ptr [mxcsr]
+        test al, 0x0D
+        jnz fp_exception
+`
+		}
+	}
+
+	text = strings.Replace(text, "        #CLEAR\n", clear, -1)
+	text = strings.Replace(text, "        #CHECK\n", check, -1)
+	return text
+}
+
+// slotSize returns the size, in bytes, of each entry upon our stack.
+func (c *Compiler) slotSize() int {
+	if c.gradient() {
+		return 8 * (len(c.grad) + 1)
+	}
+	if c.pairs() {
+		return 16
+	}
+	switch c.precision {
+	case "single":
+		return 4
+	case "extended":
+		return 16
+	}
+	return 8
+}
+
+// precise expands the placeholders which depend upon our working
+// precision, in the given assembly code:
+//
+//   #PTR    - the size of a value in memory, such as "qword ptr".
+//   #SIZE   - the size of a stack-entry.
+//   #REAL   - the directive used to declare a value.
+//   #POP x  - pop the topmost stack-entry into the memory at x.
+//   #PUSH x - push the value in the memory at x onto the stack.
+//   #LOAD x - load the value at x, which is to be printed.
+//   #STORE x - store a value which was read, by #STRTOD, at x.
+func (c *Compiler) precise(text string) string {
+
+	ptr := "qword ptr"
+	real := ".double"
+	pop := `pop rax
+        mov qword ptr [#X], rax`
+	push := `mov rax, qword ptr [#X]
+        push rax`
+	load := `fld #PTR #X`
+	store := `fstp #PTR #X`
+
+	//
+	// Integers are printed from rax, and read via [int].  Big integers
+	// are handled the same way, via pointers, as are decimals.
+	//
+	if c.integral() || c.mode == "decimal" {
+		real = ".quad"
+		load = `mov rax, qword ptr #X`
+		store = `mov rsi, qword ptr [int]
+        mov qword ptr #X, rsi`
+	}
+
+	switch c.precision {
+	case "single":
+		ptr = "dword ptr"
+		real = ".float"
+		pop = `mov eax, dword ptr [rsp]
+        add rsp, 4
+        mov dword ptr [#X], eax`
+		push = `sub rsp, 4
+        mov eax, dword ptr [#X]
+        mov dword ptr [rsp], eax`
+	case "extended":
+		ptr = "tbyte ptr"
+		real = ".tfloat"
+		pop = `pop rax
+        mov qword ptr [#X], rax
+        pop rax
+        mov qword ptr [#X + 8], rax`
+		push = `push qword ptr [#X + 8]
+        push qword ptr [#X]`
+	}
+
+	//
+	// Rationals, complex numbers, and intervals, are pairs of values
+	// which are printed via a pointer in rax, and read via [a].
+	//
+	if c.mode == "rational" {
+		real = ".quad"
+	}
+	if c.pairs() {
+		pop = `pop rax
+        mov qword ptr [#X], rax
+        pop rax
+        mov qword ptr [#X + 8], rax`
+		push = `push qword ptr [#X + 8]
+        push qword ptr [#X]`
+		load = `lea rax, #X`
+		store = `lea rdi, #X
+        movups xmm0, xmmword ptr [a]
+        movups xmmword ptr [rdi], xmm0`
+	}
+
+	//
+	// Values with derivatives are printed via a pointer in rax, and
+	// only their value is read - the derivatives of our inputs are
+	// already in place.
+	//
+	if c.gradient() {
+		pop = ""
+		push = ""
+		for k := 0; k <= len(c.grad); k++ {
+			pop += fmt.Sprintf(`pop rax
+        mov qword ptr [#X + %d], rax
+        `, 8*k)
+			push = fmt.Sprintf(`push qword ptr [#X + %d]
+        `, 8*k) + push
+		}
+		pop = strings.TrimSpace(pop)
+		push = strings.TrimSpace(push)
+		load = `lea rax, #X`
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#POP ") {
+			lines[i] = "        " + strings.Replace(pop, "#X", strings.TrimPrefix(trimmed, "#POP "), -1)
+		}
+		if strings.HasPrefix(trimmed, "#PUSH ") {
+			lines[i] = "        " + strings.Replace(push, "#X", strings.TrimPrefix(trimmed, "#PUSH "), -1)
+		}
+		if strings.HasPrefix(trimmed, "#LOAD ") {
+			lines[i] = "        " + strings.Replace(load, "#X", strings.TrimPrefix(trimmed, "#LOAD "), -1)
+		}
+		if strings.HasPrefix(trimmed, "#STORE ") {
+			lines[i] = "        " + strings.Replace(store, "#X", strings.TrimPrefix(trimmed, "#STORE "), -1)
+		}
+	}
+	text = strings.Join(lines, "\n")
+
+	text = strings.Replace(text, "#PTR", ptr, -1)
+	text = strings.Replace(text, "#SIZE", fmt.Sprintf("%d", c.slotSize()), -1)
+	text = strings.Replace(text, "#REAL", real, -1)
+	return text
+}
+
+// arithmetic returns the assembly code which calculates "[b] op [a]",
+// storing the result in [a].  The operation is one of "add", "sub",
+// "mul", or "div".
+func (c *Compiler) arithmetic(op string) string {
+	if c.fpu == "sse" {
+		text := `        movSUFFIX xmm0, #PTR [b]
+        OPSUFFIX xmm0, #PTR [a]
+        movSUFFIX #PTR [a], xmm0
+`
+		text = strings.Replace(text, "SUFFIX", c.sseSuffix(), -1)
+		return strings.Replace(text, "OP", op, -1)
+	}
+
+	text := `        fld #PTR [b]
+        fld #PTR [a]
+        fOPp st(1), st(0)
+        fstp #PTR [a]
+`
+	return strings.Replace(text, "OP", op, -1)
+}
+
+// sseSuffix returns the suffix of the scalar SSE instructions which
+// operate upon values of our working precision, for example "sd" as
+// in "addsd".
+func (c *Compiler) sseSuffix() string {
+	if c.precision == "single" {
+		return "ss"
+	}
+	return "sd"
+}
+
+// libraryCall returns the assembly code which calls the named function
+// from the C library, with [a] as its argument, storing the result in [a].
+// If the function takes two arguments then [b] is the second.
+//
+// If our working precision is single the float-version of the function
+// is called, for example "sinf" rather than "sin", and if it is extended
+// the long double version, "sinl".
+func (c *Compiler) libraryCall(name string, args int) string {
+	if c.precision == "extended" {
+		return strings.Replace(`        lea rax, NAMEl
+        call call_long
+`, "NAME", name, -1)
+	}
+
+	text := `        movSUFFIX xmm0, #PTR [a]
+`
+	if args > 1 {
+		text += `        movSUFFIX xmm1, #PTR [b]
+`
+	}
+	text += `        lea rax, NAME
+        call call_aligned
+        movSUFFIX #PTR [a], xmm0
+`
+	if c.precision == "single" {
+		name += "f"
+	}
+	text = strings.Replace(text, "SUFFIX", c.sseSuffix(), -1)
+	return strings.Replace(text, "NAME", name, -1)
+}
+
+// useLibrary returns true if the transcendental functions should be
+// calculated by the C library, rather than the FPU.  This is always the
+// case for complex numbers.
+func (c *Compiler) useLibrary() bool {
+	return c.fpu == "sse" || c.math == "accurate" || c.mode == "complex"
+}
+
+// integral returns true if we're calculating with integers, of either
+// kind.
+func (c *Compiler) integral() bool {
+	return c.mode == "int64" || c.mode == "bignum"
+}
+
+// pairs returns true if each of our values is a pair of numbers, such as
+// the numerator and denominator of a rational.
+func (c *Compiler) pairs() bool {
+	return c.mode == "rational" || c.mode == "complex" || c.mode == "interval"
+}
+
+// trapping returns true if floating-point errors should be reported.
+func (c *Compiler) trapping() bool {
+	return c.fpErrors == "" || c.fpErrors == "trap"
+}
+
+// zeroCheck is the assembly code which reports a division by zero if the
+// value in [a] is zero - of either sign.
+const zeroCheck = `
+        # a zero divisor, of either sign, is a division by zero
+        fld #PTR [a]
+        fxam
+        fstsw ax
+        fstp st(0)
+        and ah, 0x45            # C3, C2, and C0
+        cmp ah, 0x40            # zero
+        je division_by_zero
+`
+
 // genAbs generates assembly code to pop a value from the stack,
 // run an ABS-operation, and store the result back on the stack.
 func (c *Compiler) genAbs() string {
@@ -41,26 +330,63 @@ func (c *Compiler) genAbs() string {
         jb stack_error
 
         # pop one value
-        pop rax
-        mov qword ptr [a], rax
+        #POP a
 
         # abs
-        fld qword ptr [a]
+        fld #PTR [a]
         fabs
-        fstp qword ptr [a]
+        fstp #PTR [a]
 
         # push result onto stack
-        mov rax, qword ptr [a]
-        push rax
+        #PUSH a
 
         # stack size didn't change; popped one, pushed one.
 `
 }
 
+// genArguments generates the assembly code which reads each of the
+// runtime inputs from the command-line, via parse_number, and stores them
+// in the [inputs] array.
+//
+// Inputs which take their values from a range aren't read.
+func (c *Compiler) genArguments() string {
+	text := `
+        # [ARGUMENTS]
+        # We expect #COUNT input(s) upon the command-line.
+        mov qword ptr [argv], rsi
+        cmp rdi, #ARGC
+        jne usage_error
+`
+	count := c.arguments - len(c.spans)
+	text = strings.Replace(text, "#COUNT", fmt.Sprintf("%d", count), -1)
+	text = strings.Replace(text, "#ARGC", fmt.Sprintf("%d", count+1), -1)
+
+	offset := 0
+	for i := 1; i <= c.arguments; i++ {
+		if c.ranged(i) {
+			continue
+		}
+		offset += 8
+
+		arg := `
+        # Read input #NAME
+        mov rax, qword ptr [argv]
+        mov rdi, qword ptr [rax + #OFFSET]
+        call parse_number
+        #STORE [inputs + #SLOT]
+`
+		arg = strings.Replace(arg, "#NAME", c.inputName(i), -1)
+		arg = strings.Replace(arg, "#OFFSET", fmt.Sprintf("%d", offset), -1)
+		arg = strings.Replace(arg, "#SLOT", fmt.Sprintf("%d", (i-1)*c.slotSize()), -1)
+		text += arg
+	}
+	return text
+}
+
 // genCos generates assembly code to pop a value from the stack,
 // run a cos-operation, and store the result back on the stack.
 func (c *Compiler) genCos() string {
-	return `
+	text := `
         # [COS]
         # ensure there is at least one argument on the stack
         mov rax, qword ptr [depth]
@@ -68,26 +394,33 @@ func (c *Compiler) genCos() string {
         jb stack_error
 
         # pop one value
-        pop rax
-        mov qword ptr [a], rax
+        #POP a
 
         # cos
-        fld qword ptr [a]
-        fcos
-        fstp qword ptr [a]
+        #CLEAR
+#FUNCTION
+        #CHECK
 
         # push result onto stack
-        mov rax, qword ptr [a]
-        push rax
+        #PUSH a
 
         # stack size didn't change; popped one, pushed one.
 `
+
+	fn := `        fld #PTR [a]
+        fcos
+        fstp #PTR [a]
+`
+	if c.useLibrary() {
+		fn = c.libraryCall("cos", 1)
+	}
+	return c.fpChecks(strings.Replace(text, "#FUNCTION\n", fn, -1))
 }
 
 // genDivide generates assembly code to pop two values from the stack,
 // divide them and store the result back on the stack.
 func (c *Compiler) genDivide() string {
-	return `
+	text := `
         # [DIVIDE]
         # ensure there are at least two arguments on the stack
         mov rax, qword ptr [depth]
@@ -95,27 +428,34 @@ func (c *Compiler) genDivide() string {
         jb stack_error
 
         # pop two values
-        pop rax
-        cmp rax,0
-        je division_by_zero
-        mov qword ptr [a], rax
-        pop rax
-        mov qword ptr [b], rax
+        #POP a
+#ZERO
+        #POP b
 
         # divide
-        fld qword ptr [b]
-        fdiv qword ptr  [a]
-        fstp qword ptr [a]
+        #CLEAR
+#ARITHMETIC
+        #CHECK
 
         # push the result back onto the stack
-        mov rax, qword ptr [a]
-        push rax
+        #PUSH a
 
         # we took two values from the stack, but added one
         # so the net result is the stack shrunk by one.
         dec qword ptr [depth]
 `
 
+	//
+	// Unless we're trapping errors a division by zero results in
+	// an infinity, or a NaN.
+	//
+	zero := "\n"
+	if c.trapping() {
+		zero = zeroCheck
+	}
+	text = strings.Replace(text, "\n#ZERO\n", zero, -1)
+	text = strings.Replace(text, "#ARITHMETIC\n", c.arithmetic("div"), -1)
+	return c.fpChecks(text)
 }
 
 // genDup generates assembly code to pop a value from the stack and
@@ -128,15 +468,63 @@ func (c *Compiler) genDup() string {
         cmp rax, 1
         jb stack_error
 
-        pop rax
-        push rax
-        push rax
+        #POP a
+        #PUSH a
+        #PUSH a
 
         # We've added a new entry to the stack.
         inc qword ptr [depth]
 `
 }
 
+// genExp generates assembly code to pop a value from the stack, raise e
+// to its power, and store the result back on the stack.
+func (c *Compiler) genExp() string {
+	text := `
+        # [EXP]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # pop one value
+        #POP a
+
+        # exp
+        #CLEAR
+#FUNCTION
+        #CHECK
+
+        # push result onto stack
+        #PUSH a
+
+        # stack size didn't change; popped one, pushed one.
+`
+
+	//
+	// e^x is 2^(x * log2(e)), which we split into an integer power
+	// of two, applied by fscale, and a fraction, applied by f2xm1.
+	//
+	fn := `        fld #PTR [a]
+        fldl2e
+        fmulp st(1), st(0)
+        fld st(0)
+        frndint
+        fsub st(1), st(0)
+        fxch st(1)
+        f2xm1
+        fld1
+        faddp st(1), st(0)
+        fscale
+        fstp st(1)
+        fstp #PTR [a]
+`
+	if c.useLibrary() {
+		fn = c.libraryCall("exp", 1)
+	}
+	return c.fpChecks(strings.Replace(text, "#FUNCTION\n", fn, -1))
+}
+
 // genFactorial generates assembly code to pop a value from the stack,
 // run a factorial-operation, and store the result back on the stack.
 func (c *Compiler) genFactorial(i int) string {
@@ -148,14 +536,13 @@ func (c *Compiler) genFactorial(i int) string {
         jb stack_error
 
         # pop a value - rounding to an int
-        pop rax
-        mov qword ptr [a], rax
-        fld qword ptr [a]
+        #POP a
+        fld #PTR [a]
         frndint
-        fistp qword ptr [a]
+        fistp qword ptr [int]
 
         # get the value in rcx, setup rax to be 1
-        mov rcx, qword ptr [a]
+        mov rcx, qword ptr [int]
         mov rax,1
 
         # If the value is negative, return zero
@@ -163,7 +550,7 @@ func (c *Compiler) genFactorial(i int) string {
         jg again_#ID
         # jg means jump-if-greater, so if we hit this we had zero/negative
         # store the result.
-        mov qword ptr[a], 0
+        xor rax, rax
         jmp store_result_#ID
 
 again_#ID:
@@ -176,25 +563,297 @@ again_#ID:
         dec rcx
         jnz again_#ID
 
+store_result_#ID:
         # store
-        mov qword ptr[a], rax
-        fild qword ptr [a]
-        fstp qword ptr [a]
-        mov rax, qword ptr [a]
+        mov qword ptr [int], rax
+        fild qword ptr [int]
+        fstp #PTR [a]
 
-store_result_#ID:
         # push result onto stack
-        mov rax, qword ptr [a]
-        push rax
+        #PUSH a
         # stack size didn't change; popped one, pushed one.
 `
 	return (strings.Replace(text, "#ID", fmt.Sprintf("%d", i), -1))
 }
 
+// genFusedMultiply generates assembly code to pop three values from the
+// stack, and push the result of adding (or subtracting) the product of
+// the top two to the third - with a single rounding.
+func (c *Compiler) genFusedMultiply(op string) string {
+	text := `
+        # [FUSED MULTIPLY-#OP]
+        # ensure there are at least three arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 3
+        jb stack_error
+
+        # calculate third #SIGN (second * top)
+        #CLEAR
+        movSUFFIX xmm0, #PTR [rsp + #THIRD]
+        movSUFFIX xmm1, #PTR [rsp + #SECOND]
+        #INSTRUCTION xmm0, xmm1, #PTR [rsp]
+        movSUFFIX #PTR [a], xmm0
+        #CHECK
+
+        # replace the three values with the result
+        add rsp, #THIRD + #SIZE
+        #PUSH a
+
+        # we took three values from the stack, but added one
+        # so the net result is the stack shrunk by two.
+        sub qword ptr [depth], 2
+`
+	// xmm0 = (xmm1 * [rsp]) + xmm0, or -(xmm1 * [rsp]) + xmm0
+	instruction := "vfmadd231SUFFIX"
+	sign := "+"
+	if op == "subtract" {
+		instruction = "vfnmadd231SUFFIX"
+		sign = "-"
+	}
+
+	text = strings.Replace(text, "#OP", strings.ToUpper(op), -1)
+	text = strings.Replace(text, "#SIGN", sign, -1)
+	text = strings.Replace(text, "#INSTRUCTION", instruction, -1)
+	text = strings.Replace(text, "SUFFIX", c.sseSuffix(), -1)
+	text = strings.Replace(text, "#SECOND", fmt.Sprintf("%d", c.slotSize()), -1)
+	text = strings.Replace(text, "#THIRD", fmt.Sprintf("%d", 2*c.slotSize()), -1)
+	return c.fpChecks(text)
+}
+
+// genInput generates assembly code to push the value of a runtime
+// input upon the RPN stack.
+func (c *Compiler) genInput(value string) string {
+	slot, _ := strconv.Atoi(value)
+
+	text := `
+        # [INPUT]
+        # Load the value of the input #NAME onto the stack
+        # Increase the value stored at [depth] to note we've a new stack-entry
+        #PUSH inputs + #SLOT
+        inc qword ptr [depth]
+`
+	text = strings.Replace(text, "#NAME", c.inputName(slot), -1)
+	text = strings.Replace(text, "#SLOT", fmt.Sprintf("%d", (slot-1)*c.slotSize()), -1)
+	return text
+}
+
+// genInputData generates the data-area entries which are used to hold
+// our runtime inputs, and to report problems reading them.
+func (c *Compiler) genInputData() string {
+
+	names := []string{}
+	for i := 1; i <= c.arguments; i++ {
+		if !c.ranged(i) {
+			names = append(names, c.inputName(i))
+		}
+	}
+
+	text := `
+#
+# Runtime inputs.
+#
+#  endptr: used to test that we converted the whole of a number.
+#
+#  inputs: the values of our inputs, after conversion.
+#
+     endptr: .quad 0
+     inputs: .fill #COUNT * #SIZE, 1, 0
+`
+
+	if c.stream {
+		text += `
+#
+# Records read from STDIN.
+#
+#      line: the buffer, allocated by getline, holding the current record.
+#
+# line_size: the size of that buffer.
+#
+#    record: the number of the current record, used when reporting errors.
+#
+       line: .quad 0
+  line_size: .quad 0
+     record: .quad 0
+
+ short_line: .asciz "Too few fields in record %ld.  Aborting\n"
+  bad_field: .asciz "Invalid number in record %ld.  Aborting\n"
+`
+	} else {
+		text += `
+#
+# Command-line arguments.
+#
+#    argv: a copy of the pointer to our command-line arguments.
+#
+       argv: .quad 0
+
+      usage: .asciz "Usage: %s #NAMES\n"
+  bad_input: .asciz "Invalid number '%s'.  Aborting\n"
+`
+	}
+
+	if c.gradient() {
+		text = strings.Replace(text, "     inputs: .fill #COUNT * #SIZE, 1, 0\n", c.dualInputs(), -1)
+	}
+	text = strings.Replace(text, "#COUNT", fmt.Sprintf("%d", c.arguments), -1)
+	text = strings.Replace(text, "#NAMES", strings.Join(names, " "), -1)
+	return text
+}
+
+// genInputHelpers generates the subroutines which are used to convert
+// our runtime inputs from strings into numbers.
+func (c *Compiler) genInputHelpers() string {
+	text := `
+#
+# Convert the string pointed to by rdi into a number, which is
+# returned in st(0) - or [int] for integers.
+#
+# If the string is empty, or isn't entirely a number, we abort.
+#
+parse_number:
+        push rbx
+        mov rbx, rdi
+        lea rsi, endptr
+#STRTOD
+        mov rax, qword ptr [endptr]
+        # did we fail to convert anything?
+        cmp rax, rbx
+        je invalid_input
+        # was there trailing garbage?
+        cmp byte ptr [rax], 0
+        jne invalid_input
+        pop rbx
+        ret
+
+#
+# This is hit when the wrong number of inputs were given.
+#
+usage_error:
+        lea rdi,usage
+        mov rax, qword ptr [argv]
+        mov rsi, qword ptr [rax]
+        mov rdx, 1              # exit-code
+        jmp print_msg_and_exit
+
+#
+# This is hit when an input couldn't be converted to a number.
+#
+invalid_input:
+        lea rdi,bad_input
+        mov rsi, rbx
+        mov rdx, 1              # exit-code
+        jmp print_msg_and_exit
+`
+	return strings.Replace(text, "#STRTOD", c.genStrtod(), -1)
+}
+
+// genLabel generates assembly code to pop a value from the stack, and
+// print it along with the given label.
+func (c *Compiler) genLabel(label string) string {
+	text := `
+        # [LABEL]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # pop one value, and print it with the label "#LABEL"
+        #POP a
+        #LOAD [a]
+        lea rsi,label_#ID
+        call print_value
+
+        # we took one value from the stack.
+        dec qword ptr [depth]
+`
+	text = strings.Replace(text, "#LABEL", escapeString(label), -1)
+	text = strings.Replace(text, "#ID", fmt.Sprintf("%d", c.labelID(label)), -1)
+	return text
+}
+
+// genLn generates assembly code to pop a value from the stack, calculate
+// its natural logarithm, and store the result back on the stack.
+func (c *Compiler) genLn() string {
+	text := `
+        # [LN]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # pop one value
+        #POP a
+
+        # ln
+        #CLEAR
+#FUNCTION
+        #CHECK
+
+        # push result onto stack
+        #PUSH a
+
+        # stack size didn't change; popped one, pushed one.
+`
+
+	// ln(x) is log2(x) * ln(2)
+	fn := `        fldln2
+        fld #PTR [a]
+        fyl2x
+        fstp #PTR [a]
+`
+	if c.useLibrary() {
+		fn = c.libraryCall("log", 1)
+	}
+	return c.fpChecks(strings.Replace(text, "#FUNCTION\n", fn, -1))
+}
+
+// genLibraryHelpers generates the subroutine which is used to call
+// functions from the C library.
+func (c *Compiler) genLibraryHelpers() string {
+	return `
+#
+# Call the C library function whose address is in rax.
+#
+# Since the stack might contain any number of values we align it
+# first.  Arguments, and the result, are in xmm0 and xmm1.
+#
+call_aligned:
+        push rbp
+        mov rbp, rsp
+        and rsp, -16
+        call rax
+        mov rsp, rbp
+        pop rbp
+        ret
+
+#
+# Call the C library function whose address is in rax, which takes
+# long double arguments.
+#
+# These are passed upon the (aligned) stack, [a] and then [b], and the
+# result is returned in st(0), which we store in [a].
+#
+call_long:
+        push rbp
+        mov rbp, rsp
+        and rsp, -16
+        sub rsp, 32
+        fld tbyte ptr [a]
+        fstp tbyte ptr [rsp]
+        fld tbyte ptr [b]
+        fstp tbyte ptr [rsp + 16]
+        call rax
+        fstp tbyte ptr [a]
+        mov rsp, rbp
+        pop rbp
+        ret
+`
+}
+
 // genMinus generates assembly code to pop two values from the stack,
 // subtract them and store the result back on the stack.
 func (c *Compiler) genMinus() string {
-	return `
+	text := `
         # [MINUS]
         # ensure there are at least two arguments on the stack
         mov rax, qword ptr [depth]
@@ -202,74 +861,139 @@ func (c *Compiler) genMinus() string {
         jb stack_error
 
         # pop two values
-        pop rax
-        mov qword ptr [a], rax
-        pop rax
-        mov qword ptr [b], rax
+        #POP a
+        #POP b
 
         # sub
-        fld qword ptr [b]
-        fsub qword ptr  [a]
-        fstp qword ptr [a]
+        #CLEAR
+#ARITHMETIC
+        #CHECK
 
         # push the result back onto the stack
-        mov rax, qword ptr [a]
-        push rax
+        #PUSH a
 
         # we took two values from the stack, but added one
         # so the net result is the stack shrunk by one.
         dec qword ptr [depth]
 `
+
+	return c.fpChecks(strings.Replace(text, "#ARITHMETIC\n", c.arithmetic("sub"), -1))
 }
 
 // genModulus generates assembly code to pop two values from the stack,
 // perform a modulus-operation and store the result back on the stack.
-// Note we truncate things to integers in this section of the code.
-func (c *Compiler) genModulus() string {
-	return `
+//
+// This is the same as C's fmod - the result has the sign of the dividend.
+func (c *Compiler) genModulus(i int) string {
+	text := `
         # [MODULUS]
+` + remainderText + `
+        fstp st(1)
+` + remainderStore
+
+	return c.remainder(text, i)
+}
+
+// genFlooredModulus generates assembly code to pop two values from the
+// stack, perform a floored modulus-operation and store the result back
+// on the stack.
+//
+// The result has the sign of the divisor, as in Python.
+func (c *Compiler) genFlooredModulus(i int) string {
+	text := `
+        # [FLOORED MODULUS]
+` + remainderText + `
+        # The result takes the sign of the divisor; if the remainder
+        # is non-zero, and has the other sign, we add the divisor.
+        ftst
+        fstsw ax
+        sahf
+        jp mod_done_#ID         # NaN
+        setb cl                 # remainder < 0
+        sete ch                 # remainder == 0
+        fxch
+        ftst
+        fstsw ax
+        fxch
+        sahf
+        setb dl                 # divisor < 0
+
+        test ch, ch
+        jz mod_nonzero_#ID
+        # a zero remainder takes the sign of the divisor
+        fabs
+        test dl, dl
+        jz mod_done_#ID
+        fchs
+        jmp mod_done_#ID
+
+mod_nonzero_#ID:
+        cmp cl, dl
+        je mod_done_#ID
+        fadd st(0), st(1)
+
+mod_done_#ID:
+        fstp st(1)
+` + remainderStore
+
+	return c.remainder(text, i)
+}
+
+// remainder completes the assembly code of a modulus operation, which
+// uses the given ID to make its labels unique.
+//
+// Unless we're trapping errors a zero divisor results in a NaN.
+func (c *Compiler) remainder(text string, i int) string {
+	zero := "\n"
+	if c.trapping() {
+		zero = zeroCheck
+	}
+	text = strings.Replace(text, "\n#ZERO\n", zero, -1)
+	text = strings.Replace(text, "#ID", fmt.Sprintf("%d", i), -1)
+	return c.fpChecks(text)
+}
+
+// remainderText is the assembly code which is shared by our modulus
+// operations.  It leaves the remainder in st(0), and the divisor in st(1).
+const remainderText = `
         # ensure there are at least two arguments on the stack
         mov rax, qword ptr [depth]
         cmp rax, 2
         jb stack_error
 
-        # pop two values - rounding both to ints
-        pop rax
-        mov qword ptr [a], rax
-        fld qword ptr [a]
-        frndint
-        fistp qword ptr [a]
-
-        pop rax
-        mov qword ptr [b], rax
-        fld qword ptr [b]
-        frndint
-        fistp qword ptr [b]
-
-        # now we do the modulus-magic.
-        mov rax, qword ptr [b]
-        mov rbx, qword ptr [a]
-        xor rdx, rdx
-        cqo
-        div rbx
+        # pop two values - the divisor, and then the dividend.
+        #POP a
+#ZERO
+        #POP b
+
+        # fprem only calculates a partial remainder, so we repeat
+        # it until C2 is clear.
+        #CLEAR
+        fld #PTR [a]
+        fld #PTR [b]
+remainder_again_#ID:
+        fprem
+        fstsw ax
+        sahf
+        jp remainder_again_#ID
+`
 
-        # store the result from 'rdx'.
-        mov qword ptr[a], rdx
-        fild qword ptr [a]
-        fstp qword ptr [a]
-        mov rax, qword ptr [a]
-        push rax
+// remainderStore is the assembly code which stores the result of our
+// modulus operations.
+const remainderStore = `
+        fstp #PTR [a]
+        #CHECK
+        #PUSH a
 
         # we took two values from the stack, but added one
         # so the net result is the stack shrunk by one.
         dec qword ptr [depth]
 `
-}
 
 // genMultiply generates assembly code to pop two values from the stack,
 // multiply them and store the result back on the stack.
 func (c *Compiler) genMultiply() string {
-	return `
+	text := `
         # [MULTIPLY]
         # ensure there are at least two arguments on the stack
         mov rax, qword ptr [depth]
@@ -277,32 +1001,206 @@ func (c *Compiler) genMultiply() string {
         jb stack_error
 
         # pop two values
-        pop rax
-        mov qword ptr [a], rax
-        pop rax
-        mov qword ptr [b], rax
+        #POP a
+        #POP b
 
         # multiply
-        fld qword ptr [a]
-        fmul qword ptr  [b]
-        fstp qword ptr [a]
+        #CLEAR
+#ARITHMETIC
+        #CHECK
 
         # push the result back onto the stack
-        mov rax, qword ptr [a]
-        push rax
+        #PUSH a
 
         # we took two values from the stack, but added one
         # so the net result is the stack shrunk by one.
         dec qword ptr [depth]
 `
 
+	return c.fpChecks(strings.Replace(text, "#ARITHMETIC\n", c.arithmetic("mul"), -1))
+
+}
+
+// genOutputData generates the data-area entries which are used to print
+// our results, in the format which has been requested.
+func (c *Compiler) genOutputData() string {
+
+	text := `
+#
+# Output formats.
+#
+#   label_fmt: printed before a value, with its label.
+#
+#  number_fmt: used to print a value.
+#
+# integer_fmt: used to print a value which is integral, if enabled.
+#
+#    null_fmt: used in place of values which aren't finite, in JSON.
+#
+#    line_end: printed after a value.
+#
+#      result: the label used for results.
+#
+# The remaining strings are used to print the contents of the stack.
+#
+  label_fmt: .asciz "#LABEL_FMT"
+ number_fmt: .asciz "#NUMBER_FMT"
+integer_fmt: .asciz "#INTEGER_FMT"
+   null_fmt: .asciz "null"
+   line_end: .asciz "#LINE_END"
+     result: .asciz "#RESULT"
+stack_depth: .asciz "#STACK_DEPTH"
+stack_first: .asciz "#STACK_FIRST"
+stack_entry: .asciz "#STACK_ENTRY"
+  stack_end: .asciz "#STACK_END"
+`
+
+	// The defaults.
+	strs := map[string]string{
+		"#LABEL_FMT":   "%s ",
+		"#NUMBER_FMT":  escapeString(c.numberFormat()),
+		"#INTEGER_FMT": c.longFormat("%.0f"),
+		"#LINE_END":    "\\n",
+		"#RESULT":      "Result",
+		"#STACK_DEPTH": "<%ld>",
+		"#STACK_FIRST": " ",
+		"#STACK_ENTRY": " ",
+		"#STACK_END":   "\\n",
+	}
+
+	// Results in a table follow the values of its inputs.
+	if c.bare || c.tabulated() {
+		strs["#RESULT"] = ""
+	}
+
+	// Whole rationals are printed without their denominator.
+	if c.mode == "rational" {
+		strs["#INTEGER_FMT"] = "%ld"
+		if c.json {
+			strs["#INTEGER_FMT"] = `\"%ld\"`
+		}
+	}
+
+	if c.json {
+		strs["#LABEL_FMT"] = `{\"label\": \"%s\", \"value\": `
+		strs["#LINE_END"] = "}\\n"
+		strs["#STACK_DEPTH"] = `{\"depth\": %ld, \"stack\": [`
+		strs["#STACK_FIRST"] = ""
+		strs["#STACK_ENTRY"] = ", "
+		strs["#STACK_END"] = "]}\\n"
+	}
+
+	// Values are followed by the unit they're measured in, unless
+	// they're bare.
+	if c.unit != "" && !c.bare {
+		unit := " " + escapeString(c.unit)
+		if c.json {
+			unit = `, \"unit\": \"` + escapeString(c.unit) + `\"`
+		}
+		strs["#LINE_END"] = unit + strs["#LINE_END"]
+		strs["#STACK_END"] = strings.Replace(strs["#STACK_END"], "]", "]"+unit, 1)
+		if !c.json {
+			strs["#STACK_END"] = unit + strs["#STACK_END"]
+		}
+	}
+
+	for key, val := range strs {
+		text = strings.Replace(text, key, val, -1)
+	}
+	return text
+}
+
+// numberFormat returns the printf-format which is used to output numbers.
+func (c *Compiler) numberFormat() string {
+
+	if c.format != "" {
+		return c.longFormat(c.format)
+	}
+
+	conv := "g"
+	if c.hex {
+		conv = "a"
+	}
+
+	format := "%" + conv
+	if c.mode == "rational" {
+		format = "%ld/%ld"
+		if c.json {
+			format = "\"" + format + "\""
+		}
+		return format
+	}
+	if c.mode == "decimal" {
+		// The sign, the integer part, and each decimal place.
+		if c.places == 0 {
+			return "%s%lu"
+		}
+		return fmt.Sprintf("%%s%%lu.%%0%dlu", c.places)
+	}
+	if c.mode == "int64" {
+		format = "%ld"
+		if c.hex {
+			format = "0x%lx"
+		}
+	} else if c.digits > 0 {
+		format = fmt.Sprintf("%%.%d%s", c.digits, conv)
+	} else if (c.json || c.mode == "interval") && !c.hex {
+		// JSON is intended for machines, so output every digit - as
+		// are intervals, whose bounds are usually very close.
+		digits := 17
+		switch c.precision {
+		case "single":
+			digits = 9
+		case "extended":
+			digits = 21
+		}
+		format = fmt.Sprintf("%%.%dg", digits)
+	}
+	format = c.longFormat(format)
+
+	// Hexadecimal floats aren't valid JSON numbers, so we
+	// output them as strings instead.
+	if c.json && c.hex {
+		format = "\"" + format + "\""
+	}
+	return format
+}
+
+// longFormat changes the conversions in the given printf-format to
+// expect a long double, if we're working at extended precision, or a
+// long if we're working with integers.
+func (c *Compiler) longFormat(format string) string {
+	if c.mode == "int64" {
+		pos, _ := conversions(format, true)
+		for i := len(pos) - 1; i >= 0; i-- {
+			p := pos[i]
+			if format[p-1] != 'l' {
+				format = format[:p] + "l" + format[p:]
+			}
+		}
+		return format
+	}
+	if c.precision != "extended" {
+		return format
+	}
+
+	pos, _ := conversions(format, false)
+	for i := len(pos) - 1; i >= 0; i-- {
+		p := pos[i]
+		if format[p-1] == 'l' {
+			format = format[:p-1] + "L" + format[p:]
+		} else {
+			format = format[:p] + "L" + format[p:]
+		}
+	}
+	return format
 }
 
 // genPlus generates assembly code to pop two values from the stack,
 // add them and store the result back on the stack.
 func (c *Compiler) genPlus() string {
 
-	return `
+	text := `
         # [PLUS]
         # ensure there are at least two arguments on the stack
         mov rax, qword ptr [depth]
@@ -310,34 +1208,74 @@ func (c *Compiler) genPlus() string {
         jb stack_error
 
         # pop two values
-        pop rax
-        mov qword ptr [a], rax
-        pop rax
-        mov qword ptr [b], rax
+        #POP a
+        #POP b
 
         # add
-        fld qword ptr [a]
-        fadd qword ptr  [b]
-        fstp qword ptr [a]
+        #CLEAR
+#ARITHMETIC
+        #CHECK
 
         # push the result back onto the stack
-        mov rax, qword ptr [a]
-        push rax
+        #PUSH a
 
         # we took two values from the stack, but added one
         # so the net result is the stack shrunk by one.
         dec qword ptr [depth]
 `
+
+	return c.fpChecks(strings.Replace(text, "#ARITHMETIC\n", c.arithmetic("add"), -1))
 }
 
 // genPower generates assembly code to pop two values from the stack,
 // perform a power-raising and store the result back on the stack.
 //
-// Note we truncate things to integers in this section of the code.
+// If the exponent is a whole number, which fits in 32-bits, we use
+// repeated squaring - otherwise we calculate 2^(y * log2(x)).  We square
+// at extended precision, so the result is accurate even for large powers.
+//
+// A negative base may only be raised to a whole power, anything else is
+// an invalid operation.  Similarly zero raised to a negative power is
+// a division by zero.  How these are reported depends on our
+// floating-point error policy.
+//
+// When using SSE we call the C library's pow function instead, which
+// raises the same exceptions.
 //
 // Note we do some comparisons here, and need to generate some (unique) labels
 //
 func (c *Compiler) genPower(i int) string {
+
+	//
+	// When using the C library we call its pow function.
+	//
+	if c.useLibrary() {
+		text := `
+        # [POWER]
+        # ensure there are at least two arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 2
+        jb stack_error
+
+        # pop two values - the exponent, and then the base.
+        #POP b
+        #POP a
+
+        # pow(a, b)
+        #CLEAR
+#FUNCTION
+        #CHECK
+
+        # push the result back onto the stack
+        #PUSH a
+
+        # we took two values from the stack, but added one
+        # so the net result is the stack shrunk by one.
+        dec qword ptr [depth]
+`
+		return c.fpChecks(strings.Replace(text, "#FUNCTION\n", c.libraryCall("pow", 2), -1))
+	}
+
 	text := `
         # [POWER]
         # ensure there are at least two arguments on the stack
@@ -345,73 +1283,729 @@ func (c *Compiler) genPower(i int) string {
         cmp rax, 2
         jb stack_error
 
-        # pop two values - rounding both to ints
-        pop rax
-        mov qword ptr [a], rax
-        fld qword ptr [a]
+        # pop two values - the exponent, and then the base.
+        #POP a
+        #POP b
+
+        # The sign of the result is negated if r8 is set.
+        xor r8, r8
+
+        # Is the exponent a whole number?
+        fld #PTR [a]
+        fld st(0)
         frndint
-        fistp qword ptr [a]
+        fcomip st(0), st(1)
+        fstp st(0)
+        jp power_real_#ID
+        jne power_real_#ID
+
+        # If so, is it small enough to use repeated squaring?
+        fld #PTR [a]
+        fistp qword ptr [int]
+        mov rcx, qword ptr [int]
+        mov rdx, rcx
+        neg rdx
+        cmovs rdx, rcx          # rdx = abs(rcx)
+        mov rax, 0x100000000
+        cmp rdx, rax
+        jae power_real_#ID
+
+        # st(1) holds the result, st(0) the base which is squared.
+        #
+        # We square at extended precision, so that our rounding errors
+        # don't accumulate, and the result is rounded once it is stored.
+        #CLEAR
+        fldcw word ptr [fpu_ext]
+        fld1
+        fld #PTR [b]
+power_loop_#ID:
+        test rdx, 1
+        jz power_skip_#ID
+        fmul st(1), st(0)
+power_skip_#ID:
+        shr rdx, 1
+        jz power_squared_#ID
+        fmul st(0), st(0)
+        jmp power_loop_#ID
+power_squared_#ID:
+        fstp st(0)
+
+        # a negative exponent gives the reciprocal
+        cmp rcx, 0
+        jge power_squared_done_#ID
+        fld1
+        fdiv st(0), st(1)
+        fstp st(1)
+power_squared_done_#ID:
+        fldcw word ptr [fpu_cw]
+        jmp power_done_#ID
+
+power_real_#ID:
+        # Look at the sign of the base.
+        #CLEAR
+        fld #PTR [b]
+        ftst
+        fstsw ax
+        sahf
+        jb power_negative_#ID   # also NaN
+        je power_zero_#ID
+        jmp power_log_#ID
+
+power_zero_#ID:
+        # zero raised to a positive power is zero, and to a negative
+        # power is a division by zero.
+        fld #PTR [a]
+        ftst
+        fstsw ax
+        fstp st(0)
+        fabs
+        sahf
+        jp power_log_#ID        # NaN
+        ja power_done_#ID
+        fld1
+        fdivrp st(1), st(0)
+        jmp power_done_#ID
+
+power_negative_#ID:
+        # a negative base must be raised to a whole power
+        fld #PTR [a]
+        fld st(0)
+        frndint
+        fcomip st(0), st(1)
+        jp power_invalid_#ID
+        jne power_invalid_#ID
 
-        pop rax
-        mov qword ptr [b], rax
-        fld qword ptr [b]
+        # the result is negative if that power is odd.
+        fmul qword ptr [half]
+        fld st(0)
         frndint
-        fistp qword ptr [b]
+        fcomip st(0), st(1)
+        fstp st(0)
+        je power_even_#ID
+        mov r8, 1
+power_even_#ID:
+        fabs
+
+power_log_#ID:
+        # st(0) = y * log2(|x|)
+        fld #PTR [a]
+        fxch
+        fyl2x
+
+        # 2^t = 2^int(t) * 2^frac(t)
+        fld st(0)
+        frndint
+        fsub st(1), st(0)
+        fxch
+        f2xm1
+        fld1
+        faddp st(1), st(0)
+        fscale
+        fstp st(1)
+
+        test r8, r8
+        jz power_done_#ID
+        fchs
+        jmp power_done_#ID
+
+power_invalid_#ID:
+        # the result is not a number
+        fstp st(0)
+        fsqrt
+
+power_done_#ID:
+        fstp #PTR [a]
+        #CHECK
+
+        # push the result back onto the stack
+        #PUSH a
+
+        # we took two values from the stack, but added one
+        # so the net result is the stack shrunk by one.
+        dec qword ptr [depth]
+`
+
+	return c.fpChecks(strings.Replace(text, "#ID", fmt.Sprintf("%d", i), -1))
+}
+
+// genPrint generates assembly code to pop a value from the stack, and
+// print it.
+func (c *Compiler) genPrint() string {
+	return `
+        # [PRINT]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # pop one value, and print it
+        #POP a
+        #LOAD [a]
+        lea rsi,result
+        call print_value
 
-        # get the two values
-        mov rax, qword ptr [b]
-        mov rbx, qword ptr [a]
+        # we took one value from the stack.
+        dec qword ptr [depth]
+`
+}
 
-        # if the power is 0 we return zero
+// genPrintAll generates the assembly code which prints every value which
+// remains upon the stack when the program terminates, starting with the
+// one that was pushed first.
+func (c *Compiler) genPrintAll() string {
+	return `
+        # print every remaining entry, from the bottom of the stack up.
+        mov rbx, rax
+print_all_next:
         cmp rbx, 0
-        jne none_zero_#ID
-           # store zero
-           fldz
-           jmp store_value_#ID
-
-none_zero_#ID:
-
-        # if the power is 1 we return the original value
-        cmp rbx, 1
-        jne none_one_#ID
-           mov qword ptr[a], rax
-           fild qword ptr [a]
-           jmp store_value_#ID
-
-none_one_#ID:
-        # here we have rax having a value
-        # and we have rbx having the power to raise
-        mov rcx, rax   # save the value
-
-        # decrease the power by one.
+        je print_all_done
         dec rbx
-again_#ID:
-           # rax = rax * rcx (which is the original value we started with)
-           imul rax,rcx
+        imul rax, rbx, #SIZE
+        #LOAD [rsp + rax]
+        lea rsi,result
+        call print_value
+        jmp print_all_next
+
+print_all_done:
+        # discard the entries we printed
+        imul rax, qword ptr [depth], #SIZE
+        add rsp, rax
+`
+}
 
-           # value too big?
-           jo register_overflow
+// genPrintHelpers generates the subroutines which are used to print
+// values.
+//
+// Since the stack might contain any number of values, at the point these
+// are called, they take care to align it before calling printf.
+func (c *Compiler) genPrintHelpers() string {
+	text := `
+#
+# Print the value in st(0), preceded by the label pointed to by rsi.
+#
+# The value is popped, as the FPU stack must be empty when we call printf.
+#
+print_value:
+        push rbp
+        mov rbp, rsp
+        sub rsp, 16
+        and rsp, -16
+        fstp tbyte ptr [rbp - 16]
+#LABEL
+        fld tbyte ptr [rbp - 16]
+        call print_number
+
+        lea rdi,line_end
+        xor rax, rax
+        call printf
+        mov rsp, rbp
+        pop rbp
+        ret
+
+#
+# Print the number in st(0), without a label or a newline.
+#
+# The value is popped, as the FPU stack must be empty when we call printf.
+#
+print_number:
+        push rbp
+        mov rbp, rsp
+        sub rsp, 32
+        and rsp, -16
+#UNIT
+        fstp tbyte ptr [rbp - 16]
+
+        # a NaN is printed without a sign
+        fld tbyte ptr [rbp - 16]
+        fucomip st(0), st(0)
+        fstp st(0)
+        jnp print_number_format
+        btr word ptr [rbp - 8], 15
+print_number_format:
+        lea rdi,number_fmt
+#JSON#INTEGERS
+print_number_now:
+#PRINTF
+        mov rsp, rbp
+        pop rbp
+        ret
+`
 
-           dec rbx
-           jnz again_#ID
+	//
+	// Integers are passed in rax, and printed exactly.
+	//
+	if c.integral() || c.pairs() || c.mode == "decimal" || c.gradient() {
+		text = `
+#
+# Print the integer in rax, or the number which it points to, preceded
+# by the label pointed to by rsi.
+#
+print_value:
+        push rbp
+        mov rbp, rsp
+        sub rsp, 16
+        and rsp, -16
+        mov qword ptr [rbp - 16], rax
+#LABEL
+        mov rax, qword ptr [rbp - 16]
+        call print_number
+#APPROXIMATE
+
+        lea rdi,line_end
+        xor rax, rax
+        call printf
+        mov rsp, rbp
+        pop rbp
+        ret
+`
+	}
+	if c.mode == "int64" {
+		text += `
+#
+# Print the integer in rax, without a label or a newline.
+#
+print_number:
+        push rbp
+        mov rbp, rsp
+        and rsp, -16
+        mov rsi, rax
+        lea rdi,number_fmt
+        xor rax, rax
+        call printf
+        mov rsp, rbp
+        pop rbp
+        ret
+`
+	}
+	if c.mode == "decimal" {
+		text += strings.Replace(`
+#
+# Print the decimal in rax, without a label or a newline.
+#
+# The magnitude is split into its integer part, and its decimal places,
+# which are printed after the sign.
+#
+print_number:
+        push rbp
+        mov rbp, rsp
+        and rsp, -16
+        lea rsi,decimal_plus
+        test rax, rax
+        jns print_number_split
+        lea rsi,decimal_minus
+        neg rax
+print_number_split:
+        xor rdx, rdx
+        mov rcx, #SCALE
+        div rcx
+        mov rcx, rdx
+        mov rdx, rax
+        lea rdi,number_fmt
+        xor rax, rax
+        call printf
+        mov rsp, rbp
+        pop rbp
+        ret
+`, "#SCALE", fmt.Sprintf("%d", c.scale()), -1)
+	}
+	if c.mode == "bignum" {
+		text += `
+#
+# Print the big integer pointed to by rax, without a label or a newline.
+#
+# The most-significant limb is printed first, then the others with
+# their leading zeros.
+#
+print_number:
+        push rbp
+        mov rbp, rsp
+        push rbx
+        push r12
+        and rsp, -16
+        mov r12, rax
+        mov rbx, qword ptr [r12 + 8]
+        lea rdi,big_first
+        lea rsi,big_plus
+        lea rax,big_minus
+        cmp qword ptr [r12], 0
+        cmovne rsi, rax
+        xor edx, edx
+        test rbx, rbx
+        jz print_number_first
+        dec rbx
+        mov edx, dword ptr [r12 + 16 + rbx*4]
+print_number_first:
+        xor rax, rax
+        call printf
+print_number_next:
+        test rbx, rbx
+        jz print_number_done
+        dec rbx
+        lea rdi,big_next
+        mov esi, dword ptr [r12 + 16 + rbx*4]
+        xor rax, rax
+        call printf
+        jmp print_number_next
+print_number_done:
+        lea rsp, [rbp - 16]
+        pop r12
+        pop rbx
+        pop rbp
+        ret
+`
+	}
+	if c.mode == "rational" {
+		text += `
+#
+# Print the rational pointed to by rax, without a label or a newline.
+#
+# Whole numbers are printed without their denominator.
+#
+print_number:
+        push rbp
+        mov rbp, rsp
+        and rsp, -16
+        mov rsi, qword ptr [rax]
+        mov rdx, qword ptr [rax + 8]
+        lea rdi,number_fmt
+        lea rax,integer_fmt
+        cmp rdx, 1
+        cmove rdi, rax
+        xor rax, rax
+        call printf
+        mov rsp, rbp
+        pop rbp
+        ret
+
+#
+# Print the approximate value of the rational pointed to by rax.
+#
+print_approximation:
+        push rbp
+        mov rbp, rsp
+        and rsp, -16
+#WHOLE
+        fild qword ptr [rax]
+        fild qword ptr [rax + 8]
+        fdivp st(1), st(0)
+        fstp qword ptr [int]
+        movsd xmm0, qword ptr [int]
+        lea rdi,approx_fmt
+        mov rax, 1
+        call printf
+print_approximation_done:
+        mov rsp, rbp
+        pop rbp
+        ret
+`
 
-        mov qword ptr[a], rax
-        fild qword ptr [a]
+		//
+		// Whole numbers don't need an approximation, except in JSON
+		// where every value should have the same fields.
+		//
+		whole := `        cmp qword ptr [rax + 8], 1
+        je print_approximation_done
+`
+		if c.json {
+			whole = ""
+		}
+		text = strings.Replace(text, "#WHOLE\n", whole, -1)
+	}
 
-store_value_#ID:
+	//
+	// If we were given a number of digits then rationals are printed
+	// with their approximate value.
+	//
+	approximate := ""
+	if c.mode == "rational" && c.digits > 0 {
+		approximate = `        mov rax, qword ptr [rbp - 16]
+        call print_approximation
+`
+	}
+	text = strings.Replace(text, "#APPROXIMATE\n", approximate, -1)
+
+	if c.mode == "complex" && c.json {
+		text += `
+#
+# Print the complex number pointed to by rax, as a JSON object.
+#
+print_number:
+        push rbp
+        mov rbp, rsp
+        and rsp, -16
+        movsd xmm0, qword ptr [rax]
+        movsd xmm1, qword ptr [rax + 8]
+        lea rdi,complex_json
+        mov rax, 2
+        call printf
+        mov rsp, rbp
+        pop rbp
+        ret
+`
+	} else if c.mode == "complex" {
+		text += `
+#
+# Print the complex number pointed to by rax, without a label or a
+# newline.
+#
+# A part which is zero is omitted, unless both are, as is the coefficient
+# of the imaginary part if it is one - so we print "i" rather than "0+1i".
+#
+print_number:
+        push rbp
+        mov rbp, rsp
+        push rbx
+        push r12
+        and rsp, -16
+        mov rbx, rax
+        xor r12, r12            # was the real part printed?
+
+        xorpd xmm1, xmm1
+        movsd xmm0, qword ptr [rbx]
+        ucomisd xmm0, xmm1
+        jp print_number_real
+        jne print_number_real
+        movsd xmm0, qword ptr [rbx + 8]
+        ucomisd xmm0, xmm1
+        jp print_number_imaginary
+        jne print_number_imaginary
+print_number_real:
+        movsd xmm0, qword ptr [rbx]
+        lea rdi,number_fmt
+        mov rax, 1
+        call printf
+        inc r12
+
+print_number_imaginary:
+        xorpd xmm1, xmm1
+        movsd xmm0, qword ptr [rbx + 8]
+        ucomisd xmm0, xmm1
+        jp print_number_sign
+        je print_number_done
+print_number_sign:
+        # the sign is only needed after the real part, if positive
+        lea rdi,complex_minus
+        movmskpd eax, xmm0
+        test eax, 1
+        jnz print_number_signed
+        lea rdi,complex_plus
+        test r12, r12
+        jz print_number_coefficient
+print_number_signed:
+        xor rax, rax
+        call printf
+print_number_coefficient:
+        mov rax, qword ptr [rbx + 8]
+        btr rax, 63
+        mov rcx, 0x3FF0000000000000
+        cmp rax, rcx
+        je print_number_unit
+        movq xmm0, rax
+        lea rdi,number_fmt
+        mov rax, 1
+        call printf
+print_number_unit:
+        lea rdi,complex_i
+        xor rax, rax
+        call printf
+print_number_done:
+        lea rsp, [rbp - 16]
+        pop r12
+        pop rbx
+        pop rbp
+        ret
+`
+	}
 
-        fstp qword ptr [a]
+	if c.mode == "interval" {
+		text += `
+#
+# Print the interval pointed to by rax, without a label or a newline.
+#
+# printf rounds according to the FPU control-word, so the lower bound
+# is rounded down, and the upper bound up, to the digits we print.
+#
+print_number:
+        push rbp
+        mov rbp, rsp
+        push rbx
+        and rsp, -16
+        mov rbx, rax
+        lea rdi,interval_open
+        xor rax, rax
+        call printf
+        fldcw word ptr [fpu_down]
+        movsd xmm0, qword ptr [rbx]
+        lea rdi,number_fmt
+        mov rax, 1
+        call printf
+        fldcw word ptr [fpu_cw]
+        lea rdi,interval_sep
+        xor rax, rax
+        call printf
+        fldcw word ptr [fpu_up]
+        movsd xmm0, qword ptr [rbx + 8]
+        lea rdi,number_fmt
+        mov rax, 1
+        call printf
+        fldcw word ptr [fpu_cw]
+        lea rdi,interval_close
+        xor rax, rax
+        call printf
+        mov rbx, qword ptr [rbp - 8]
+        mov rsp, rbp
+        pop rbp
+        ret
+`
+	}
 
-        # push the result back onto the stack
-        mov rax, qword ptr [a]
-        push rax
+	text += `
+#
+# Print every value upon the stack, starting with the one that was
+# pushed first.
+#
+# Our caller's stack begins above our return-address and saved rbp.
+#
+print_stack:
+        push rbp
+        mov rbp, rsp
+        push rbx
+        and rsp, -16
+
+        # show the depth
+        lea rdi,stack_depth
+        mov rsi, qword ptr [depth]
+        xor rax, rax
+        call printf
+
+        # then each entry, preceded by a separator.
+        mov rbx, qword ptr [depth]
+        lea rdi,stack_first
+print_stack_next:
+        cmp rbx, 0
+        je print_stack_done
+        dec rbx
+        xor rax, rax
+        call printf
+        imul rax, rbx, #SIZE
+        #LOAD [rbp + 16 + rax]
+        call print_number
+        lea rdi,stack_entry
+        jmp print_stack_next
+
+print_stack_done:
+        lea rdi,stack_end
+        xor rax, rax
+        call printf
+        mov rbx, qword ptr [rbp - 8]
+        mov rsp, rbp
+        pop rbp
+        ret
+`
 
-        # we took two values from the stack, but added one
-        # so the net result is the stack shrunk by one.
-        dec qword ptr [depth]
+	//
+	// Labels are always present in JSON, otherwise an empty label is
+	// skipped - which is how we output bare results.
+	//
+	label := `
+        lea rdi,label_fmt
+        xor rax, rax
+        call printf
+`
+	if !c.json {
+		label = `
+        # empty labels aren't printed
+        cmp byte ptr [rsi], 0
+        je print_value_number
+        lea rdi,label_fmt
+        xor rax, rax
+        call printf
+print_value_number:
+`
+	}
+	text = strings.Replace(text, "#LABEL", label, -1)
+
+	//
+	// JSON can't represent values which aren't finite.
+	//
+	json := ""
+	if c.json {
+		json = `
+        # values which aren't finite are output as null in JSON.
+        fld tbyte ptr [rbp - 16]
+        fxam
+        fstsw ax
+        fstp st(0)
+        test ah, 0x01           # C0 is set for NaN, and infinity
+        jz print_number_finite
+        lea rdi,null_fmt
+        jmp print_number_now
+print_number_finite:
 `
+	}
+	text = strings.Replace(text, "#JSON", json, -1)
+
+	//
+	// Values are measured in SI base units, which we might convert.
+	//
+	unit := ""
+	if c.unit != "" {
+		unit = `        # convert the value to our output unit
+        fdiv qword ptr [unit_scale]
+`
+	}
+	text = strings.Replace(text, "#UNIT\n", unit, -1)
+
+	//
+	// If a value is integral we might output it exactly.
+	//
+	integers := ""
+	if c.integers {
+		integers = `
+        # values which are integral are output exactly.
+        fld tbyte ptr [rbp - 16]
+        fld st(0)
+        frndint
+        fcomip st(0), st(1)
+        fstp st(0)
+        jp print_number_now     # NaN
+        jne print_number_now
+        lea rdi,integer_fmt
+`
+	}
+	text = strings.Replace(text, "#INTEGERS", integers, -1)
+
+	//
+	// An extended value is passed to printf in memory, the others are
+	// passed as doubles.
+	//
+	printf := `        fld tbyte ptr [rbp - 16]
+        fstp qword ptr [rbp - 24]
+        movq xmm0, qword ptr [rbp - 24]
+        mov rax, 1
+        call printf
+`
+	if c.precision == "extended" {
+		printf = `        sub rsp, 16
+        fld tbyte ptr [rbp - 16]
+        fstp tbyte ptr [rsp]
+        xor rax, rax
+        call printf
+`
+	}
+	text = strings.Replace(text, "#PRINTF\n", printf, -1)
 
-	return (strings.Replace(text, "#ID", fmt.Sprintf("%d", i), -1))
+	return text
+}
+
+// genPrintStack generates assembly code to print every value upon the
+// stack, without removing any of them.
+func (c *Compiler) genPrintStack() string {
+	return `
+        # [PRINT STACK]
+        call print_stack
+        # stack size didn't change.
+`
 }
 
 // genPush generates assembly code to push a value upon the RPN stack.
@@ -421,10 +2015,7 @@ func (c *Compiler) genPush(value string) string {
         # [PUSH]
         # Load the value #VALUE onto the stack
         # Increase the value stored at [depth] to note we've a new stack-entry
-        fld qword ptr #ESCAPED
-        fstp qword ptr [int]
-        mov rax, qword ptr [int]
-        push rax
+        #PUSH #ESCAPED
         inc qword ptr [depth]
 `
 
@@ -435,10 +2026,271 @@ func (c *Compiler) genPush(value string) string {
 	return (text)
 }
 
+// genRand generates assembly code to push a random number, uniformly
+// distributed in the range [0,1), upon the stack.
+func (c *Compiler) genRand() string {
+	return `
+        # [RAND]
+        call rand_uniform
+        fstp #PTR [a]
+
+        # push result onto stack
+        #PUSH a
+        inc qword ptr [depth]
+`
+}
+
+// genRandInt generates assembly code to pop two values from the stack,
+// and push a random integer which lies between them, inclusively.
+func (c *Compiler) genRandInt() string {
+	return `
+        # [RANDINT]
+        # ensure there are at least two arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 2
+        jb stack_error
+
+        # pop two values - rounding both to ints
+        #POP a
+        #POP b
+        fld #PTR [b]
+        frndint
+        fstp #PTR [b]
+
+        # the size of the range is (a - b + 1), which must be positive.
+        fld #PTR [a]
+        frndint
+        fld #PTR [b]
+        fsubp st(1), st(0)
+        ftst
+        fstsw ax
+        sahf
+        jb domain_error
+        fld1
+        faddp st(1), st(0)
+
+        # scale a random number to the range, and truncate it - which we
+        # do by rounding towards zero, as the range might not fit in an
+        # integer.
+        call rand_uniform
+        fmulp st(1), st(0)
+        fnstcw word ptr [int]
+        or word ptr [int], 0x0C00
+        fldcw word ptr [int]
+        frndint
+        fldcw word ptr [fpu_cw]
+        fld #PTR [b]
+        faddp st(1), st(0)
+        fstp #PTR [a]
+
+        # push the result back onto the stack
+        #PUSH a
+
+        # we took two values from the stack, but added one
+        # so the net result is the stack shrunk by one.
+        dec qword ptr [depth]
+`
+}
+
+// genRandNormal generates assembly code to push a random number, from
+// the standard normal distribution, upon the stack.
+func (c *Compiler) genRandNormal() string {
+	return `
+        # [RANDN]
+        call rand_normal
+        fstp #PTR [a]
+
+        # push result onto stack
+        #PUSH a
+        inc qword ptr [depth]
+`
+}
+
+// genRandomData generates the data-area entries which are used by our
+// random number generator.
+func (c *Compiler) genRandomData() string {
+	return `
+#
+# Random numbers.
+#
+#  rng_state: the state of our xorshift64* generator.
+#
+#  rng_scale: converts 53 random bits into the range [0,1).
+#
+#   rng_temp: used to hold intermediate results.
+#
+# rng_radius: used to hold the radius, in the Box-Muller transform.
+#
+  rng_state: .quad 1
+  rng_scale: .double 1.1102230246251565e-16
+    rng_two: .double -2.0
+   rng_temp: .double 0.0
+ rng_radius: .double 0.0
+`
+}
+
+// genRandomHelpers generates the subroutines which implement our random
+// number generator.
+//
+// We use xorshift64*, which is seeded via splitmix64, and return the
+// results upon the FPU stack.
+func (c *Compiler) genRandomHelpers() string {
+	return `
+#
+# Seed the random number generator with the value in rdi.
+#
+rand_seed:
+        mov rax, 0x9E3779B97F4A7C15
+        add rax, rdi
+        mov rdx, rax
+        shr rdx, 30
+        xor rax, rdx
+        mov rdx, 0xBF58476D1CE4E5B9
+        imul rax, rdx
+        mov rdx, rax
+        shr rdx, 27
+        xor rax, rdx
+        mov rdx, 0x94D049BB133111EB
+        imul rax, rdx
+        mov rdx, rax
+        shr rdx, 31
+        xor rax, rdx
+        # xorshift must never have a state of zero
+        jnz rand_seeded
+        inc rax
+rand_seeded:
+        mov qword ptr [rng_state], rax
+        ret
+
+#
+# Load a random number, uniformly distributed in [0,1), into st(0).
+#
+rand_uniform:
+        mov rax, qword ptr [rng_state]
+        mov rdx, rax
+        shr rdx, 12
+        xor rax, rdx
+        mov rdx, rax
+        shl rdx, 25
+        xor rax, rdx
+        mov rdx, rax
+        shr rdx, 27
+        xor rax, rdx
+        mov qword ptr [rng_state], rax
+
+        # scramble, and keep the top 53 bits.
+        mov rdx, 0x2545F4914F6CDD1D
+        imul rax, rdx
+        shr rax, 11
+        mov qword ptr [rng_temp], rax
+        fild qword ptr [rng_temp]
+        fmul qword ptr [rng_scale]
+        ret
+
+#
+# Load a random number, from the standard normal distribution, into st(0).
+#
+# We use the Box-Muller transform:
+#
+#    sqrt(-2 ln(1 - u1)) * cos(2 pi u2)
+#
+rand_normal:
+        call rand_uniform
+        fstp qword ptr [rng_temp]
+
+        # 1 - u1 is in the range (0,1], so we can take its log.
+        fldln2
+        fld1
+        fsub qword ptr [rng_temp]
+        fyl2x
+        fmul qword ptr [rng_two]
+        fsqrt
+        fstp qword ptr [rng_radius]
+
+        # cos(2 pi u2)
+        call rand_uniform
+        fldpi
+        fmulp st(1), st(0)
+        fadd st(0), st(0)
+        fcos
+
+        fmul qword ptr [rng_radius]
+        ret
+`
+}
+
+// genSaturateHelpers generates the subroutine which clamps the value in
+// [a], if our floating-point error policy is to saturate.
+func (c *Compiler) genSaturateHelpers() string {
+	return `
+#
+# Clamp an infinite value in [a] to the largest finite value, with the
+# same sign.  A NaN can't be clamped, so is a domain error.
+#
+fp_saturate:
+        fld #PTR [a]
+        fxam
+        fstsw ax
+        fstp st(0)
+        mov dl, ah
+        and dl, 0x45            # C3, C2, and C0
+        cmp dl, 0x01            # NaN
+        je domain_error
+        cmp dl, 0x05            # infinity
+        jne fp_saturate_done
+        fld #PTR [fp_max]
+        test ah, 0x02           # C1 holds the sign
+        jz fp_saturate_positive
+        fchs
+fp_saturate_positive:
+        fstp #PTR [a]
+fp_saturate_done:
+        ret
+`
+}
+
+// genSeed generates the assembly code which seeds our random number
+// generator, either with a fixed value or from the clock.
+//
+// Our command-line arguments are read afterwards, so argc and argv are
+// preserved.
+func (c *Compiler) genSeed() string {
+	if c.seeded {
+		return fmt.Sprintf(`
+        # [SEED]
+        # Seed our random number generator with a fixed value.
+        push rdi
+        push rsi
+        mov rdi, %d
+        call rand_seed
+        pop rsi
+        pop rdi
+`, c.seed)
+	}
+
+	return `
+        # [SEED]
+        # Seed our random number generator from the clock.
+        push rdi
+        push rsi
+        sub rsp, 16             # struct timespec
+        xor rdi, rdi            # CLOCK_REALTIME
+        mov rsi, rsp
+        call clock_gettime
+        mov rdi, qword ptr [rsp]
+        imul rdi, rdi, 1000000000
+        add rdi, qword ptr [rsp + 8]
+        add rsp, 16
+        call rand_seed
+        pop rsi
+        pop rdi
+`
+}
+
 // genSin generates assembly code to pop a value from the stack,
 // run a sin-operation, and store the result back on the stack.
 func (c *Compiler) genSin() string {
-	return `
+	text := `
         # [SIN]
         # ensure there is at least one argument on the stack
         mov rax, qword ptr [depth]
@@ -446,20 +2298,256 @@ func (c *Compiler) genSin() string {
         jb stack_error
 
         # pop one value
-        pop rax
-        mov qword ptr [a], rax
+        #POP a
 
         # sin
-        fld qword ptr [a]
-        fsin
-        fstp qword ptr [a]
+        #CLEAR
+#FUNCTION
+        #CHECK
 
         # push result onto stack
-        mov rax, qword ptr [a]
-        push rax
+        #PUSH a
 
         # stack size didn't change; popped one, pushed one.
 `
+
+	fn := `        fld #PTR [a]
+        fsin
+        fstp #PTR [a]
+`
+	if c.useLibrary() {
+		fn = c.libraryCall("sin", 1)
+	}
+	return c.fpChecks(strings.Replace(text, "#FUNCTION\n", fn, -1))
+}
+
+// genStream generates the assembly code which reads the next record from
+// STDIN, and binds its fields to our runtime inputs.
+//
+// The label `next_record` is jumped to once the result of the previous
+// record has been printed, and we jump to `end_of_input` when there are
+// no more records to read.
+func (c *Compiler) genStream() string {
+	return `
+        # [STREAM]
+        # Each line of STDIN is a record, the fields of which are bound
+        # to our inputs.  We calculate, and print, one result per record.
+next_record:
+        mov qword ptr [depth], 0
+        inc qword ptr [record]
+
+        # read the line
+        lea rdi,line
+        lea rsi,line_size
+        mov rdx, qword ptr [stdin]
+        call getline
+        cmp rax, -1
+        je end_of_input
+
+        # parse the fields, skipping a record which is blank
+        mov rdi, qword ptr [line]
+        call parse_record
+        cmp rax, 0
+        je next_record
+`
+}
+
+// genStreamHelpers generates the subroutine which is used to parse
+// the fields of a record, read from STDIN.
+func (c *Compiler) genStreamHelpers() string {
+	text := `
+#
+# Parse the record pointed to by rdi, storing each of its fields in
+# [inputs].
+#
+# Fields are numbers separated by whitespace and/or commas, any
+# fields beyond those we need are ignored.
+#
+# A record which is empty, or only whitespace, is blank and has no
+# fields.  We return zero in rax for such a record, and one otherwise.
+#
+parse_record:
+        push rbx
+        push r12
+        sub rsp, 8
+        mov rbx, rdi            # current position
+        xor r12, r12            # current field
+
+        mov rcx, rbx
+skip_blank:
+        mov al, byte ptr [rcx]
+        inc rcx
+        cmp al, ' '
+        je skip_blank
+        cmp al, 9               # tab
+        je skip_blank
+        cmp al, 13              # carriage-return
+        je skip_blank
+        cmp al, 10
+        je blank_record
+        cmp al, 0
+        je blank_record
+
+next_field:
+        cmp r12, #COUNT
+        je record_parsed
+
+skip_separators:
+        mov al, byte ptr [rbx]
+        cmp al, ' '
+        je skip_separator
+        cmp al, 9               # tab
+        je skip_separator
+        cmp al, 13              # carriage-return
+        je skip_separator
+        cmp al, ','
+        je skip_separator
+        jmp read_field
+skip_separator:
+        inc rbx
+        jmp skip_separators
+
+read_field:
+        # have we reached the end of the line?
+        cmp al, 10
+        je short_record
+        cmp al, 0
+        je short_record
+
+        mov rdi, rbx
+        lea rsi, endptr
+#STRTOD
+        mov rax, qword ptr [endptr]
+        # did we fail to convert anything?
+        cmp rax, rbx
+        je invalid_field
+
+        # the number must be followed by a separator, or the end of the line.
+        mov cl, byte ptr [rax]
+        cmp cl, ' '
+        je field_parsed
+        cmp cl, 9
+        je field_parsed
+        cmp cl, 13
+        je field_parsed
+        cmp cl, ','
+        je field_parsed
+        cmp cl, 10
+        je field_parsed
+        cmp cl, 0
+        je field_parsed
+        jmp invalid_field
+
+field_parsed:
+        imul rcx, r12, #SIZE
+        lea rdx, inputs
+        #STORE [rdx + rcx]
+        mov rbx, rax
+        inc r12
+        jmp next_field
+
+record_parsed:
+        mov rax, 1
+        jmp record_done
+blank_record:
+        xor rax, rax
+record_done:
+        add rsp, 8
+        pop r12
+        pop rbx
+        ret
+
+#
+# This is hit when a record has too few fields.
+#
+short_record:
+        lea rdi,short_line
+        mov rsi, qword ptr [record]
+        mov rdx, 1              # exit-code
+        jmp print_msg_and_exit
+
+#
+# This is hit when a field isn't a number.
+#
+invalid_field:
+        lea rdi,bad_field
+        mov rsi, qword ptr [record]
+        mov rdx, 1              # exit-code
+        jmp print_msg_and_exit
+`
+	text = strings.Replace(text, "#STRTOD", c.genStrtod(), -1)
+	return strings.Replace(text, "#COUNT", fmt.Sprintf("%d", c.arguments), -1)
+}
+
+// genStrtod generates the assembly code which converts the string pointed
+// to by rdi into a number, at our working precision, in st(0).
+//
+// The conversion is made by the C library, and rsi must point to the
+// location which receives the end of the number.
+//
+// Integers are stored in [int], and rbx must also point to the string,
+// as an integer which is out of range is treated as if nothing were
+// converted.
+func (c *Compiler) genStrtod() string {
+	if c.mode == "rational" {
+		return `        call rat_parse
+`
+	}
+	if c.mode == "complex" {
+		return `        call complex_parse
+`
+	}
+	if c.mode == "interval" {
+		return `        call interval_parse
+`
+	}
+	if c.mode == "decimal" {
+		return `        call decimal_parse
+`
+	}
+	if c.mode == "bignum" {
+		return `        call big_parse
+        mov qword ptr [int], rax
+`
+	}
+	if c.mode == "int64" {
+		return `        call __errno_location
+        mov dword ptr [rax], 0
+        mov rdi, rbx
+        lea rsi, endptr
+        mov rdx, 10
+        call strtoll
+        mov qword ptr [int], rax
+        call __errno_location
+        cmp dword ptr [rax], 0
+        mov rax, qword ptr [endptr]
+        cmovne rax, rbx
+        mov qword ptr [endptr], rax
+`
+	}
+
+	switch c.precision {
+	case "single":
+		//
+		// The number is read as a double, and narrowed by the FPU, so
+		// that one which is out of range is handled by our policy.
+		//
+		return c.fpChecks(`        call strtod
+        movsd qword ptr [int], xmm0
+        #CLEAR
+        fld qword ptr [int]
+        fstp dword ptr [a]
+        #CHECK
+        fld dword ptr [a]
+`)
+	case "extended":
+		return `        call strtold
+`
+	}
+	return `        call strtod
+        movsd qword ptr [int], xmm0
+        fld qword ptr [int]
+`
 }
 
 // genSwap generates assembly code to pop two values from the stack and
@@ -472,10 +2560,10 @@ func (c *Compiler) genSwap() string {
         cmp rax, 2
         jb stack_error
 
-        pop rax
-        pop rbx
-        push rax
-        push rbx
+        #POP a
+        #POP b
+        #PUSH a
+        #PUSH b
         # stack size didn't change; popped two, pushed two.
 `
 }
@@ -483,7 +2571,7 @@ func (c *Compiler) genSwap() string {
 // genTan generates assembly code to pop a value from the stack,
 // run a tan-operation, and store the result back on the stack.
 func (c *Compiler) genTan() string {
-	return `
+	text := `
         # [TAN]
         # ensure there is at least one argument on the stack
         mov rax, qword ptr [depth]
@@ -491,25 +2579,32 @@ func (c *Compiler) genTan() string {
         jb stack_error
 
         # pop one value
-        pop rax
-        mov qword ptr [a], rax
+        #POP a
 
         # tan
-        fld qword ptr [a]
-        fsincos
-        fdivr %st(0),st(1)
-        fstp qword ptr [a]
+        #CLEAR
+#FUNCTION
+        #CHECK
 
         # push result onto stack
-        mov rax, qword ptr [a]
-        push rax
+        #PUSH a
+`
+
+	fn := `        fld #PTR [a]
+        fsincos
+        fdivp st(1), st(0)
+        fstp #PTR [a]
 `
+	if c.useLibrary() {
+		fn = c.libraryCall("tan", 1)
+	}
+	return c.fpChecks(strings.Replace(text, "#FUNCTION\n", fn, -1))
 }
 
 // genSqrt generates assembly code to pop a value from the stack,
 // run a square-root operation, and store the result back on the stack.
 func (c *Compiler) genSqrt() string {
-	return `
+	text := `
         # [SQRT]
         # ensure there is at least one argument on the stack
         mov rax, qword ptr [depth]
@@ -517,18 +2612,30 @@ func (c *Compiler) genSqrt() string {
         jb stack_error
 
         # pop one value
-        pop rax
-        mov qword ptr [a], rax
+        #POP a
 
         # sqrt
-        fld qword ptr [a]
-        fsqrt
-        fstp qword ptr [a]
+        #CLEAR
+#FUNCTION
+        #CHECK
 
         # push result onto stack
-        mov rax, qword ptr [a]
-        push rax
+        #PUSH a
 
         # stack size didn't change; popped one, pushed one.
 `
+
+	fn := `        fld #PTR [a]
+        fsqrt
+        fstp #PTR [a]
+`
+	if c.math == "accurate" {
+		fn = c.libraryCall("sqrt", 1)
+	} else if c.fpu == "sse" {
+		fn = strings.Replace(`        movSUFFIX xmm0, #PTR [a]
+        sqrtSUFFIX xmm0, xmm0
+        movSUFFIX #PTR [a], xmm0
+`, "SUFFIX", c.sseSuffix(), -1)
+	}
+	return c.fpChecks(strings.Replace(text, "#FUNCTION\n", fn, -1))
 }
@@ -13,6 +13,11 @@ func TestEscape(t *testing.T) {
 		{"0.03", "const_0_03"},
 		{"-3", "const_neg_3"},
 		{"-3.3", "const_neg_3_3"},
+		{"1e22", "const_1e22"},
+		{"1e-5", "const_1e_neg_5"},
+		{"-2.5e+3", "const_neg_2_5e_pos_3"},
+		{"1e5", "const_1e5"},
+		{"1e+5", "const_1e_pos_5"},
 	}
 
 	for _, text := range tests {
@@ -38,6 +43,7 @@ func TestGenerators(t *testing.T) {
 
 	// misc
 	c.genPush("3.4")
+	c.genInput("1")
 
 	// simple
 	c.genPlus()
@@ -46,7 +52,8 @@ func TestGenerators(t *testing.T) {
 	c.genDivide()
 
 	// misc
-	c.genModulus()
+	c.genModulus(1)
+	c.genFlooredModulus(1)
 	c.genPower(1)
 
 	// complex
@@ -59,4 +66,18 @@ func TestGenerators(t *testing.T) {
 	// stack
 	c.genDup()
 	c.genSwap()
+
+	// random
+	c.genRand()
+	c.genRandInt()
+	c.genRandNormal()
+
+	// output
+	c.genPrint()
+	c.genPrintStack()
+	c.genLabel("area")
+
+	// fused
+	c.genFusedMultiply("add")
+	c.genFusedMultiply("subtract")
 }
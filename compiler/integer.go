@@ -0,0 +1,333 @@
+// integer.go contains the code for emitting instructions which operate
+// upon signed 64-bit integers, rather than floating-point numbers.
+
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skx/math-compiler/instructions"
+)
+
+// genInteger generates the assembly code for the given instruction, which
+// is the i'th of our program, when working with integers.
+//
+// The instructions which don't depend upon the kind of values we're
+// working with, such as swap, are shared with floating-point numbers.
+func (c *Compiler) genInteger(opr instructions.Instruction, i int) string {
+
+	switch opr.Type {
+
+	case instructions.Abs:
+		return c.integerUnary("ABS", `        # negating the smallest integer overflows
+        mov rcx, rax
+        neg rcx
+        jo register_overflow
+        cmovns rax, rcx
+`)
+
+	case instructions.And:
+		return c.integerBinary("AND", `        and rax, rcx
+`)
+
+	case instructions.Divide:
+		return c.integerBinary("DIVIDE", c.integerDivide(`        # the quotient is rounded towards zero
+        cqo
+        idiv rcx
+`, i))
+
+	case instructions.Dup:
+		return c.genDup()
+
+	case instructions.Factorial:
+		return c.genIntegerFactorial(i)
+
+	case instructions.FlooredModulus:
+		return c.integerBinary("FLOORED MODULUS", c.integerDivide(`        # the remainder has the sign of the divisor
+        cqo
+        idiv rcx
+        mov rax, rdx
+        test rax, rax
+        jz mod_done_#ID
+        xor rdx, rcx
+        jns mod_done_#ID
+        add rax, rcx
+mod_done_#ID:
+`, i))
+
+	case instructions.Input:
+		return c.genInput(opr.Value)
+
+	case instructions.Label:
+		return c.genLabel(opr.Value)
+
+	case instructions.Minus:
+		return c.integerBinary("MINUS", `        sub rax, rcx
+        jo register_overflow
+`)
+
+	case instructions.Modulus:
+		return c.integerBinary("MODULUS", c.integerDivide(`        # the remainder has the sign of the dividend
+        cqo
+        idiv rcx
+        mov rax, rdx
+`, i))
+
+	case instructions.Multiply:
+		return c.integerBinary("MULTIPLY", `        imul rax, rcx
+        jo register_overflow
+`)
+
+	case instructions.Not:
+		return c.integerUnary("NOT", `        not rax
+`)
+
+	case instructions.Or:
+		return c.integerBinary("OR", `        or rax, rcx
+`)
+
+	case instructions.Plus:
+		return c.integerBinary("PLUS", `        add rax, rcx
+        jo register_overflow
+`)
+
+	case instructions.PopCount:
+		return c.integerUnary("POPCOUNT", `        popcnt rax, rax
+`)
+
+	case instructions.Power:
+		return c.genIntegerPower(i)
+
+	case instructions.Print:
+		return c.genPrint()
+
+	case instructions.PrintStack:
+		return c.genPrintStack()
+
+	case instructions.Push:
+		return c.genPush(opr.Value)
+
+	case instructions.RandInt:
+		return c.genIntegerRandInt()
+
+	case instructions.ShiftLeft:
+		return c.integerBinary("SHIFT LEFT", c.integerShift("shl"))
+
+	case instructions.ShiftRight:
+		return c.integerBinary("SHIFT RIGHT", c.integerShift("shr"))
+
+	case instructions.Swap:
+		return c.genSwap()
+
+	case instructions.Xor:
+		return c.integerBinary("XOR", `        xor rax, rcx
+`)
+
+	}
+	return ""
+}
+
+// integerBinary returns the assembly code which pops two integers from the
+// stack, and pushes the result of the given calculation.
+//
+// The calculation is given the first value in rax, and the second in rcx,
+// and leaves its result in rax.
+func (c *Compiler) integerBinary(name string, calc string) string {
+	text := `
+        # [#NAME]
+        # ensure there are at least two arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 2
+        jb stack_error
+
+        # pop two values
+        #POP a
+        #POP b
+        mov rax, qword ptr [b]
+        mov rcx, qword ptr [a]
+#CALC
+        mov qword ptr [a], rax
+
+        # push the result back onto the stack
+        #PUSH a
+
+        # we took two values from the stack, but added one
+        # so the net result is the stack shrunk by one.
+        dec qword ptr [depth]
+`
+	text = strings.Replace(text, "#NAME", name, -1)
+	return strings.Replace(text, "#CALC\n", calc, -1)
+}
+
+// integerUnary returns the assembly code which pops an integer from the
+// stack, and pushes the result of the given calculation.
+//
+// The calculation is given the value in rax, and leaves its result there.
+func (c *Compiler) integerUnary(name string, calc string) string {
+	text := `
+        # [#NAME]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # pop one value
+        #POP a
+        mov rax, qword ptr [a]
+#CALC
+        mov qword ptr [a], rax
+
+        # push result onto stack
+        #PUSH a
+
+        # stack size didn't change; popped one, pushed one.
+`
+	text = strings.Replace(text, "#NAME", name, -1)
+	return strings.Replace(text, "#CALC\n", calc, -1)
+}
+
+// integerDivide returns the given division, of rax by rcx, preceded by
+// the checks which prevent it from faulting.
+//
+// The smallest integer divided by -1 overflows, so we divide by 1 and
+// negate the quotient - which reports the overflow, whilst the remainder
+// is correctly zero.
+func (c *Compiler) integerDivide(div string, i int) string {
+	text := `        test rcx, rcx
+        jz division_by_zero
+        xor r8, r8
+        cmp rcx, -1
+        jne divide_#ID
+        mov rcx, 1
+        inc r8
+divide_#ID:
+` + div + `        test r8, r8
+        jz divide_done_#ID
+        neg rax
+        jo register_overflow
+divide_done_#ID:
+`
+	return strings.Replace(text, "#ID", fmt.Sprintf("%d", i), -1)
+}
+
+// integerShift returns the assembly code which shifts rax by rcx bits, in
+// the given direction.  The shift is logical, and shifting by 64 bits, or
+// more, leaves zero.
+func (c *Compiler) integerShift(op string) string {
+	text := `        # the count mustn't be negative
+        cmp rcx, 0
+        jl domain_error
+        xor rdx, rdx
+        OP rax, cl
+        cmp rcx, 64
+        cmovae rax, rdx
+`
+	return strings.Replace(text, "OP", op, -1)
+}
+
+// genIntegerFactorial generates assembly code to pop an integer from the
+// stack, and push its factorial.
+func (c *Compiler) genIntegerFactorial(i int) string {
+	text := `
+        # [FACTORIAL]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # pop one value, which mustn't be negative
+        #POP a
+        mov rcx, qword ptr [a]
+        cmp rcx, 0
+        jl domain_error
+
+        # multiply rax by each of rcx, rcx - 1, .., 2
+        mov rax, 1
+factorial_loop_#ID:
+        cmp rcx, 1
+        jle factorial_done_#ID
+        imul rax, rcx
+        jo register_overflow
+        dec rcx
+        jmp factorial_loop_#ID
+factorial_done_#ID:
+        mov qword ptr [a], rax
+
+        # push result onto stack
+        #PUSH a
+
+        # stack size didn't change; popped one, pushed one.
+`
+	return strings.Replace(text, "#ID", fmt.Sprintf("%d", i), -1)
+}
+
+// genIntegerPower generates assembly code to pop two integers from the
+// stack, and push the result of raising one to the power of the other.
+//
+// The exponent mustn't be negative, as the result wouldn't be an integer.
+func (c *Compiler) genIntegerPower(i int) string {
+	calc := `        # the exponent mustn't be negative
+        cmp rcx, 0
+        jl domain_error
+
+        # raise rdx to the power of rcx, by repeated squaring
+        mov rdx, rax
+        mov rax, 1
+power_loop_#ID:
+        test rcx, 1
+        jz power_skip_#ID
+        imul rax, rdx
+        jo register_overflow
+power_skip_#ID:
+        shr rcx, 1
+        jz power_done_#ID
+        imul rdx, rdx
+        jo register_overflow
+        jmp power_loop_#ID
+power_done_#ID:
+`
+	calc = strings.Replace(calc, "#ID", fmt.Sprintf("%d", i), -1)
+	return c.integerBinary("POWER", calc)
+}
+
+// genIntegerRandInt generates assembly code to pop two integers from the
+// stack, and push a random integer which lies between them, inclusively.
+func (c *Compiler) genIntegerRandInt() string {
+	return `
+        # [RANDINT]
+        # ensure there are at least two arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 2
+        jb stack_error
+
+        # pop two values
+        #POP a
+        #POP b
+
+        # the size of the range is (a - b + 1), which must be positive.
+        mov rax, qword ptr [a]
+        sub rax, qword ptr [b]
+        jo register_overflow
+        jl domain_error
+        inc rax
+        jo register_overflow
+        mov qword ptr [int], rax
+
+        # scale a random number to the range, and truncate it.
+        call rand_uniform
+        fild qword ptr [int]
+        fmulp st(1), st(0)
+        fisttp qword ptr [int]
+        mov rax, qword ptr [int]
+        add rax, qword ptr [b]
+        mov qword ptr [a], rax
+
+        # push the result back onto the stack
+        #PUSH a
+
+        # we took two values from the stack, but added one
+        # so the net result is the stack shrunk by one.
+        dec qword ptr [depth]
+`
+}
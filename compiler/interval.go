@@ -0,0 +1,520 @@
+// interval.go contains the code for emitting instructions which operate
+// upon intervals, along with the runtime which implements their
+// arithmetic.
+
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/skx/math-compiler/instructions"
+)
+
+// interval returns the closest doubles either side of the given literal,
+// such as "0.1", which is to say the smallest interval enclosing it.
+func interval(literal string) (float64, float64, error) {
+	exact, ok := new(big.Rat).SetString(literal)
+	f, err := strconv.ParseFloat(literal, 64)
+	if !ok || err != nil || math.IsInf(f, 0) {
+		return 0, 0, fmt.Errorf("the number '%s' is out of range", literal)
+	}
+
+	lo, hi := f, f
+	switch new(big.Rat).SetFloat64(f).Cmp(exact) {
+	case 1:
+		lo = math.Nextafter(f, math.Inf(-1))
+	case -1:
+		hi = math.Nextafter(f, math.Inf(1))
+	}
+	return lo, hi, nil
+}
+
+// intervalConstant returns the declaration of the given constant, as the
+// bit-patterns of its bounds.
+func (c *Compiler) intervalConstant(value string) string {
+	lo, hi, _ := interval(value)
+	return fmt.Sprintf("%s: .quad 0x%016X, 0x%016X\n", c.escapeConstant(value),
+		math.Float64bits(lo), math.Float64bits(hi))
+}
+
+// genInterval generates the assembly code for the given instruction when
+// working with intervals.
+//
+// Each entry upon our stack is a lower bound followed by an upper bound,
+// both of which are doubles.  The lower bound of each result is rounded
+// down, and the upper bound up, so that the interval always contains the
+// exact result.
+func (c *Compiler) genInterval(opr instructions.Instruction) string {
+
+	switch opr.Type {
+
+	case instructions.Abs:
+		return c.intervalUnary("ABS", "interval_abs")
+
+	case instructions.Divide:
+		return c.intervalBinary("DIVIDE", "interval_div")
+
+	case instructions.Dup:
+		return c.genDup()
+
+	case instructions.Input:
+		return c.genInput(opr.Value)
+
+	case instructions.Label:
+		return c.genLabel(opr.Value)
+
+	case instructions.Minus:
+		return c.intervalBinary("MINUS", "interval_sub")
+
+	case instructions.Multiply:
+		return c.intervalBinary("MULTIPLY", "interval_mul")
+
+	case instructions.Plus:
+		return c.intervalBinary("PLUS", "interval_add")
+
+	case instructions.Power:
+		return c.intervalBinary("POWER", "interval_pow")
+
+	case instructions.Print:
+		return c.genPrint()
+
+	case instructions.PrintStack:
+		return c.genPrintStack()
+
+	case instructions.Push:
+		return c.genPush(opr.Value)
+
+	case instructions.Sqrt:
+		return c.intervalUnary("SQRT", "interval_sqrt")
+
+	case instructions.Swap:
+		return c.genSwap()
+
+	}
+	return ""
+}
+
+// intervalBinary returns the assembly code which pops two intervals from
+// the stack, and pushes the result of calling the named function of our
+// runtime with them.
+func (c *Compiler) intervalBinary(name string, fn string) string {
+	text := `
+        # [#NAME]
+        # ensure there are at least two arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 2
+        jb stack_error
+
+        # pop two values, and calculate [b] op [a]
+        #POP a
+        #POP b
+        #CLEAR
+        call #FN
+        #CHECK
+
+        # push the result back onto the stack
+        #PUSH a
+
+        # we took two values from the stack, but added one
+        # so the net result is the stack shrunk by one.
+        dec qword ptr [depth]
+`
+	text = strings.Replace(text, "#NAME", name, -1)
+	return c.fpChecks(strings.Replace(text, "#FN", fn, -1))
+}
+
+// intervalUnary returns the assembly code which pops an interval from the
+// stack, and pushes the result of calling the named function of our
+// runtime with it.
+func (c *Compiler) intervalUnary(name string, fn string) string {
+	text := `
+        # [#NAME]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # pop one value
+        #POP a
+        #CLEAR
+        call #FN
+        #CHECK
+
+        # push result onto stack
+        #PUSH a
+
+        # stack size didn't change; popped one, pushed one.
+`
+	text = strings.Replace(text, "#NAME", name, -1)
+	return c.fpChecks(strings.Replace(text, "#FN", fn, -1))
+}
+
+// genIntervalData generates the data-area entries which are used to print
+// intervals, and by our runtime.
+func (c *Compiler) genIntervalData() string {
+	return `
+#
+# Intervals.
+#
+#       fpu_down: the FPU control-word which rounds towards -Infinity.
+#
+#         fpu_up: the FPU control-word which rounds towards +Infinity.
+#
+#     mxcsr_down: the MXCSR which rounds towards -Infinity, and so on.
+#
+#  interval_open: printed before an interval.
+#
+#   interval_sep: printed between the bounds of an interval.
+#
+# interval_close: printed after an interval.
+#
+#    interval_lo: used to hold a lower bound, whilst the upper bound is
+#                 calculated.
+#
+#     interval_p: used to hold the four products, or quotients, of the
+#                 bounds when multiplying, or dividing.
+#
+#  interval_base: used to hold the base, when raising to a power.
+#
+# interval_result: used to hold the result, when raising to a power.
+#
+       fpu_down: .word 0x067F
+         fpu_up: .word 0x0A7F
+     mxcsr_down: .long 0x3F80
+       mxcsr_up: .long 0x5F80
+     mxcsr_near: .long 0x1F80
+  interval_open: .asciz "["
+   interval_sep: .asciz ", "
+ interval_close: .asciz "]"
+    interval_lo: .quad 0
+     interval_p: .quad 0, 0, 0, 0
+  interval_base: .octa 0
+interval_result: .octa 0
+`
+}
+
+// genIntervalHelpers generates the runtime which implements the arithmetic
+// of intervals.
+//
+// The functions operate upon the values in [b] and [a], storing their
+// result in [a].  Each bound is calculated by the FPU, after changing its
+// rounding mode.
+func (c *Compiler) genIntervalHelpers() string {
+	text := `
+#
+# [a] = [b] + [a]
+#
+interval_add:
+        fldcw word ptr [fpu_down]
+        fld qword ptr [b]
+        fadd qword ptr [a]
+        fstp qword ptr [a]
+        fldcw word ptr [fpu_up]
+        fld qword ptr [b + 8]
+        fadd qword ptr [a + 8]
+        fstp qword ptr [a + 8]
+        fldcw word ptr [fpu_cw]
+        ret
+
+#
+# [a] = [b] - [a], which is bounded by subtracting the opposite bounds.
+#
+interval_sub:
+        fldcw word ptr [fpu_down]
+        fld qword ptr [b]
+        fsub qword ptr [a + 8]
+        fstp qword ptr [interval_lo]
+        fldcw word ptr [fpu_up]
+        fld qword ptr [b + 8]
+        fsub qword ptr [a]
+        fstp qword ptr [a + 8]
+        fldcw word ptr [fpu_cw]
+        mov rax, qword ptr [interval_lo]
+        mov qword ptr [a], rax
+        ret
+
+#
+# [a] = [b] * [a], which is bounded by the smallest, and largest, of the
+# products of the bounds.
+#
+interval_mul:
+        fldcw word ptr [fpu_down]
+        call interval_products
+        call interval_smallest
+        movsd qword ptr [interval_lo], xmm0
+        fldcw word ptr [fpu_up]
+        call interval_products
+        call interval_largest
+        movsd qword ptr [a + 8], xmm0
+        fldcw word ptr [fpu_cw]
+        mov rax, qword ptr [interval_lo]
+        mov qword ptr [a], rax
+        ret
+
+interval_products:
+        fld qword ptr [b]
+        fmul qword ptr [a]
+        fstp qword ptr [interval_p]
+        fld qword ptr [b]
+        fmul qword ptr [a + 8]
+        fstp qword ptr [interval_p + 8]
+        fld qword ptr [b + 8]
+        fmul qword ptr [a]
+        fstp qword ptr [interval_p + 16]
+        fld qword ptr [b + 8]
+        fmul qword ptr [a + 8]
+        fstp qword ptr [interval_p + 24]
+        ret
+
+#
+# [a] = [b] / [a], which is bounded by the smallest, and largest, of the
+# quotients of the bounds - unless the divisor contains zero.
+#
+interval_div:
+        xorpd xmm1, xmm1
+        movsd xmm0, qword ptr [a]
+        ucomisd xmm0, xmm1
+        ja interval_div_nonzero
+        movsd xmm0, qword ptr [a + 8]
+        ucomisd xmm1, xmm0
+        ja interval_div_nonzero
+#ZERO
+interval_div_nonzero:
+        fldcw word ptr [fpu_down]
+        call interval_quotients
+        call interval_smallest
+        movsd qword ptr [interval_lo], xmm0
+        fldcw word ptr [fpu_up]
+        call interval_quotients
+        call interval_largest
+        movsd qword ptr [a + 8], xmm0
+        fldcw word ptr [fpu_cw]
+        mov rax, qword ptr [interval_lo]
+        mov qword ptr [a], rax
+        ret
+
+interval_quotients:
+        fld qword ptr [b]
+        fdiv qword ptr [a]
+        fstp qword ptr [interval_p]
+        fld qword ptr [b]
+        fdiv qword ptr [a + 8]
+        fstp qword ptr [interval_p + 8]
+        fld qword ptr [b + 8]
+        fdiv qword ptr [a]
+        fstp qword ptr [interval_p + 16]
+        fld qword ptr [b + 8]
+        fdiv qword ptr [a + 8]
+        fstp qword ptr [interval_p + 24]
+        ret
+
+#
+# Return the smallest, or largest, of the four values in interval_p in
+# xmm0.
+#
+interval_smallest:
+        movsd xmm0, qword ptr [interval_p]
+        minsd xmm0, qword ptr [interval_p + 8]
+        minsd xmm0, qword ptr [interval_p + 16]
+        minsd xmm0, qword ptr [interval_p + 24]
+        ret
+
+interval_largest:
+        movsd xmm0, qword ptr [interval_p]
+        maxsd xmm0, qword ptr [interval_p + 8]
+        maxsd xmm0, qword ptr [interval_p + 16]
+        maxsd xmm0, qword ptr [interval_p + 24]
+        ret
+
+#
+# [a] = sqrt([a]), which requires that [a] isn't negative.
+#
+interval_sqrt:
+        xorpd xmm1, xmm1
+        movsd xmm0, qword ptr [a]
+        ucomisd xmm0, xmm1
+        jb domain_error
+        fldcw word ptr [fpu_down]
+        fld qword ptr [a]
+        fsqrt
+        fstp qword ptr [a]
+        fldcw word ptr [fpu_up]
+        fld qword ptr [a + 8]
+        fsqrt
+        fstp qword ptr [a + 8]
+        fldcw word ptr [fpu_cw]
+        ret
+
+#
+# [a] = abs([a]), which begins at zero if [a] contains it.
+#
+interval_abs:
+        xorpd xmm2, xmm2
+        movsd xmm0, qword ptr [a]
+        movsd xmm1, qword ptr [a + 8]
+        ucomisd xmm0, xmm2
+        jae interval_abs_done
+        ucomisd xmm1, xmm2
+        jbe interval_abs_negative
+        mov rax, qword ptr [a]
+        btc rax, 63
+        movq xmm0, rax
+        maxsd xmm0, xmm1
+        movsd qword ptr [a + 8], xmm0
+        mov qword ptr [a], 0
+        ret
+interval_abs_negative:
+        mov rax, qword ptr [a]
+        mov rcx, qword ptr [a + 8]
+        btc rax, 63
+        btc rcx, 63
+        mov qword ptr [a], rcx
+        mov qword ptr [a + 8], rax
+interval_abs_done:
+        ret
+
+#
+# [a] = [b] ^ [a], where [a] must be a single whole number.  This is
+# calculated by repeated squaring.
+#
+interval_pow:
+        movsd xmm0, qword ptr [a]
+        ucomisd xmm0, qword ptr [a + 8]
+        jp domain_error
+        jne domain_error
+        mov rax, qword ptr [a]
+        btr rax, 63
+        movq xmm1, rax
+        mov rax, 0x43D0000000000000     # 2^62
+        movq xmm2, rax
+        ucomisd xmm1, xmm2
+        jae domain_error
+        cvttsd2si rcx, xmm0
+        cvtsi2sd xmm1, rcx
+        ucomisd xmm0, xmm1
+        jne domain_error
+        push rbx
+        mov rbx, rcx
+
+        # an even power of the base is the same power of its magnitude,
+        # which gives a tighter result if the base contains zero.
+        movupd xmm0, xmmword ptr [b]
+        movupd xmmword ptr [a], xmm0
+        test rbx, 1
+        jnz interval_pow_base
+        call interval_abs
+interval_pow_base:
+        movupd xmm0, xmmword ptr [a]
+        movupd xmmword ptr [interval_base], xmm0
+
+        # a negative exponent raises the reciprocal of the base.
+        test rbx, rbx
+        jns interval_pow_start
+        neg rbx
+        mov rax, 0x3FF0000000000000     # 1.0
+        mov qword ptr [b], rax
+        mov qword ptr [b + 8], rax
+        call interval_div
+        movupd xmm0, xmmword ptr [a]
+        movupd xmmword ptr [interval_base], xmm0
+
+interval_pow_start:
+        mov rax, 0x3FF0000000000000     # 1.0
+        mov qword ptr [interval_result], rax
+        mov qword ptr [interval_result + 8], rax
+interval_pow_next:
+        test rbx, 1
+        jz interval_pow_square
+        movupd xmm0, xmmword ptr [interval_result]
+        movupd xmmword ptr [b], xmm0
+        movupd xmm0, xmmword ptr [interval_base]
+        movupd xmmword ptr [a], xmm0
+        call interval_mul
+        movupd xmm0, xmmword ptr [a]
+        movupd xmmword ptr [interval_result], xmm0
+interval_pow_square:
+        shr rbx, 1
+        jz interval_pow_done
+        movupd xmm0, xmmword ptr [interval_base]
+        movupd xmmword ptr [b], xmm0
+        movupd xmmword ptr [a], xmm0
+        call interval_mul
+        movupd xmm0, xmmword ptr [a]
+        movupd xmmword ptr [interval_base], xmm0
+        jmp interval_pow_next
+interval_pow_done:
+        movupd xmm0, xmmword ptr [interval_result]
+        movupd xmmword ptr [a], xmm0
+        pop rbx
+        ret
+
+#
+# Convert the string pointed to by rdi into the smallest interval which
+# contains it, which is stored in [a].  The end of the number is stored
+# at the location pointed to by rsi.
+#
+# strtod rounds according to the FPU control-word, or the MXCSR if the
+# number is too small to represent, so we call it once for each bound.
+#
+interval_parse:
+        push rbx
+        push r12
+        sub rsp, 8
+        mov rbx, rdi
+        mov r12, rsi
+        fldcw word ptr [fpu_down]
+        ldmxcsr dword ptr [mxcsr_down]
+        mov rdi, rbx
+        mov rsi, r12
+        call strtod
+        movsd qword ptr [a], xmm0
+        fldcw word ptr [fpu_up]
+        ldmxcsr dword ptr [mxcsr_up]
+        mov rdi, rbx
+        mov rsi, r12
+        call strtod
+        movsd qword ptr [a + 8], xmm0
+        fldcw word ptr [fpu_cw]
+        ldmxcsr dword ptr [mxcsr_near]
+
+        # an overflow gives an infinite result, whichever way we round,
+        # so the other bound is replaced by the largest finite value.
+        mov rax, 0x7FF0000000000000     # +Infinity
+        mov rcx, 0x7FEFFFFFFFFFFFFF     # the largest double
+        mov rdx, qword ptr [a]
+        cmp rdx, rax
+        cmove rdx, rcx
+        mov qword ptr [a], rdx
+        bts rax, 63
+        bts rcx, 63
+        mov rdx, qword ptr [a + 8]
+        cmp rdx, rax
+        cmove rdx, rcx
+        mov qword ptr [a + 8], rdx
+        add rsp, 8
+        pop r12
+        pop rbx
+        ret
+`
+
+	//
+	// Dividing by an interval which contains zero is an error, unless
+	// we're following IEEE semantics - when the result could be any
+	// number at all.
+	//
+	zero := `        jmp division_by_zero
+`
+	if !c.trapping() {
+		zero = `        mov rax, 0xFFF0000000000000     # -Infinity
+        mov qword ptr [a], rax
+        mov rax, 0x7FF0000000000000     # +Infinity
+        mov qword ptr [a + 8], rax
+        ret
+`
+	}
+	return strings.Replace(text, "#ZERO\n", zero, -1)
+}
@@ -0,0 +1,803 @@
+// quotation.go contains the code for quotations, such as "[ x sin ]",
+// which are compiled to subroutines that may be integrated, or solved.
+
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/skx/math-compiler/token"
+)
+
+// checkQuotations ensures that each quotation is closed, calculates a
+// single value from its variable, and is used by exactly one `integrate`
+// or `solve`, within the program or quotation which contains it.
+//
+// The number of values upon the stack of each quotation is tracked at
+// compile time, so that a quotation can never use the values of the
+// program which calls it.
+func (c *Compiler) checkQuotations() error {
+
+	// depth holds the number of values upon the stack of each of the
+	// quotations we're within, and pending the number of quotations
+	// each contains which are yet to be used.
+	depth := []int{0}
+	pending := []int{0}
+
+	for _, tok := range c.tokens {
+		n := len(depth) - 1
+
+		switch tok.Type {
+		case token.LBRACKET:
+			depth = append(depth, 0)
+			pending = append(pending, 0)
+			continue
+
+		case token.RBRACKET:
+			if n == 0 {
+				return fmt.Errorf("']' was found without a matching '['")
+			}
+			if pending[n] > 0 {
+				return fmt.Errorf("a quotation must be followed by its bounds, and integrate or solve")
+			}
+			if depth[n] != 1 {
+				return fmt.Errorf("a quotation must calculate exactly one value, such as [ x sin ]")
+			}
+			depth = depth[:n]
+			pending = pending[:n]
+			pending[n-1]++
+			continue
+
+		case token.INTEGRATE, token.SOLVE:
+			if pending[n] == 0 {
+				return fmt.Errorf("'%s' requires a quotation, such as [ x sin ] 0 pi %s", tok.Literal, tok.Literal)
+			}
+			pending[n]--
+
+		case token.LABEL, token.PRINT, token.PRINTSTACK:
+			if n > 0 {
+				return fmt.Errorf("a quotation can't print its values")
+			}
+
+		case token.UNIT:
+			if c.quoted() {
+				return fmt.Errorf("values measured in units can't be integrated, or solved")
+			}
+		}
+
+		// The program's own stack is checked when it runs.
+		if n == 0 {
+			continue
+		}
+		needs, leaves := effect(tok)
+		if depth[n] < needs {
+			return fmt.Errorf("a quotation has too few values upon its stack for '%s'", tok.Literal)
+		}
+		depth[n] += leaves - needs
+	}
+
+	if len(depth) > 1 {
+		return fmt.Errorf("'[' was found without a matching ']'")
+	}
+	if pending[0] > 0 {
+		return fmt.Errorf("a quotation must be followed by its bounds, and integrate or solve")
+	}
+
+	//
+	// Our integrals are of numbers, rather than units.
+	//
+	if c.unit != "" && c.quoted() {
+		return fmt.Errorf("values measured in units can't be integrated, or solved")
+	}
+	return nil
+}
+
+// quoted returns true if our program contains a quotation.
+func (c *Compiler) quoted() bool {
+	for _, tok := range c.tokens {
+		if tok.Type == token.LBRACKET {
+			return true
+		}
+	}
+	return false
+}
+
+// effect returns the number of values the given token takes from the
+// stack, and the number it leaves in their place.
+func effect(tok token.Token) (int, int) {
+	switch tok.Type {
+	case token.NUMBER, token.IDENT, token.RAND, token.RANDN, token.VECTOR:
+		return 0, 1
+	case token.DUP:
+		return 1, 2
+	case token.SWAP:
+		return 2, 2
+	case token.ASTERISK, token.CROSS, token.DOT, token.FLOORMOD, token.INTEGRATE, token.MINUS, token.MOD, token.PLUS, token.POWER, token.RANDINT, token.SLASH, token.SOLVE:
+		return 2, 1
+	case token.STRING:
+		return 0, 0
+	}
+	return 1, 1
+}
+
+// genVariable generates assembly code to push the variable of the
+// quotation we're within, which it keeps in its frame.
+func (c *Compiler) genVariable() string {
+	return `
+        # [VARIABLE]
+        # Load the value of x, which our quotation was called with, onto the stack
+        # Increase the value stored at [depth] to note we've a new stack-entry
+        fld qword ptr [rbp - 8]
+        fstp #PTR [a]
+        #PUSH a
+        inc qword ptr [depth]
+`
+}
+
+// genDriver generates assembly code to pop two values from the stack,
+// and push the result of the given driver, either "integrate" or "solve",
+// upon the given quotation between them.
+func (c *Compiler) genDriver(driver string, quotation string) string {
+	text := `
+        # [#NAME]
+        # ensure there are at least two arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 2
+        jb stack_error
+
+        # pop two values, the upper bound and the lower
+        #POP a
+        #POP b
+
+        # call our driver with the quotation, and the bounds as doubles
+        fld #PTR [b]
+        fstp qword ptr [int]
+        movsd xmm0, qword ptr [int]
+        fld #PTR [a]
+        fstp qword ptr [int]
+        movsd xmm1, qword ptr [int]
+        lea rdi, quotation_#ID
+        call #DRIVER
+        movsd qword ptr [int], xmm0
+        fld qword ptr [int]
+        fstp #PTR [a]
+
+        # push the result back onto the stack
+        #PUSH a
+
+        # we took two values from the stack, but added one
+        # so the net result is the stack shrunk by one.
+        dec qword ptr [depth]
+`
+	text = strings.Replace(text, "#NAME", strings.ToUpper(driver), -1)
+	text = strings.Replace(text, "#DRIVER", driver, -1)
+	return strings.Replace(text, "#ID", quotation, -1)
+}
+
+// genQuotations generates a subroutine for each of our quotations.
+//
+// The instructions of our program are numbered from zero, so those of
+// our quotations are numbered from the given index.
+func (c *Compiler) genQuotations(index int) string {
+	text := ""
+
+	for id, quotation := range c.quotations {
+		text += strings.Replace(`
+#
+# Quotation #ID, which is called with its variable, x, in xmm0 and
+# returns its value in xmm0.
+#
+# x is kept in our frame, and since we use the stack of our caller we
+# count our values from zero, restoring [depth] when we return.
+#
+quotation_#ID:
+        push rbp
+        mov rbp, rsp
+        sub rsp, 8
+        movsd qword ptr [rbp - 8], xmm0
+        push qword ptr [depth]
+        mov qword ptr [depth], 0
+`, "#ID", strconv.Itoa(id), -1)
+
+		for _, opr := range quotation {
+			text += c.genInstruction(opr, index)
+			index++
+		}
+
+		text += `
+        # return the one value which remains
+        #POP a
+        fld #PTR [a]
+        fstp qword ptr [int]
+        movsd xmm0, qword ptr [int]
+        pop qword ptr [depth]
+        mov rsp, rbp
+        pop rbp
+        ret
+`
+	}
+	return text
+}
+
+// genQuotationData generates the data-area entries which are used by
+// our drivers.
+func (c *Compiler) genQuotationData() string {
+	text := `
+#
+# Integrals, and roots.
+#
+#   real_N: the constant N, used by both drivers.
+#
+# simpson_tol: the error we accept in an integral, relative to its size,
+#              which is larger than the precision of our values.
+#
+# simpson_min: the smallest tolerance we use, relative to our first.
+#
+#   brent_eps: twice the precision of a double, which is the smallest
+#              step we take towards a root.
+#
+#   brent_min: the smallest normal double, which is the smallest step
+#              we take towards a root of zero.
+#
+     real_1: .double 1.0
+     real_3: .double 3.0
+     real_4: .double 4.0
+     real_6: .double 6.0
+    real_15: .double 15.0
+simpson_tol: .double #TOLERANCE
+simpson_min: .double 1e-6
+  brent_eps: .double 4.4408920985006262e-16
+  brent_min: .double 2.2250738585072014e-308
+    no_root: .asciz "The function doesn't change sign over the interval.  Aborting\n"
+`
+
+	if c.precision == "single" {
+		return strings.Replace(text, "#TOLERANCE", "1e-6", -1)
+	}
+	return strings.Replace(text, "#TOLERANCE", "1e-12", -1)
+}
+
+// genQuotationHelpers generates the drivers which integrate, and solve,
+// our quotations.
+//
+// Each driver is called with the address of a quotation in rdi, and
+// the bounds of an interval in xmm0 and xmm1.  Since a quotation may
+// itself call a driver all of their state is kept in their frames,
+// which we name with placeholders.
+func (c *Compiler) genQuotationHelpers() string {
+	integrate := `
+#
+# Integrate the quotation in rdi, from xmm0 to xmm1, returning the
+# integral in xmm0.
+#
+# We use Simpson's rule over the interval, and then each half of it, and
+# only divide further those parts where the two disagree - until their
+# difference is within a tolerance relative to the size of the integral.
+#
+integrate:
+        push rbp
+        mov rbp, rsp
+        sub rsp, 80
+        mov qword ptr #F, rdi
+        movsd qword ptr #A, xmm0
+        movsd qword ptr #B, xmm1
+
+        # calculate f(a), f(b), and f at their midpoint, m
+        call qword ptr #F
+        movsd qword ptr #FA, xmm0
+        movsd xmm0, qword ptr #B
+        call qword ptr #F
+        movsd qword ptr #FB, xmm0
+        movsd xmm0, qword ptr #A
+        addsd xmm0, qword ptr #B
+        mulsd xmm0, qword ptr [half]
+        movsd qword ptr #M, xmm0
+        call qword ptr #F
+        movsd qword ptr #FM, xmm0
+
+        # Simpson's rule, (b - a) * (f(a) + 4 f(m) + f(b)) / 6
+        movsd xmm0, qword ptr #FM
+        mulsd xmm0, qword ptr [real_4]
+        addsd xmm0, qword ptr #FA
+        addsd xmm0, qword ptr #FB
+        movsd xmm1, qword ptr #B
+        subsd xmm1, qword ptr #A
+        mulsd xmm0, xmm1
+        divsd xmm0, qword ptr [real_6]
+        movsd qword ptr #WHOLE, xmm0
+
+        # our tolerance
+        movq rax, xmm0
+        btr rax, 63
+        movq xmm0, rax
+        mulsd xmm0, qword ptr [simpson_tol]
+        movsd qword ptr #TOL, xmm0
+
+        # simpson(f, a, b, f(a), f(b), f(m), whole, tolerance, depth)
+        push 24
+        push qword ptr #TOL
+        push qword ptr #WHOLE
+        push qword ptr #FM
+        push qword ptr #FB
+        push qword ptr #FA
+        push qword ptr #B
+        push qword ptr #A
+        push qword ptr #F
+        call simpson
+        movsd qword ptr #RESULT, xmm0
+
+        # If the integral is much smaller than Simpson's rule over the
+        # whole interval suggested our tolerance was too large, so we
+        # integrate again with a tolerance relative to the integral - but
+        # no smaller than a millionth of the first, as it may be zero.
+        #ABS xmm0, #RESULT
+        mulsd xmm0, qword ptr [simpson_tol]
+        movsd xmm1, qword ptr #TOL
+        mulsd xmm1, qword ptr [half]
+        ucomisd xmm0, xmm1
+        jp integrate_done
+        jae integrate_done
+        movsd xmm1, qword ptr #TOL
+        mulsd xmm1, qword ptr [simpson_min]
+        maxsd xmm0, xmm1
+        movsd qword ptr #TOL, xmm0
+
+        lea rsp, #RESULT
+        push 24
+        push qword ptr #TOL
+        push qword ptr #WHOLE
+        push qword ptr #FM
+        push qword ptr #FB
+        push qword ptr #FA
+        push qword ptr #B
+        push qword ptr #A
+        push qword ptr #F
+        call simpson
+        movsd qword ptr #RESULT, xmm0
+
+integrate_done:
+        movsd xmm0, qword ptr #RESULT
+        mov rsp, rbp
+        pop rbp
+        ret
+
+#
+# Integrate a part of an interval, whose arguments are upon the stack,
+# returning the integral in xmm0.
+#
+# If we've divided the interval as often as we allow we return our best
+# estimate, rather than continue.
+#
+simpson:
+        push rbp
+        mov rbp, rsp
+        sub rsp, 80
+
+        # the midpoint, m, and the midpoints of each half, l and r
+        movsd xmm0, qword ptr #A
+        addsd xmm0, qword ptr #B
+        mulsd xmm0, qword ptr [half]
+        movsd qword ptr #M, xmm0
+        movsd xmm0, qword ptr #A
+        addsd xmm0, qword ptr #M
+        mulsd xmm0, qword ptr [half]
+        movsd qword ptr #L, xmm0
+        movsd xmm0, qword ptr #M
+        addsd xmm0, qword ptr #B
+        mulsd xmm0, qword ptr [half]
+        movsd qword ptr #R, xmm0
+        movsd xmm0, qword ptr #L
+        call qword ptr #F
+        movsd qword ptr #FL, xmm0
+        movsd xmm0, qword ptr #R
+        call qword ptr #F
+        movsd qword ptr #FR, xmm0
+
+        # Simpson's rule over each half
+        movsd xmm0, qword ptr #FL
+        mulsd xmm0, qword ptr [real_4]
+        addsd xmm0, qword ptr #FA
+        addsd xmm0, qword ptr #FM
+        movsd xmm1, qword ptr #M
+        subsd xmm1, qword ptr #A
+        mulsd xmm0, xmm1
+        divsd xmm0, qword ptr [real_6]
+        movsd qword ptr #LEFT, xmm0
+        movsd xmm0, qword ptr #FR
+        mulsd xmm0, qword ptr [real_4]
+        addsd xmm0, qword ptr #FM
+        addsd xmm0, qword ptr #FB
+        movsd xmm1, qword ptr #B
+        subsd xmm1, qword ptr #M
+        mulsd xmm0, xmm1
+        divsd xmm0, qword ptr [real_6]
+        movsd qword ptr #RIGHT, xmm0
+
+        # the difference between the halves, and the whole
+        movsd xmm0, qword ptr #LEFT
+        addsd xmm0, qword ptr #RIGHT
+        subsd xmm0, qword ptr #WHOLE
+        movsd qword ptr #DELTA, xmm0
+
+        # we're done if the difference is within 15 times our tolerance,
+        # or isn't a number, or we can't divide the interval further
+        cmp qword ptr #DEPTH, 0
+        je simpson_done
+        movq rax, xmm0
+        btr rax, 63
+        movq xmm0, rax
+        movsd xmm1, qword ptr #TOL
+        mulsd xmm1, qword ptr [real_15]
+        ucomisd xmm0, xmm1
+        jbe simpson_done
+
+        # otherwise we integrate each half, with half the tolerance
+        movsd xmm0, qword ptr #TOL
+        mulsd xmm0, qword ptr [half]
+        movsd qword ptr #HALFTOL, xmm0
+        mov rax, qword ptr #DEPTH
+        dec rax
+        push rax
+        push qword ptr #HALFTOL
+        push qword ptr #LEFT
+        push qword ptr #FL
+        push qword ptr #FM
+        push qword ptr #FA
+        push qword ptr #M
+        push qword ptr #A
+        push qword ptr #F
+        call simpson
+        add rsp, 72
+        movsd qword ptr #SUM, xmm0
+        mov rax, qword ptr #DEPTH
+        dec rax
+        push rax
+        push qword ptr #HALFTOL
+        push qword ptr #RIGHT
+        push qword ptr #FR
+        push qword ptr #FB
+        push qword ptr #FM
+        push qword ptr #B
+        push qword ptr #M
+        push qword ptr #F
+        call simpson
+        add rsp, 72
+        addsd xmm0, qword ptr #SUM
+        jmp simpson_return
+
+simpson_done:
+        # the sum of the halves, corrected by a fifteenth of the difference
+        movsd xmm0, qword ptr #DELTA
+        divsd xmm0, qword ptr [real_15]
+        addsd xmm0, qword ptr #LEFT
+        addsd xmm0, qword ptr #RIGHT
+simpson_return:
+        mov rsp, rbp
+        pop rbp
+        ret
+`
+
+	solve := `
+#
+# Find a root of the quotation in rdi, between xmm0 and xmm1, returning
+# it in xmm0.
+#
+# We use Brent's method, which keeps the root between b, our best estimate,
+# and c - interpolating between the points we've seen where we can, and
+# bisecting the interval where we can't.  The function must change sign
+# over the interval, or be zero at one end of it.
+#
+solve:
+        push rbp
+        mov rbp, rsp
+        sub rsp, 128
+        mov qword ptr #F, rdi
+        movsd qword ptr #A, xmm0
+        movsd qword ptr #B, xmm1
+
+        # calculate f(a), and f(b)
+        call qword ptr #F
+        movsd qword ptr #FA, xmm0
+        movsd xmm0, qword ptr #B
+        call qword ptr #F
+        movsd qword ptr #FB, xmm0
+
+        # either end might be a root
+        xorpd xmm1, xmm1
+        movsd xmm0, qword ptr #FB
+        ucomisd xmm0, xmm1
+        jp solve_no_root
+        je solve_done
+        movsd xmm0, qword ptr #FA
+        ucomisd xmm0, xmm1
+        jp solve_no_root
+        je solve_found_a
+
+        # otherwise their signs must differ
+        mov rax, qword ptr #FA
+        xor rax, qword ptr #FB
+        jns solve_no_root
+
+        # c = b
+        mov rax, qword ptr #B
+        mov qword ptr #C, rax
+        mov rax, qword ptr #FB
+        mov qword ptr #FC, rax
+        mov qword ptr #ITER, 0
+
+solve_loop:
+        # if f(b) and f(c) have the same sign the root lies between a and
+        # b, so c = a, and our last steps are the width of the interval
+        xorpd xmm1, xmm1
+        movsd xmm0, qword ptr #FB
+        ucomisd xmm0, xmm1
+        je solve_bracketed
+        movsd xmm0, qword ptr #FC
+        ucomisd xmm0, xmm1
+        je solve_bracketed
+        mov rax, qword ptr #FB
+        xor rax, qword ptr #FC
+        js solve_bracketed
+        mov rax, qword ptr #A
+        mov qword ptr #C, rax
+        mov rax, qword ptr #FA
+        mov qword ptr #FC, rax
+        movsd xmm0, qword ptr #B
+        subsd xmm0, qword ptr #A
+        movsd qword ptr #D, xmm0
+        movsd qword ptr #E, xmm0
+
+solve_bracketed:
+        # b must be the better estimate, with the smaller value
+        #ABS xmm0, #FC
+        #ABS xmm1, #FB
+        comisd xmm0, xmm1
+        jae solve_ordered
+        mov rax, qword ptr #B
+        mov qword ptr #A, rax
+        mov rax, qword ptr #C
+        mov qword ptr #B, rax
+        mov rax, qword ptr #A
+        mov qword ptr #C, rax
+        mov rax, qword ptr #FB
+        mov qword ptr #FA, rax
+        mov rax, qword ptr #FC
+        mov qword ptr #FB, rax
+        mov rax, qword ptr #FA
+        mov qword ptr #FC, rax
+
+solve_ordered:
+        # our tolerance, and half the width of the interval
+        #ABS xmm0, #B
+        mulsd xmm0, qword ptr [brent_eps]
+        addsd xmm0, qword ptr [brent_min]
+        movsd qword ptr #TOL, xmm0
+        movsd xmm0, qword ptr #C
+        subsd xmm0, qword ptr #B
+        mulsd xmm0, qword ptr [half]
+        movsd qword ptr #XM, xmm0
+
+        # we're done if the interval is within our tolerance, if b is
+        # a root, or if we've taken as many steps as we allow
+        #ABS xmm0, #XM
+        comisd xmm0, qword ptr #TOL
+        jbe solve_done
+        xorpd xmm1, xmm1
+        movsd xmm0, qword ptr #FB
+        ucomisd xmm0, xmm1
+        je solve_done
+        inc qword ptr #ITER
+        cmp qword ptr #ITER, 200
+        ja solve_done
+
+        # we interpolate if our step before last was large enough, and
+        # f(a) is larger than f(b)
+        #ABS xmm0, #E
+        comisd xmm0, qword ptr #TOL
+        jb solve_bisect
+        #ABS xmm0, #FA
+        #ABS xmm1, #FB
+        comisd xmm0, xmm1
+        jbe solve_bisect
+
+        # s = f(b) / f(a)
+        movsd xmm0, qword ptr #FB
+        divsd xmm0, qword ptr #FA
+        movsd qword ptr #S, xmm0
+
+        # with two points we use the secant method,
+        # p = 2 xm s, q = 1 - s
+        movsd xmm0, qword ptr #A
+        ucomisd xmm0, qword ptr #C
+        jp solve_inverse
+        jne solve_inverse
+        movsd xmm0, qword ptr #XM
+        addsd xmm0, xmm0
+        mulsd xmm0, qword ptr #S
+        movsd qword ptr #P, xmm0
+        movsd xmm0, qword ptr [real_1]
+        subsd xmm0, qword ptr #S
+        movsd qword ptr #Q, xmm0
+        jmp solve_interpolated
+
+solve_inverse:
+        # with three we use inverse quadratic interpolation,
+        # q = f(a) / f(c), r = f(b) / f(c)
+        movsd xmm0, qword ptr #FA
+        divsd xmm0, qword ptr #FC
+        movsd qword ptr #Q, xmm0
+        movsd xmm0, qword ptr #FB
+        divsd xmm0, qword ptr #FC
+        movsd qword ptr #R, xmm0
+
+        # p = s (2 xm q (q - r) - (b - a) (r - 1))
+        movsd xmm0, qword ptr #XM
+        addsd xmm0, xmm0
+        mulsd xmm0, qword ptr #Q
+        movsd xmm1, qword ptr #Q
+        subsd xmm1, qword ptr #R
+        mulsd xmm0, xmm1
+        movsd xmm1, qword ptr #B
+        subsd xmm1, qword ptr #A
+        movsd xmm2, qword ptr #R
+        subsd xmm2, qword ptr [real_1]
+        mulsd xmm1, xmm2
+        subsd xmm0, xmm1
+        mulsd xmm0, qword ptr #S
+        movsd qword ptr #P, xmm0
+
+        # q = (q - 1) (r - 1) (s - 1)
+        movsd xmm0, qword ptr #Q
+        subsd xmm0, qword ptr [real_1]
+        movsd xmm1, qword ptr #R
+        subsd xmm1, qword ptr [real_1]
+        mulsd xmm0, xmm1
+        movsd xmm1, qword ptr #S
+        subsd xmm1, qword ptr [real_1]
+        mulsd xmm0, xmm1
+        movsd qword ptr #Q, xmm0
+
+solve_interpolated:
+        # our step is p / q, with p made positive
+        xorpd xmm1, xmm1
+        movsd xmm0, qword ptr #P
+        comisd xmm0, xmm1
+        jbe solve_positive
+        btc qword ptr #Q, 63
+solve_positive:
+        btr qword ptr #P, 63
+
+        # which we take if 2p < min(3 xm q - |tol q|, |e q|), so that
+        # we stay within the interval, and converge quickly enough
+        movsd xmm0, qword ptr #XM
+        mulsd xmm0, qword ptr [real_3]
+        mulsd xmm0, qword ptr #Q
+        movsd xmm1, qword ptr #TOL
+        mulsd xmm1, qword ptr #Q
+        movq rax, xmm1
+        btr rax, 63
+        movq xmm1, rax
+        subsd xmm0, xmm1
+        movsd xmm1, qword ptr #E
+        mulsd xmm1, qword ptr #Q
+        movq rax, xmm1
+        btr rax, 63
+        movq xmm1, rax
+        minsd xmm0, xmm1
+        movsd xmm1, qword ptr #P
+        addsd xmm1, xmm1
+        comisd xmm1, xmm0
+        jae solve_bisect
+        mov rax, qword ptr #D
+        mov qword ptr #E, rax
+        movsd xmm0, qword ptr #P
+        divsd xmm0, qword ptr #Q
+        movsd qword ptr #D, xmm0
+        jmp solve_step
+
+solve_bisect:
+        # otherwise we bisect the interval
+        movsd xmm0, qword ptr #XM
+        movsd qword ptr #D, xmm0
+        movsd qword ptr #E, xmm0
+
+solve_step:
+        # a = b, and b takes our step - which is at least our tolerance
+        mov rax, qword ptr #B
+        mov qword ptr #A, rax
+        mov rax, qword ptr #FB
+        mov qword ptr #FA, rax
+        #ABS xmm0, #D
+        comisd xmm0, qword ptr #TOL
+        movsd xmm0, qword ptr #D
+        ja solve_stepped
+        movsd xmm0, qword ptr #TOL
+        mov rax, qword ptr #XM
+        test rax, rax
+        jns solve_stepped
+        movq rax, xmm0
+        btc rax, 63
+        movq xmm0, rax
+solve_stepped:
+        addsd xmm0, qword ptr #B
+        movsd qword ptr #B, xmm0
+        call qword ptr #F
+        movsd qword ptr #FB, xmm0
+        jmp solve_loop
+
+solve_found_a:
+        mov rax, qword ptr #A
+        mov qword ptr #B, rax
+solve_done:
+        movsd xmm0, qword ptr #B
+        mov rsp, rbp
+        pop rbp
+        ret
+
+#
+# This is hit when a root can't be found, since the function doesn't
+# change sign over the interval.
+#
+solve_no_root:
+        lea rdi,no_root
+        mov rdx, 6              # exit-code
+        jmp print_msg_and_exit
+`
+
+	//
+	// The arguments of simpson are upon the stack, above its frame,
+	// while everything else is a local variable.
+	//
+	integrate = frame(integrate[:strings.Index(integrate, "\nsimpson:")], []string{"F", "A", "B", "FA", "FB", "FM", "M", "WHOLE", "TOL", "RESULT"}) +
+		frame(integrate[strings.Index(integrate, "\nsimpson:"):], []string{"M", "L", "R", "FL", "FR", "LEFT", "RIGHT", "DELTA", "HALFTOL", "SUM"})
+	integrate = args(integrate, []string{"F", "A", "B", "FA", "FB", "FM", "WHOLE", "TOL", "DEPTH"})
+	solve = frame(solve, []string{"F", "A", "B", "C", "D", "E", "FA", "FB", "FC", "P", "Q", "R", "S", "TOL", "XM", "ITER"})
+
+	return absolute(integrate) + absolute(solve)
+}
+
+// frame replaces each of the given placeholders, such as "#FA", in the
+// given assembly code with a location in the frame of a subroutine.
+func frame(text string, names []string) string {
+	for i, name := range names {
+		text = placeholder(text, name, fmt.Sprintf("[rbp - %d]", 8*(i+1)))
+	}
+	return text
+}
+
+// args replaces each of the given placeholders in the given assembly
+// code with the location of an argument to a subroutine, upon the stack.
+func args(text string, names []string) string {
+	for i, name := range names {
+		text = placeholder(text, name, fmt.Sprintf("[rbp + %d]", 16+8*i))
+	}
+	return text
+}
+
+// placeholder replaces the placeholder for the given name, which is
+// always followed by the end of a line or a comma, with a location.
+func placeholder(text string, name string, location string) string {
+	text = strings.Replace(text, "#"+name+"\n", location+"\n", -1)
+	return strings.Replace(text, "#"+name+",", location+",", -1)
+}
+
+// absolute expands each "#ABS reg, location" line of the given assembly
+// code, to load the absolute value of the double at location into the
+// register.
+func absolute(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "#ABS ") {
+			continue
+		}
+		operands := strings.Split(strings.TrimPrefix(trimmed, "#ABS "), ", ")
+		lines[i] = fmt.Sprintf(`        movsd %s, qword ptr %s
+        movq rax, %s
+        btr rax, 63
+        movq %s, rax`, operands[0], operands[1], operands[0], operands[0])
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,555 @@
+// rational.go contains the code for emitting instructions which operate
+// upon rational numbers, along with the runtime which implements their
+// arithmetic.
+
+package compiler
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/skx/math-compiler/instructions"
+)
+
+// rational converts the given literal, such as "0.25", into the numerator
+// and denominator of a rational number in its lowest terms.
+func rational(literal string) (int64, int64, error) {
+	r, ok := new(big.Rat).SetString(literal)
+	if !ok || !r.Num().IsInt64() || !r.Denom().IsInt64() {
+		return 0, 0, fmt.Errorf("the number '%s' isn't a rational with 64-bit parts", literal)
+	}
+	return r.Num().Int64(), r.Denom().Int64(), nil
+}
+
+// genRational generates the assembly code for the given instruction when
+// working with rational numbers.
+//
+// Each entry upon our stack is a numerator followed by a denominator,
+// which is always positive.  Every result is reduced to its lowest terms.
+func (c *Compiler) genRational(opr instructions.Instruction) string {
+
+	switch opr.Type {
+
+	case instructions.Abs:
+		return rationalUnary("ABS", "rat_abs")
+
+	case instructions.Divide:
+		return rationalBinary("DIVIDE", "rat_div")
+
+	case instructions.Dup:
+		return c.genDup()
+
+	case instructions.Factorial:
+		return rationalUnary("FACTORIAL", "rat_factorial")
+
+	case instructions.FlooredModulus:
+		return rationalBinary("FLOORED MODULUS", "rat_floored_mod")
+
+	case instructions.Input:
+		return c.genInput(opr.Value)
+
+	case instructions.Label:
+		return c.genLabel(opr.Value)
+
+	case instructions.Minus:
+		return rationalBinary("MINUS", "rat_sub")
+
+	case instructions.Modulus:
+		return rationalBinary("MODULUS", "rat_mod")
+
+	case instructions.Multiply:
+		return rationalBinary("MULTIPLY", "rat_mul")
+
+	case instructions.Plus:
+		return rationalBinary("PLUS", "rat_add")
+
+	case instructions.Power:
+		return rationalBinary("POWER", "rat_pow")
+
+	case instructions.Print:
+		return c.genPrint()
+
+	case instructions.PrintStack:
+		return c.genPrintStack()
+
+	case instructions.Push:
+		return c.genPush(opr.Value)
+
+	case instructions.Swap:
+		return c.genSwap()
+
+	}
+	return ""
+}
+
+// rationalBinary returns the assembly code which pops two rationals from
+// the stack, and pushes the result of calling the named function of our
+// runtime with them.
+func rationalBinary(name string, fn string) string {
+	text := `
+        # [#NAME]
+        # ensure there are at least two arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 2
+        jb stack_error
+
+        # pop two values, and calculate [b] op [a]
+        #POP a
+        #POP b
+        call #FN
+
+        # push the result back onto the stack
+        #PUSH a
+
+        # we took two values from the stack, but added one
+        # so the net result is the stack shrunk by one.
+        dec qword ptr [depth]
+`
+	text = strings.Replace(text, "#NAME", name, -1)
+	return strings.Replace(text, "#FN", fn, -1)
+}
+
+// rationalUnary returns the assembly code which pops a rational from the
+// stack, and pushes the result of calling the named function of our
+// runtime with it.
+func rationalUnary(name string, fn string) string {
+	text := `
+        # [#NAME]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # pop one value
+        #POP a
+        call #FN
+
+        # push result onto stack
+        #PUSH a
+
+        # stack size didn't change; popped one, pushed one.
+`
+	text = strings.Replace(text, "#NAME", name, -1)
+	return strings.Replace(text, "#FN", fn, -1)
+}
+
+// genRationalData generates the data-area entries which are used by our
+// runtime.
+func (c *Compiler) genRationalData() string {
+	text := `
+#
+# Rational numbers.
+#
+# approx_fmt: used to print the approximate value of a fraction.
+#
+#   rat_base: used to hold the base, when raising to a power.
+#
+# rat_result: used to hold the result, when raising to a power, or the
+#             divisor, when finding a remainder.
+#
+ approx_fmt: .asciz "#APPROX_FMT"
+   rat_base: .octa 0
+ rat_result: .octa 0
+`
+	approx := fmt.Sprintf(" ≈ %%.%dg", c.digits)
+	if c.json {
+		approx = fmt.Sprintf(`, \"approximation\": %%.%dg`, c.digits)
+	}
+	return strings.Replace(text, "#APPROX_FMT", approx, -1)
+}
+
+// genRationalHelpers generates the runtime which implements the arithmetic
+// of rational numbers.
+//
+// The functions operate upon the values in [b] and [a], storing their
+// result in [a].  Overflow is reported, rather than wrapping.
+func (c *Compiler) genRationalHelpers() string {
+	return `
+#
+# Return the greatest common divisor of the unsigned values in rdi and
+# rsi, in rax.
+#
+rat_gcd:
+        mov rax, rdi
+        mov rcx, rsi
+rat_gcd_next:
+        test rcx, rcx
+        jz rat_gcd_done
+        xor rdx, rdx
+        div rcx
+        mov rax, rcx
+        mov rcx, rdx
+        jmp rat_gcd_next
+rat_gcd_done:
+        ret
+
+#
+# Store the fraction rax / rdx, whose denominator is positive, in [a]
+# after reducing it to its lowest terms.
+#
+rat_store:
+        mov r8, rax
+        mov r9, rdx
+        mov rdi, rax
+        neg rax
+        cmovns rdi, rax
+        mov rsi, r9
+        call rat_gcd
+        mov rcx, rax
+        mov rax, r8
+        cqo
+        idiv rcx
+        mov qword ptr [a], rax
+        mov rax, r9
+        xor rdx, rdx
+        div rcx
+        mov qword ptr [a + 8], rax
+        ret
+
+#
+# [a] = [b] + [a], after removing the common factor of the denominators.
+#
+rat_add:
+        mov rdi, qword ptr [b + 8]
+        mov rsi, qword ptr [a + 8]
+        call rat_gcd
+        mov rcx, rax
+        mov rax, qword ptr [a + 8]
+        xor rdx, rdx
+        div rcx
+        mov r8, rax
+        mov rax, qword ptr [b + 8]
+        xor rdx, rdx
+        div rcx
+        mov r9, rax
+
+        # the numerator
+        mov rax, qword ptr [b]
+        imul rax, r8
+        jo register_overflow
+        mov r10, qword ptr [a]
+        imul r10, r9
+        jo register_overflow
+        add rax, r10
+        jo register_overflow
+
+        # the denominator
+        mov rdx, qword ptr [a + 8]
+        imul rdx, r9
+        jo register_overflow
+        jmp rat_store
+
+#
+# [a] = [b] - [a]
+#
+rat_sub:
+        mov rax, qword ptr [a]
+        neg rax
+        jo register_overflow
+        mov qword ptr [a], rax
+        jmp rat_add
+
+#
+# [a] = [b] * [a], after cancelling the common factors of each numerator
+# with the other denominator.
+#
+rat_mul:
+        mov rdi, qword ptr [b]
+        mov rax, rdi
+        neg rax
+        cmovns rdi, rax
+        mov rsi, qword ptr [a + 8]
+        call rat_gcd
+        mov r10, rax
+        mov rdi, qword ptr [a]
+        mov rax, rdi
+        neg rax
+        cmovns rdi, rax
+        mov rsi, qword ptr [b + 8]
+        call rat_gcd
+        mov r11, rax
+
+        # the numerator
+        mov rax, qword ptr [b]
+        cqo
+        idiv r10
+        mov r8, rax
+        mov rax, qword ptr [a]
+        cqo
+        idiv r11
+        imul r8, rax
+        jo register_overflow
+
+        # the denominator
+        mov rax, qword ptr [b + 8]
+        xor rdx, rdx
+        div r11
+        mov r9, rax
+        mov rax, qword ptr [a + 8]
+        xor rdx, rdx
+        div r10
+        imul r9, rax
+        jo register_overflow
+
+        mov rax, r8
+        mov rdx, r9
+        jmp rat_store
+
+#
+# [a] = [b] / [a], which is [b] multiplied by the reciprocal of [a].
+#
+rat_div:
+        mov rax, qword ptr [a]
+        mov rcx, qword ptr [a + 8]
+        test rax, rax
+        jz division_by_zero
+        jns rat_div_positive
+        neg rax
+        jo register_overflow
+        neg rcx
+rat_div_positive:
+        mov qword ptr [a], rcx
+        mov qword ptr [a + 8], rax
+        jmp rat_mul
+
+#
+# [a] = [b] ^ [a], where [a] must be an integer.  This is calculated by
+# repeated squaring.
+#
+rat_pow:
+        cmp qword ptr [a + 8], 1
+        jne domain_error
+        push rbx
+        mov rbx, qword ptr [a]
+        movups xmm0, xmmword ptr [b]
+        movups xmmword ptr [rat_base], xmm0
+
+        # a negative exponent raises the reciprocal of the base.
+        test rbx, rbx
+        jns rat_pow_start
+        neg rbx
+        jo register_overflow
+        mov qword ptr [a], 1
+        mov qword ptr [a + 8], 1
+        movups xmm0, xmmword ptr [a]
+        movups xmmword ptr [b], xmm0
+        movups xmm0, xmmword ptr [rat_base]
+        movups xmmword ptr [a], xmm0
+        call rat_div
+        movups xmm0, xmmword ptr [a]
+        movups xmmword ptr [rat_base], xmm0
+
+rat_pow_start:
+        mov qword ptr [rat_result], 1
+        mov qword ptr [rat_result + 8], 1
+rat_pow_next:
+        test rbx, 1
+        jz rat_pow_square
+        movups xmm0, xmmword ptr [rat_result]
+        movups xmmword ptr [b], xmm0
+        movups xmm0, xmmword ptr [rat_base]
+        movups xmmword ptr [a], xmm0
+        call rat_mul
+        movups xmm0, xmmword ptr [a]
+        movups xmmword ptr [rat_result], xmm0
+rat_pow_square:
+        shr rbx, 1
+        jz rat_pow_done
+        movups xmm0, xmmword ptr [rat_base]
+        movups xmmword ptr [b], xmm0
+        movups xmmword ptr [a], xmm0
+        call rat_mul
+        movups xmm0, xmmword ptr [a]
+        movups xmmword ptr [rat_base], xmm0
+        jmp rat_pow_next
+rat_pow_done:
+        movups xmm0, xmmword ptr [rat_result]
+        movups xmmword ptr [a], xmm0
+        pop rbx
+        ret
+
+#
+# [a] = [b] - [a] * q, where q is the quotient [b] / [a] rounded towards
+# zero.  The result has the sign of [b].
+#
+rat_mod:
+        push rbx
+        xor rbx, rbx
+        jmp rat_remainder
+
+#
+# As rat_mod, except the quotient is rounded down so that the result
+# has the sign of [a].
+#
+rat_floored_mod:
+        push rbx
+        mov rbx, 1
+rat_remainder:
+        movups xmm0, xmmword ptr [b]
+        movups xmmword ptr [rat_base], xmm0
+        movups xmm0, xmmword ptr [a]
+        movups xmmword ptr [rat_result], xmm0
+        call rat_div
+
+        # the integer part of the quotient
+        mov rax, qword ptr [a]
+        cqo
+        idiv qword ptr [a + 8]
+        test rbx, rbx
+        jz rat_remainder_quotient
+        test rdx, rdx
+        jz rat_remainder_quotient
+        cmp qword ptr [a], 0
+        jge rat_remainder_quotient
+        dec rax
+rat_remainder_quotient:
+        mov qword ptr [a], rax
+        mov qword ptr [a + 8], 1
+
+        # subtract the divisor multiplied by the quotient
+        movups xmm0, xmmword ptr [rat_result]
+        movups xmmword ptr [b], xmm0
+        call rat_mul
+        movups xmm0, xmmword ptr [rat_base]
+        movups xmmword ptr [b], xmm0
+        call rat_sub
+        pop rbx
+        ret
+
+#
+# [a] = abs([a])
+#
+rat_abs:
+        mov rax, qword ptr [a]
+        test rax, rax
+        jns rat_abs_done
+        neg rax
+        jo register_overflow
+        mov qword ptr [a], rax
+rat_abs_done:
+        ret
+
+#
+# [a] = [a]!, where [a] must be a whole number which isn't negative.
+#
+rat_factorial:
+        cmp qword ptr [a + 8], 1
+        jne domain_error
+        mov rcx, qword ptr [a]
+        cmp rcx, 0
+        jl domain_error
+        mov rax, 1
+rat_factorial_next:
+        cmp rcx, 1
+        jle rat_factorial_done
+        imul rax, rcx
+        jo register_overflow
+        dec rcx
+        jmp rat_factorial_next
+rat_factorial_done:
+        mov qword ptr [a], rax
+        ret
+
+#
+# Convert the string pointed to by rdi into a rational, which is stored
+# in [a].  The end of the number is stored at the location pointed to by
+# rsi.
+#
+# We accept integers, decimals such as "0.25", and fractions such as
+# "1/3".  If the string isn't a number, or is out of range, the start of
+# the string is stored as its end.
+#
+rat_parse:
+        push r12
+        mov r12, rsi
+        mov qword ptr [r12], rdi
+        mov r8, rdi
+        xor r11, r11            # is the number negative?
+        cmp byte ptr [r8], '-'
+        jne rat_parse_plus
+        inc r11
+        inc r8
+        jmp rat_parse_integer
+rat_parse_plus:
+        cmp byte ptr [r8], '+'
+        jne rat_parse_integer
+        inc r8
+
+rat_parse_integer:
+        xor rax, rax            # the numerator
+        mov r9, 1               # the denominator
+        mov r10, r8
+rat_parse_digit:
+        movzx ecx, byte ptr [r8]
+        sub ecx, '0'
+        cmp ecx, 9
+        ja rat_parse_point
+        imul rax, rax, 10
+        jo rat_parse_failed
+        add rax, rcx
+        jo rat_parse_failed
+        inc r8
+        jmp rat_parse_digit
+
+rat_parse_point:
+        cmp r8, r10
+        je rat_parse_failed
+        cmp byte ptr [r8], '.'
+        jne rat_parse_slash
+        inc r8
+        mov r10, r8
+rat_parse_fraction:
+        movzx ecx, byte ptr [r8]
+        sub ecx, '0'
+        cmp ecx, 9
+        ja rat_parse_fractioned
+        imul rax, rax, 10
+        jo rat_parse_failed
+        add rax, rcx
+        jo rat_parse_failed
+        imul r9, r9, 10
+        jo rat_parse_failed
+        inc r8
+        jmp rat_parse_fraction
+rat_parse_fractioned:
+        cmp r8, r10
+        je rat_parse_failed
+        jmp rat_parse_done
+
+rat_parse_slash:
+        cmp byte ptr [r8], '/'
+        jne rat_parse_done
+        inc r8
+        mov r10, r8
+        xor r9, r9
+rat_parse_denominator:
+        movzx ecx, byte ptr [r8]
+        sub ecx, '0'
+        cmp ecx, 9
+        ja rat_parse_denominated
+        imul r9, r9, 10
+        jo rat_parse_failed
+        add r9, rcx
+        jo rat_parse_failed
+        inc r8
+        jmp rat_parse_denominator
+rat_parse_denominated:
+        cmp r8, r10
+        je rat_parse_failed
+        test r9, r9
+        jz rat_parse_failed
+
+rat_parse_done:
+        test r11, r11
+        jz rat_parse_store
+        neg rax
+rat_parse_store:
+        mov qword ptr [r12], r8
+        mov rdx, r9
+        call rat_store
+rat_parse_failed:
+        pop r12
+        ret
+`
+}
@@ -0,0 +1,341 @@
+// table.go contains the code for tabulating our program, over ranges of
+// values of its runtime inputs, such as "x=0:10:0.5".
+
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/skx/math-compiler/token"
+)
+
+// span is a range of values, which a runtime input takes in turn.
+type span struct {
+	// name is the name of the runtime input, and slot its position.
+	name string
+	slot int
+
+	// from is the first value, which is followed by count-1 more,
+	// each step larger than the last.
+	from  string
+	step  string
+	count int64
+}
+
+// tabulated returns true if our program is run over ranges of values,
+// printing a table of its results.
+func (c *Compiler) tabulated() bool {
+	return len(c.ranges) > 0
+}
+
+// checkRanges parses each of our ranges, which have the form
+// "name=from:to:step" - where the step may be omitted if it is one.
+//
+// The values are either doubles, or 64-bit integers, and the last value
+// is the largest which doesn't go beyond the end of the range.
+func (c *Compiler) checkRanges() error {
+
+	if c.mode != "" && c.mode != "float" && c.mode != "int64" {
+		return fmt.Errorf("ranges are only supported for floating-point numbers, and 64-bit integers")
+	}
+	if c.stream {
+		return fmt.Errorf("inputs can't be given ranges when they're read from STDIN")
+	}
+
+	// Each row of our table holds one result, so the program can't
+	// print values of its own.
+	for _, tok := range c.tokens {
+		switch tok.Type {
+		case token.LABEL, token.PRINT, token.PRINTSTACK:
+			return fmt.Errorf("'%s' can't be used when printing a table", tok.Literal)
+		}
+	}
+
+	c.spans = nil
+	for _, text := range c.ranges {
+		eq := strings.Index(text, "=")
+		if eq < 0 {
+			return fmt.Errorf("the range '%s' must have the form name=from:to:step", text)
+		}
+		s := span{name: text[:eq]}
+		if !c.isInput(s.name) {
+			return fmt.Errorf("'%s' isn't a runtime input, so it can't be given a range", s.name)
+		}
+		s.slot = c.inputSlot(s.name)
+		for _, prev := range c.spans {
+			if prev.slot == s.slot {
+				return fmt.Errorf("the input '%s' was given a range twice", s.name)
+			}
+		}
+
+		bounds := strings.Split(text[eq+1:], ":")
+		if len(bounds) == 2 {
+			bounds = append(bounds, "1")
+		}
+		if len(bounds) != 3 {
+			return fmt.Errorf("the range '%s' must have the form name=from:to:step", text)
+		}
+
+		var err error
+		if c.mode == "int64" {
+			err = s.integers(bounds)
+		} else {
+			err = s.reals(bounds)
+		}
+		if err != nil {
+			return fmt.Errorf("the range '%s' is invalid: %s", text, err.Error())
+		}
+		c.spans = append(c.spans, s)
+
+		// Our inputs are stored in slots, up to the last we use.
+		if s.slot > c.arguments {
+			c.arguments = s.slot
+		}
+	}
+	return nil
+}
+
+// reals sets the values of a span from the given bounds, which are
+// doubles.
+func (s *span) reals(bounds []string) error {
+	n := []float64{}
+	for _, b := range bounds {
+		v, err := strconv.ParseFloat(b, 64)
+		if err != nil || math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("'%s' isn't a number", b)
+		}
+		n = append(n, v)
+	}
+	if n[2] == 0 {
+		return fmt.Errorf("the step can't be zero")
+	}
+
+	// The end of the range is included, despite rounding.
+	steps := math.Floor((n[1]-n[0])/n[2] + 1e-9)
+	if steps < 0 {
+		return fmt.Errorf("the step goes away from the end")
+	}
+	if steps >= 1e9 {
+		return fmt.Errorf("there are too many values")
+	}
+	s.from = strconv.FormatFloat(n[0], 'g', -1, 64)
+	s.step = strconv.FormatFloat(n[2], 'g', -1, 64)
+	s.count = int64(steps) + 1
+	return nil
+}
+
+// integers sets the values of a span from the given bounds, which are
+// 64-bit integers.
+func (s *span) integers(bounds []string) error {
+	n := []int64{}
+	for _, b := range bounds {
+		v, err := strconv.ParseInt(b, 10, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' isn't a 64-bit integer", b)
+		}
+		n = append(n, v)
+	}
+	if n[2] == 0 {
+		return fmt.Errorf("the step can't be zero")
+	}
+	steps := (n[1] - n[0]) / n[2]
+	if steps < 0 {
+		return fmt.Errorf("the step goes away from the end")
+	}
+	if steps >= 1e9 {
+		return fmt.Errorf("there are too many values")
+	}
+	s.from = strconv.FormatInt(n[0], 10)
+	s.step = strconv.FormatInt(n[2], 10)
+	s.count = steps + 1
+	return nil
+}
+
+// ranged returns true if the runtime input in the given slot takes its
+// values from a range, rather than our command-line.
+func (c *Compiler) ranged(slot int) bool {
+	for _, s := range c.spans {
+		if s.slot == slot {
+			return true
+		}
+	}
+	return false
+}
+
+// genTableData generates the data-area entries which hold our ranges,
+// and the strings used to print our table.
+func (c *Compiler) genTableData() string {
+	text := `
+#
+# Tabulation.
+#
+#     range_N: the position of each input in its range, from zero.
+#
+# range_N_from: the first value of each range.
+#
+# range_N_step: the difference between its values.
+#
+#  table_head: the names of our columns.
+#
+#  column_fmt: used to print the value of an input.
+#
+#     row_rsp: the stack-pointer at the start of each row, which is
+#              restored if the row fails.
+#
+#   row_error: printed in place of the result of a row which fails.
+#
+`
+	real := ".double"
+	if c.mode == "int64" {
+		real = ".quad"
+	}
+
+	names := []string{}
+	for i, s := range c.spans {
+		text += fmt.Sprintf("range_%d: .quad 0\nrange_%d_from: %s %s\nrange_%d_step: %s %s\n",
+			i, i, real, s.from, i, real, s.step)
+		names = append(names, s.name)
+	}
+
+	sep := "\\t"
+	if c.csv {
+		sep = ","
+	}
+	names = append(names, "result")
+	text += fmt.Sprintf(" table_head: .asciz \"%s\\n\"\n", strings.Join(names, sep))
+	text += fmt.Sprintf(" column_fmt: .asciz \"%s%s\"\n", c.columnFormat(), sep)
+	text += "    row_rsp: .quad 0\n  row_error: .asciz \"error\\n\"\n"
+	return text
+}
+
+// columnFormat returns the printf-format which is used to print the
+// values of our inputs, which are doubles - or 64-bit integers.
+func (c *Compiler) columnFormat() string {
+	switch {
+	case c.mode == "int64" && c.hex:
+		return "0x%lx"
+	case c.mode == "int64":
+		return "%ld"
+	case c.hex:
+		return "%a"
+	case c.digits > 0:
+		return fmt.Sprintf("%%.%dg", c.digits)
+	}
+	return "%g"
+}
+
+// genTable generates the assembly code which prints the head of our
+// table, and begins each row by setting, and printing, the value of
+// each input which has a range.
+//
+// The label `next_row` is jumped to once the result of the previous
+// row has been printed.
+func (c *Compiler) genTable() string {
+	text := `
+        # [TABLE]
+        # We calculate, and print, one result for each value of our
+        # inputs which have ranges.
+`
+	if !c.bare {
+		text += `        lea rdi,table_head
+        call print_column
+`
+	}
+	text += `next_row:
+        mov qword ptr [depth], 0
+        mov qword ptr [row_rsp], rsp
+`
+
+	for i, s := range c.spans {
+		value := `
+        # #NAME = from + i * step
+        fild qword ptr [range_#N]
+        fmul qword ptr [range_#N_step]
+        fadd qword ptr [range_#N_from]
+        fstp #PTR [inputs + #SLOT]
+        fld #PTR [inputs + #SLOT]
+        fstp qword ptr [int]
+        movsd xmm0, qword ptr [int]
+        lea rdi,column_fmt
+        call print_column
+`
+		if c.mode == "int64" {
+			value = `
+        # #NAME = from + i * step
+        mov rax, qword ptr [range_#N]
+        imul rax, qword ptr [range_#N_step]
+        add rax, qword ptr [range_#N_from]
+        mov qword ptr [inputs + #SLOT], rax
+        mov rsi, rax
+        lea rdi,column_fmt
+        call print_column
+`
+		}
+		value = strings.Replace(value, "#NAME", s.name, -1)
+		value = strings.Replace(value, "#N", strconv.Itoa(i), -1)
+		text += strings.Replace(value, "#SLOT", strconv.Itoa((s.slot-1)*c.slotSize()), -1)
+	}
+	return text
+}
+
+// genNextRow generates the assembly code which moves on to the next
+// row of our table, once a result has been printed.
+//
+// The last range changes fastest, and once it is exhausted it starts
+// again - as the range before it moves on.
+func (c *Compiler) genNextRow() string {
+	text := `
+        # [NEXT ROW]
+`
+	for i := len(c.spans) - 1; i >= 0; i-- {
+		text += fmt.Sprintf(`        inc qword ptr [range_%d]
+        mov rax, %d
+        cmp qword ptr [range_%d], rax
+        jb next_row
+        mov qword ptr [range_%d], 0
+`, i, c.spans[i].count, i, i)
+	}
+	return text
+}
+
+// genTableHelpers generates the subroutine which is used to print the
+// columns of our table, and the code which marks a row as having failed.
+//
+// A row fails if calculating its result hits an error, which doesn't
+// stop us from going on to the next - but the error is our exit-code.
+func (c *Compiler) genTableHelpers() string {
+	return `
+#
+# This is hit when a row of our table fails, with the exit-code in rdx.
+#
+# The stack, and the FPU, are reset as they were when the row began,
+# and "error" is printed in place of its result.
+#
+row_failed:
+        mov qword ptr [status], rdx
+        mov rsp, qword ptr [row_rsp]
+        fninit
+        fldcw word ptr [fpu_cw]
+        lea rdi,row_error
+        xor rax, rax
+        call printf
+        jmp print_done
+
+#
+# Print the format pointed to by rdi, with the double in xmm0 or the
+# integer in rsi.
+#
+print_column:
+        push rbp
+        mov rbp, rsp
+        and rsp, -16
+        mov rax, 1
+        call printf
+        mov rsp, rbp
+        pop rbp
+        ret
+`
+}
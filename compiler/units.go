@@ -0,0 +1,220 @@
+// units.go contains the code which checks that a program which measures
+// its values in units, such as "3 km", is dimensionally sound.
+
+package compiler
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/skx/math-compiler/token"
+	"github.com/skx/math-compiler/units"
+)
+
+// checkUnits ensures that the program is dimensionally sound, such that
+// values are only added to, or subtracted from, values of the same
+// dimension, and that every value we output may be converted to our
+// output unit.
+//
+// The dimension of each value is tracked through the program at compile
+// time, so this costs nothing at runtime.  If the program would run out
+// of values upon the stack we stop checking, and the generated program
+// will report that instead.
+func (c *Compiler) checkUnits() error {
+
+	//
+	// Programs without units have nothing to check.
+	//
+	used := c.unit != ""
+	for _, tok := range c.tokens {
+		if tok.Type == token.UNIT {
+			used = true
+		}
+	}
+	if !used {
+		return nil
+	}
+
+	var output units.Dimension
+	if c.unit != "" {
+		u, err := units.Parse(c.unit)
+		if err != nil {
+			return err
+		}
+		output = u.Dimension
+	}
+
+	//
+	// Every value we print must be measured in our output unit, if
+	// we have one.
+	//
+	printed := func(d units.Dimension) error {
+		if c.unit != "" && d != output {
+			return fmt.Errorf("a value measured in %s can't be output in %s", d, c.unit)
+		}
+		return nil
+	}
+
+	none := units.Dimension{}
+	stack := []units.Dimension{}
+	for i, tok := range c.tokens {
+
+		switch tok.Type {
+
+		case token.NUMBER, token.IDENT, token.RAND, token.RANDN:
+			stack = append(stack, none)
+
+		case token.UNIT:
+			if len(stack) < 1 {
+				return nil
+			}
+			u, _ := units.Parse(tok.Literal)
+			stack[len(stack)-1] = stack[len(stack)-1].Mul(u.Dimension)
+
+		case token.PLUS, token.MINUS, token.MOD, token.FLOORMOD:
+			if len(stack) < 2 {
+				return nil
+			}
+			a, b := stack[len(stack)-1], stack[len(stack)-2]
+			if a != b {
+				return fmt.Errorf("'%s' was given values measured in %s, and %s", tok.Literal, b, a)
+			}
+			stack = stack[:len(stack)-1]
+
+		case token.ASTERISK, token.SLASH:
+			if len(stack) < 2 {
+				return nil
+			}
+			a, b := stack[len(stack)-1], stack[len(stack)-2]
+			stack = stack[:len(stack)-1]
+			if tok.Type == token.ASTERISK {
+				stack[len(stack)-1] = b.Mul(a)
+			} else {
+				stack[len(stack)-1] = b.Div(a)
+			}
+
+		case token.POWER:
+			if len(stack) < 2 {
+				return nil
+			}
+			a, b := stack[len(stack)-1], stack[len(stack)-2]
+			if a != none {
+				return fmt.Errorf("'^' was given an exponent measured in %s", a)
+			}
+			stack = stack[:len(stack)-1]
+			if b == none {
+				break
+			}
+
+			// The dimension of the result depends upon the value
+			// of the exponent, so it must be a constant.
+			if c.tokens[i-1].Type != token.NUMBER {
+				return fmt.Errorf("a value measured in %s may only be raised to a constant power", b)
+			}
+			d, err := power(b, c.tokens[i-1].Literal)
+			if err != nil {
+				return err
+			}
+			stack[len(stack)-1] = d
+
+		case token.SQRT:
+			if len(stack) < 1 {
+				return nil
+			}
+			d, err := power(stack[len(stack)-1], "0.5")
+			if err != nil {
+				return fmt.Errorf("'sqrt' was given a value measured in %s, whose root isn't a unit", stack[len(stack)-1])
+			}
+			stack[len(stack)-1] = d
+
+		case token.COS, token.EXP, token.FACTORIAL, token.LN, token.SIN, token.TAN:
+			if len(stack) < 1 {
+				return nil
+			}
+			if stack[len(stack)-1] != none {
+				return fmt.Errorf("'%s' was given a value measured in %s, rather than a number", tok.Literal, stack[len(stack)-1])
+			}
+
+		case token.RANDINT:
+			if len(stack) < 2 {
+				return nil
+			}
+			if stack[len(stack)-1] != none || stack[len(stack)-2] != none {
+				return fmt.Errorf("'%s' was given a value measured in units, rather than a number", tok.Literal)
+			}
+			stack = stack[:len(stack)-1]
+
+		case token.DUP:
+			if len(stack) < 1 {
+				return nil
+			}
+			stack = append(stack, stack[len(stack)-1])
+
+		case token.SWAP:
+			if len(stack) < 2 {
+				return nil
+			}
+			n := len(stack)
+			stack[n-1], stack[n-2] = stack[n-2], stack[n-1]
+
+		case token.PRINT, token.LABEL:
+			if len(stack) < 1 {
+				return nil
+			}
+			err := printed(stack[len(stack)-1])
+			if err != nil {
+				return err
+			}
+			stack = stack[:len(stack)-1]
+
+		case token.PRINTSTACK:
+			for _, d := range stack {
+				err := printed(d)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	//
+	// The values which remain are printed when we terminate.
+	//
+	if c.printAll || len(stack) == 1 {
+		for _, d := range stack {
+			err := printed(d)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// power returns the dimension of a value with the given dimension raised
+// to the given power, which must leave each SI base unit raised to a whole
+// power.  The square-root of an area is a length, for example.
+func power(d units.Dimension, exponent string) (units.Dimension, error) {
+	r, ok := new(big.Rat).SetString(exponent)
+	if !ok {
+		return d, fmt.Errorf("the exponent '%s' isn't a number", exponent)
+	}
+
+	result := units.Dimension{}
+	for i, n := range d {
+		p := new(big.Rat).Mul(r, big.NewRat(int64(n), 1))
+		if !p.IsInt() || !p.Num().IsInt64() {
+			return d, fmt.Errorf("a value measured in %s can't be raised to the power %s", d, exponent)
+		}
+		result[i] = int(p.Num().Int64())
+	}
+	return result, nil
+}
+
+// unitScale returns the size of the given unit, in SI base units, as a
+// constant which our program may use.
+func unitScale(expr string) string {
+	u, _ := units.Parse(expr)
+	return strconv.FormatFloat(u.Scale, 'g', -1, 64)
+}
@@ -0,0 +1,1083 @@
+// vector.go contains the code for vectors, such as "[1 2 3]", which are
+// calculated with the packed instructions of SSE.
+//
+// Each vector is a single entry upon our stack, which points to its
+// elements.  The length of every vector is known when we compile, so
+// each instruction which calculates one has an area of its own to hold
+// the result.
+
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/skx/math-compiler/instructions"
+	"github.com/skx/math-compiler/token"
+)
+
+// shape holds the lengths of the operands of an instruction, and of its
+// result, where zero is a number rather than a vector.
+type shape struct {
+	in  []int
+	out int
+}
+
+// vectorNames holds the words for the instructions which we report,
+// when they're given vectors - or numbers - they don't accept.
+var vectorNames = map[instructions.InstructionType]string{
+	instructions.Plus:           "+",
+	instructions.Minus:          "-",
+	instructions.Multiply:       "*",
+	instructions.Divide:         "/",
+	instructions.Power:          "^",
+	instructions.Modulus:        "%",
+	instructions.FlooredModulus: "mod",
+	instructions.Factorial:      "!",
+	instructions.RandInt:        "randint",
+	instructions.Integrate:      "integrate",
+	instructions.Solve:          "solve",
+	instructions.Cross:          "cross",
+	instructions.Dot:            "dot",
+	instructions.Length:         "len",
+	instructions.Norm:           "norm",
+	instructions.Sum:            "sum",
+}
+
+// findVectors replaces each pair of brackets which only holds numbers,
+// such as "[1 2 3]", with a single vector.
+//
+// Brackets are also used for quotations, so those which `integrate`, or
+// `solve`, need are left alone.  Each uses the most recent quotation
+// which isn't merely numbers, if there is one - and any numbers after it
+// are vectors.
+func (c *Compiler) findVectors() error {
+
+	type group struct {
+		start, end int
+		numeric    bool
+		quoted     bool
+	}
+	groups := []group{}
+	vectors := map[int]bool{}
+
+	// open holds the groups we're within, and pending the groups
+	// within each which are yet to be used by integrate, or solve.
+	open := []int{}
+	pending := [][]int{nil}
+
+	for i, tok := range c.tokens {
+		n := len(open)
+
+		switch tok.Type {
+		case token.LBRACKET:
+			if n > 0 {
+				groups[open[n-1]].numeric = false
+			}
+			groups = append(groups, group{start: i, numeric: true, quoted: n > 0})
+			open = append(open, len(groups)-1)
+			pending = append(pending, nil)
+
+		case token.RBRACKET:
+			// Unmatched brackets are reported by checkQuotations.
+			if n == 0 {
+				return nil
+			}
+			g := open[n-1]
+			groups[g].end = i
+			if groups[g].start+1 == i {
+				groups[g].numeric = false
+			}
+			for _, id := range pending[n] {
+				vectors[id] = groups[id].numeric
+			}
+			open = open[:n-1]
+			pending = pending[:n]
+			pending[n-1] = append(pending[n-1], g)
+
+		case token.INTEGRATE, token.SOLVE:
+			p := pending[n]
+			use := len(p) - 1
+			for k := len(p) - 1; k >= 0; k-- {
+				if !groups[p[k]].numeric {
+					use = k
+					break
+				}
+			}
+			if use < 0 {
+				continue
+			}
+			for _, id := range p[use+1:] {
+				vectors[id] = true
+			}
+			pending[n] = p[:use]
+
+		case token.NUMBER:
+
+		default:
+			if n > 0 {
+				groups[open[n-1]].numeric = false
+			}
+		}
+	}
+	if len(open) > 0 {
+		return nil
+	}
+	for _, id := range pending[0] {
+		vectors[id] = groups[id].numeric
+	}
+
+	//
+	// Replace the brackets of each vector, and its numbers, with a
+	// single token.
+	//
+	// Brackets which aren't used by integrate, or solve, and hold a list
+	// of values which aren't all numbers, were meant to be vectors.
+	//
+	starts := map[int]int{}
+	for id, vector := range vectors {
+		if !vector && c.listed(groups[id].start, groups[id].end) {
+			return fmt.Errorf("the elements of a vector must be numbers, such as [1 2 3]")
+		}
+		if !vector {
+			continue
+		}
+		if groups[id].quoted {
+			return fmt.Errorf("vectors can't be used within a quotation")
+		}
+		starts[groups[id].start] = groups[id].end
+	}
+
+	tokens := []token.Token{}
+	for i := 0; i < len(c.tokens); i++ {
+		end, ok := starts[i]
+		if !ok {
+			tokens = append(tokens, c.tokens[i])
+			continue
+		}
+		elements := []string{}
+		for _, tok := range c.tokens[i+1 : end] {
+			elements = append(elements, tok.Literal)
+		}
+		tokens = append(tokens, token.Token{Type: token.VECTOR, Literal: strings.Join(elements, " ")})
+		i = end
+	}
+	c.tokens = tokens
+
+	if c.vectors() {
+		return c.checkVectorOptions()
+	}
+	return nil
+}
+
+// listed returns true if the tokens between the brackets at the given
+// positions are numbers, and runtime inputs, without any operation upon
+// them - or the variable of a quotation.
+func (c *Compiler) listed(start int, end int) bool {
+	for _, tok := range c.tokens[start+1 : end] {
+		if tok.Type != token.NUMBER && !(tok.Type == token.IDENT && c.isInput(tok.Literal)) {
+			return false
+		}
+	}
+	return end-start > 1
+}
+
+// vectors returns true if our program uses vectors, or the operations
+// upon them.
+func (c *Compiler) vectors() bool {
+	for _, tok := range c.tokens {
+		switch tok.Type {
+		case token.CROSS, token.DOT, token.LEN, token.NORM, token.SUM, token.VECTOR:
+			return true
+		}
+	}
+	return false
+}
+
+// checkVectorOptions ensures that our options may be used with vectors,
+// which hold doubles.
+func (c *Compiler) checkVectorOptions() error {
+	if c.precision != "" && c.precision != "double" {
+		return fmt.Errorf("vectors are always calculated at double precision")
+	}
+	if c.gradient() || c.derive != "" {
+		return fmt.Errorf("vectors can't be differentiated")
+	}
+	if c.fma {
+		return fmt.Errorf("vectors can't use fused multiply-add")
+	}
+	if c.fpErrors == "saturate" {
+		return fmt.Errorf("vectors can't be saturated")
+	}
+	if c.unit != "" {
+		return fmt.Errorf("vectors can't be measured in units")
+	}
+	if c.tabulated() {
+		return fmt.Errorf("vectors can't be printed in a table")
+	}
+	for _, tok := range c.tokens {
+		if tok.Type == token.UNIT {
+			return fmt.Errorf("vectors can't be measured in units")
+		}
+	}
+	return nil
+}
+
+// checkShapes ensures that the operands of each instruction are vectors,
+// or numbers, as it requires - and that vectors which are combined have
+// the same length.  The shape of each instruction is recorded, for when
+// we generate its code, along with that of our result.
+//
+// As with units, if the program would run out of values upon the stack
+// we stop checking, and the generated program will report that instead.
+func (c *Compiler) checkShapes() error {
+	c.shapes = map[int]shape{}
+
+	stack, err := c.shapesOf(c.instructions, c.shapes)
+	if err != nil {
+		return err
+	}
+	if stack == nil {
+		return nil
+	}
+
+	for _, n := range stack {
+		if n > 0 && c.printAll {
+			return fmt.Errorf("vectors can't be printed with every value upon the stack")
+		}
+	}
+	if len(stack) == 1 {
+		c.resultLength = stack[0]
+	}
+	if c.resultLength > 0 && c.exitResult {
+		return fmt.Errorf("the exit-code can't be set from a vector")
+	}
+
+	//
+	// Quotations only calculate numbers.
+	//
+	for _, quotation := range c.quotations {
+		_, err := c.shapesOf(quotation, map[int]shape{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shapesOf records the shape of each of the given instructions, which
+// begin with an empty stack, and returns the lengths of the values which
+// remain upon it - or nil if it runs out of values.
+func (c *Compiler) shapesOf(program []instructions.Instruction, shapes map[int]shape) ([]int, error) {
+	stack := []int{}
+
+	for i, opr := range program {
+		needs, leaves := arity(opr.Type)
+		if len(stack) < needs {
+			return nil, nil
+		}
+		in := append([]int{}, stack[len(stack)-needs:]...)
+		stack = stack[:len(stack)-needs]
+		name := vectorNames[opr.Type]
+
+		out := 0
+		switch opr.Type {
+		case instructions.Vector:
+			out = len(strings.Fields(opr.Value))
+
+		case instructions.Plus, instructions.Minus, instructions.Multiply, instructions.Divide, instructions.Power, instructions.Modulus:
+			if in[0] > 0 && in[1] > 0 && in[0] != in[1] {
+				return nil, fmt.Errorf("'%s' was given vectors of lengths %d, and %d", name, in[0], in[1])
+			}
+			out = in[0]
+			if in[1] > out {
+				out = in[1]
+			}
+
+		case instructions.Abs, instructions.Cos, instructions.Exp, instructions.Ln, instructions.Sin, instructions.Sqrt, instructions.Tan:
+			out = in[0]
+
+		case instructions.Dot:
+			if in[0] == 0 || in[0] != in[1] {
+				return nil, fmt.Errorf("'dot' requires two vectors, of the same length")
+			}
+
+		case instructions.Cross:
+			if in[0] != 3 || in[1] != 3 {
+				return nil, fmt.Errorf("'cross' requires two vectors, of three elements")
+			}
+			out = 3
+
+		case instructions.Length, instructions.Norm, instructions.Sum:
+			if in[0] == 0 {
+				return nil, fmt.Errorf("'%s' requires a vector", name)
+			}
+
+		case instructions.Dup:
+			stack = append(stack, in[0], in[0])
+			continue
+
+		case instructions.Swap:
+			stack = append(stack, in[1], in[0])
+			continue
+
+		case instructions.Print, instructions.Label:
+			shapes[i] = shape{in: in}
+			continue
+
+		case instructions.PrintStack:
+			shapes[i] = shape{in: append([]int{}, stack...)}
+			continue
+
+		default:
+			for _, n := range in {
+				if n > 0 {
+					return nil, fmt.Errorf("'%s' isn't supported for vectors", name)
+				}
+			}
+		}
+
+		shapes[i] = shape{in: in, out: out}
+		for k := 0; k < leaves; k++ {
+			stack = append(stack, out)
+		}
+	}
+	return stack, nil
+}
+
+// arity returns the number of values the given instruction takes from the
+// stack, and the number it leaves in their place.
+func arity(t instructions.InstructionType) (int, int) {
+	switch t {
+	case instructions.Push, instructions.Input, instructions.Rand, instructions.RandNormal, instructions.Variable, instructions.Vector:
+		return 0, 1
+	case instructions.Plus, instructions.Minus, instructions.Multiply, instructions.Divide, instructions.Power, instructions.Modulus, instructions.FlooredModulus, instructions.RandInt, instructions.Integrate, instructions.Solve, instructions.Dot, instructions.Cross:
+		return 2, 1
+	case instructions.FusedMultiplyAdd, instructions.FusedMultiplySubtract:
+		return 3, 1
+	case instructions.Dup:
+		return 1, 2
+	case instructions.Swap:
+		return 2, 2
+	case instructions.Print, instructions.Label:
+		return 1, 0
+	case instructions.PrintStack:
+		return 0, 0
+	}
+	return 1, 1
+}
+
+// vectored returns true if the given instruction, of our program, is
+// calculated with vectors.
+//
+// Duplicating, and swapping, vectors is no different from duplicating
+// numbers, since each is a single entry upon our stack.
+func (c *Compiler) vectored(opr instructions.Instruction, i int) bool {
+	switch opr.Type {
+	case instructions.Vector, instructions.Cross, instructions.Dot, instructions.Length, instructions.Norm, instructions.Sum:
+		return true
+	case instructions.Dup, instructions.Swap:
+		return false
+	}
+	s := c.shapes[i]
+	for _, n := range s.in {
+		if n > 0 {
+			return true
+		}
+	}
+	return s.out > 0
+}
+
+// genVector generates the assembly code for an instruction which is
+// calculated with vectors.
+func (c *Compiler) genVector(opr instructions.Instruction, i int) string {
+	s, ok := c.shapes[i]
+
+	//
+	// An operation upon vectors without a shape is only reached once
+	// we've run out of values upon the stack.
+	//
+	if !ok && opr.Type != instructions.Vector {
+		return fmt.Sprintf(`
+        # [%s]
+        # there are too few values upon the stack
+        jmp stack_error
+`, strings.ToUpper(vectorNames[opr.Type]))
+	}
+
+	text := ""
+	switch opr.Type {
+	case instructions.Vector:
+		text = c.genVectorPush(opr.Value)
+	case instructions.Plus:
+		text = c.genVectorArithmetic("PLUS", "add", i, s)
+	case instructions.Minus:
+		text = c.genVectorArithmetic("MINUS", "sub", i, s)
+	case instructions.Multiply:
+		text = c.genVectorArithmetic("MULTIPLY", "mul", i, s)
+	case instructions.Divide:
+		text = c.genVectorArithmetic("DIVIDE", "div", i, s)
+	case instructions.Power:
+		text = c.genVectorMap("POWER", "pow", i, s)
+	case instructions.Modulus:
+		text = c.genVectorMap("MODULUS", "fmod", i, s)
+	case instructions.Abs:
+		text = c.genVectorUnary("ABS", "andpd xmm0, xmm7", "andpd xmm0, xmm7", i, s.out)
+	case instructions.Sqrt:
+		text = c.genVectorUnary("SQRT", "sqrtpd xmm0, xmm0", "sqrtsd xmm0, xmm0", i, s.out)
+	case instructions.Cos:
+		text = c.genVectorMap("COS", "cos", i, s)
+	case instructions.Exp:
+		text = c.genVectorMap("EXP", "exp", i, s)
+	case instructions.Ln:
+		text = c.genVectorMap("LN", "log", i, s)
+	case instructions.Sin:
+		text = c.genVectorMap("SIN", "sin", i, s)
+	case instructions.Tan:
+		text = c.genVectorMap("TAN", "tan", i, s)
+	case instructions.Dot:
+		text = c.genVectorReduce("DOT", i, s.in[0])
+	case instructions.Norm:
+		text = c.genVectorReduce("NORM", i, s.in[0])
+	case instructions.Sum:
+		text = c.genVectorReduce("SUM", i, s.in[0])
+	case instructions.Length:
+		text = c.genVectorLength(s.in[0])
+	case instructions.Cross:
+		text = c.genCross(i)
+	case instructions.Print:
+		text = c.genVectorPrint("PRINT", "result", s.in[0])
+	case instructions.Label:
+		text = c.genVectorPrint("LABEL", fmt.Sprintf("label_%d", c.labelID(opr.Value)), s.in[0])
+	case instructions.PrintStack:
+		text = c.genVectorPrintStack()
+	}
+	return c.vectorChecks(strings.Replace(text, "#ID", strconv.Itoa(i), -1))
+}
+
+// vectorChecks applies our floating-point error policy to the given
+// assembly code, which calculates with SSE, by replacing the "#CLEAR"
+// and "#CHECK" lines.
+//
+// Unlike fpChecks only the MXCSR register is examined.
+func (c *Compiler) vectorChecks(text string) string {
+	clear := ""
+	check := ""
+	if c.trapping() {
+		clear = `        # discard any pending floating-point exceptions
+        stmxcsr dword ptr [mxcsr]
+        and dword ptr [mxcsr], -64
+        ldmxcsr dword ptr [mxcsr]
+`
+		check = `        # report invalid operations, zero-divides, and overflows
+        stmxcsr dword ptr [mxcsr]
+        mov al, byte ptr [mxcsr]
+        test al, 0x0D
+        jnz fp_exception
+`
+	}
+	text = strings.Replace(text, "        #CLEAR\n", clear, -1)
+	text = strings.Replace(text, "        #CHECK\n", check, -1)
+	return text
+}
+
+// genVectorPush generates assembly code to push a vector, whose elements
+// are in our data-area, onto the stack.
+func (c *Compiler) genVectorPush(value string) string {
+	return strings.Replace(`
+        # [VECTOR]
+        # Push a pointer to the vector [#VALUE] onto the stack
+        # Increase the value stored at [depth] to note we've a new stack-entry
+        lea rax, vector_#ID
+        push rax
+        inc qword ptr [depth]
+`, "#VALUE", value, -1)
+}
+
+// operand returns the assembly code which loads the elements of an
+// operand, at the given register, into an SSE register - or which
+// copies the number it was splatted into, if it isn't a vector.
+func operand(length int, ptr string, reg string, splat string, packed bool) string {
+	if length == 0 {
+		return fmt.Sprintf("        movapd %s, %s\n", reg, splat)
+	}
+	if packed {
+		return fmt.Sprintf("        movupd %s, xmmword ptr [%s]\n", reg, ptr)
+	}
+	return fmt.Sprintf("        movsd %s, qword ptr [%s]\n", reg, ptr)
+}
+
+// genVectorArithmetic generates assembly code to pop two values from the
+// stack, at least one of which is a vector, and push the vector which
+// results from the given operation upon their elements.  A number is
+// combined with every element of a vector.
+//
+// The elements are calculated in pairs, with packed instructions, and
+// any which remains is calculated alone.
+func (c *Compiler) genVectorArithmetic(name string, op string, i int, s shape) string {
+	left, right, n := s.in[0], s.in[1], s.out
+
+	text := `
+        # [VECTOR #NAME]
+        # ensure there are at least two arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 2
+        jb stack_error
+
+        # pop our operands, either of which may be a number that is
+        # combined with every element of the other
+        pop rdx
+        pop rsi
+`
+	if left == 0 {
+		text += `        movq xmm2, rsi
+        unpcklpd xmm2, xmm2
+`
+	}
+	if right == 0 {
+		text += `        movq xmm3, rdx
+        unpcklpd xmm3, xmm3
+`
+	}
+	text += `        lea rdi, vector_#ID
+        #CLEAR
+`
+
+	if n/2 > 0 {
+		text += fmt.Sprintf(`        mov rcx, %d
+vector_#ID_pairs:
+`, n/2)
+		text += operand(left, "rsi", "xmm0", "xmm2", true)
+		text += operand(right, "rdx", "xmm1", "xmm3", true)
+		text += `        #OPpd xmm0, xmm1
+        movupd xmmword ptr [rdi], xmm0
+`
+		if left > 0 {
+			text += "        add rsi, 16\n"
+		}
+		if right > 0 {
+			text += "        add rdx, 16\n"
+		}
+		text += `        add rdi, 16
+        dec rcx
+        jnz vector_#ID_pairs
+`
+	}
+	if n%2 == 1 {
+		text += operand(left, "rsi", "xmm0", "xmm2", false)
+		text += operand(right, "rdx", "xmm1", "xmm3", false)
+		text += `        #OPsd xmm0, xmm1
+        movsd qword ptr [rdi], xmm0
+`
+	}
+
+	text += `        #CHECK
+
+        # push the result onto the stack
+        lea rax, vector_#ID
+        push rax
+
+        # we took two values from the stack, and pushed one.
+        dec qword ptr [depth]
+`
+	text = strings.Replace(text, "#NAME", name, -1)
+	return strings.Replace(text, "#OP", op, -1)
+}
+
+// genVectorUnary generates assembly code to pop a vector from the stack,
+// and push the vector which results from the given packed, and scalar,
+// instructions upon its elements.  The instructions operate upon xmm0,
+// and xmm7 holds the mask which clears the sign of a pair of doubles.
+func (c *Compiler) genVectorUnary(name string, packed string, scalar string, i int, n int) string {
+	text := `
+        # [VECTOR #NAME]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # pop the vector
+        pop rsi
+        lea rdi, vector_#ID
+        movupd xmm7, xmmword ptr [vector_abs]
+        #CLEAR
+`
+	if n/2 > 0 {
+		text += fmt.Sprintf(`        mov rcx, %d
+vector_#ID_pairs:
+        movupd xmm0, xmmword ptr [rsi]
+        %s
+        movupd xmmword ptr [rdi], xmm0
+        add rsi, 16
+        add rdi, 16
+        dec rcx
+        jnz vector_#ID_pairs
+`, n/2, packed)
+	}
+	if n%2 == 1 {
+		text += fmt.Sprintf(`        movsd xmm0, qword ptr [rsi]
+        %s
+        movsd qword ptr [rdi], xmm0
+`, scalar)
+	}
+	text += `        #CHECK
+
+        # push the result onto the stack
+        lea rax, vector_#ID
+        push rax
+
+        # stack size didn't change; popped one, pushed one.
+`
+	return strings.Replace(text, "#NAME", name, -1)
+}
+
+// genVectorMap generates assembly code to pop one value, or two, from
+// the stack, and push the vector which results from calling the named
+// C library function upon their elements, via vector_map.
+//
+// A number is passed along with every element of a vector, by reading it
+// from [a], or [b], without advancing.
+func (c *Compiler) genVectorMap(name string, function string, i int, s shape) string {
+	text := `
+        # [VECTOR #NAME]
+        # ensure there are enough arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, #NEEDS
+        jb stack_error
+
+`
+	if len(s.in) == 1 {
+		text += `        # pop the vector
+        pop rsi
+        mov rdx, rsi
+        mov r8, 8
+        mov r9, 8
+`
+	} else {
+		text += "        # pop our operands, either of which may be a number\n        pop rdx\n"
+		if s.in[1] == 0 {
+			text += "        mov qword ptr [a], rdx\n        lea rdx, a\n        mov r9, 0\n"
+		} else {
+			text += "        mov r9, 8\n"
+		}
+		text += "        pop rsi\n"
+		if s.in[0] == 0 {
+			text += "        mov qword ptr [b], rsi\n        lea rsi, b\n        mov r8, 0\n"
+		} else {
+			text += "        mov r8, 8\n"
+		}
+	}
+
+	text += `        lea rdi, vector_#ID
+        mov rcx, #COUNT
+        lea rax, #FUNCTION
+        #CLEAR
+        call vector_map
+        #CHECK
+
+        # push the result onto the stack
+        lea rax, vector_#ID
+        push rax
+`
+	if len(s.in) == 2 {
+		text += `
+        # we took two values from the stack, and pushed one.
+        dec qword ptr [depth]
+`
+	}
+	text = strings.Replace(text, "#NAME", name, -1)
+	text = strings.Replace(text, "#NEEDS", strconv.Itoa(len(s.in)), -1)
+	text = strings.Replace(text, "#COUNT", strconv.Itoa(s.out), -1)
+	return strings.Replace(text, "#FUNCTION", function, -1)
+}
+
+// genVectorReduce generates assembly code to pop a vector from the stack,
+// and push the sum of its elements - or pop two, and push their dot
+// product.  The norm of a vector is the square-root of its dot product
+// with itself.
+func (c *Compiler) genVectorReduce(name string, i int, n int) string {
+	text := `
+        # [#NAME]
+        # ensure there are enough arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, #NEEDS
+        jb stack_error
+
+        # pop the vector, or vectors
+`
+	switch name {
+	case "DOT":
+		text += "        pop rdx\n        pop rsi\n"
+	case "NORM":
+		text += "        pop rsi\n        mov rdx, rsi\n"
+	default:
+		text += "        pop rsi\n"
+	}
+	text += `        #CLEAR
+        xorpd xmm0, xmm0
+`
+
+	if n/2 > 0 {
+		text += fmt.Sprintf(`        mov rcx, %d
+vector_#ID_pairs:
+        movupd xmm1, xmmword ptr [rsi]
+`, n/2)
+		if name != "SUM" {
+			text += "        movupd xmm2, xmmword ptr [rdx]\n        mulpd xmm1, xmm2\n        add rdx, 16\n"
+		}
+		text += `        addpd xmm0, xmm1
+        add rsi, 16
+        dec rcx
+        jnz vector_#ID_pairs
+
+        # add the sums of each half of the pairs
+        movapd xmm1, xmm0
+        unpckhpd xmm1, xmm1
+        addsd xmm0, xmm1
+`
+	}
+	if n%2 == 1 {
+		text += "        movsd xmm1, qword ptr [rsi]\n"
+		if name != "SUM" {
+			text += "        mulsd xmm1, qword ptr [rdx]\n"
+		}
+		text += "        addsd xmm0, xmm1\n"
+	}
+	if name == "NORM" {
+		text += "        sqrtsd xmm0, xmm0\n"
+	}
+
+	text += `        movsd qword ptr [a], xmm0
+        #CHECK
+
+        # push the result onto the stack
+        #PUSH a
+`
+	if name == "DOT" {
+		text += `
+        # we took two values from the stack, and pushed one.
+        dec qword ptr [depth]
+`
+	}
+
+	needs := "1"
+	if name == "DOT" {
+		needs = "2"
+	}
+	text = strings.Replace(text, "#NEEDS", needs, -1)
+	return strings.Replace(text, "#NAME", name, -1)
+}
+
+// genVectorLength generates assembly code to pop a vector from the stack,
+// and push the number of its elements - which we already know.
+func (c *Compiler) genVectorLength(n int) string {
+	return fmt.Sprintf(`
+        # [LEN]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # the length of the vector is known when it is compiled
+        pop rax
+        mov rax, %d
+        cvtsi2sd xmm0, rax
+        movsd qword ptr [a], xmm0
+        #PUSH a
+
+        # stack size didn't change; popped one, pushed one.
+`, n)
+}
+
+// genCross generates assembly code to pop two vectors, of three elements,
+// from the stack and push their cross product.
+func (c *Compiler) genCross(i int) string {
+	text := `
+        # [CROSS]
+        # ensure there are at least two arguments on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 2
+        jb stack_error
+
+        # pop the vectors, u and v
+        pop rdx
+        pop rsi
+        lea rdi, vector_#ID
+        #CLEAR
+`
+	// Each element is the difference of two products of the others.
+	for k, pair := range [][2]int{{1, 2}, {2, 0}, {0, 1}} {
+		a, b := pair[0]*8, pair[1]*8
+		text += fmt.Sprintf(`
+        # u%d v%d - u%d v%d
+        movsd xmm0, qword ptr [rsi + %d]
+        mulsd xmm0, qword ptr [rdx + %d]
+        movsd xmm1, qword ptr [rsi + %d]
+        mulsd xmm1, qword ptr [rdx + %d]
+        subsd xmm0, xmm1
+        movsd qword ptr [rdi + %d], xmm0
+`, pair[0], pair[1], pair[1], pair[0], a, b, b, a, k*8)
+	}
+
+	text += `        #CHECK
+
+        # push the result onto the stack
+        lea rax, vector_#ID
+        push rax
+
+        # we took two values from the stack, and pushed one.
+        dec qword ptr [depth]
+`
+	return text
+}
+
+// genVectorPrint generates assembly code to pop a vector from the stack,
+// and print it with the given label.
+func (c *Compiler) genVectorPrint(name string, label string, n int) string {
+	return fmt.Sprintf(`
+        # [%s]
+        # ensure there is at least one argument on the stack
+        mov rax, qword ptr [depth]
+        cmp rax, 1
+        jb stack_error
+
+        # pop the vector, and print it
+        pop rax
+        mov rcx, %d
+        lea rsi,%s
+        call print_vector
+
+        # we took one value from the stack.
+        dec qword ptr [depth]
+`, name, n, label)
+}
+
+// genVectorPrintStack generates assembly code to print every value upon
+// the stack, when some of them are vectors.
+func (c *Compiler) genVectorPrintStack() string {
+	return `
+        # [PRINT STACK]
+        # the stack holds vectors, so we give the length of each entry
+        lea rsi,stack_shape_#ID
+        call print_vector_stack
+        # stack size didn't change.
+`
+}
+
+// genVectorData generates the data-area entries which hold the elements
+// of our vectors, and the strings used to print them.
+//
+// Each instruction which calculates a vector has an area of its own to
+// hold it, in which the elements of a vector we push already are.
+func (c *Compiler) genVectorData() string {
+	text := `
+#
+# Vectors.
+#
+#    vector_N: the elements of the vector calculated by instruction N.
+#
+# stack_shape_N: the length of each entry upon the stack, from the top,
+#                when instruction N prints it.
+#
+#  vector_abs: the mask which clears the sign of a pair of doubles.
+#
+# The strings are used to print vectors.
+#
+ vector_abs: .quad 0x7FFFFFFFFFFFFFFF, 0x7FFFFFFFFFFFFFFF
+vector_open: .asciz "["
+ vector_sep: .asciz ", "
+vector_close: .asciz "]"
+        .balign 16
+`
+	for i, opr := range c.instructions {
+		if opr.Type == instructions.PrintStack && c.vectored(opr, i) {
+			lengths := []string{}
+			for k := len(c.shapes[i].in) - 1; k >= 0; k-- {
+				lengths = append(lengths, strconv.Itoa(c.shapes[i].in[k]))
+			}
+			text += fmt.Sprintf("stack_shape_%d: .quad %s\n", i, strings.Join(lengths, ", "))
+			continue
+		}
+		if opr.Type == instructions.Vector {
+			text += fmt.Sprintf("vector_%d: .double %s\n", i, strings.Join(strings.Fields(opr.Value), ", "))
+			continue
+		}
+		if s, ok := c.shapes[i]; ok && s.out > 0 {
+			text += fmt.Sprintf("vector_%d: .fill %d, 8, 0\n", i, s.out)
+		}
+	}
+	return text
+}
+
+// genVectorHelpers generates the subroutines which are used to print our
+// vectors, and to call the C library upon their elements.
+func (c *Compiler) genVectorHelpers() string {
+	label := `
+        # empty labels aren't printed
+        cmp byte ptr [rsi], 0
+        je print_vector_open
+        lea rdi,label_fmt
+        xor rax, rax
+        call printf
+print_vector_open:
+`
+	if c.json {
+		label = `
+        lea rdi,label_fmt
+        xor rax, rax
+        call printf
+`
+	}
+
+	return `
+#
+# Print the vector pointed to by rax, which has rcx elements, preceded by
+# the label pointed to by rsi.
+#
+print_vector:
+        push rbp
+        mov rbp, rsp
+        push rbx
+        push r12
+        and rsp, -16
+        mov rbx, rax
+        mov r12, rcx
+` + label + `        mov rax, rbx
+        mov rcx, r12
+        call print_elements
+        lea rdi,line_end
+        xor rax, rax
+        call printf
+        lea rsp, [rbp - 16]
+        pop r12
+        pop rbx
+        pop rbp
+        ret
+
+#
+# Print the rcx elements of the vector pointed to by rax, within brackets.
+#
+print_elements:
+        push rbp
+        mov rbp, rsp
+        push rbx
+        push r12
+        and rsp, -16
+        mov rbx, rax
+        mov r12, rcx
+        lea rdi,vector_open
+        xor rax, rax
+        call printf
+print_elements_next:
+        fld qword ptr [rbx]
+        call print_number
+        add rbx, 8
+        dec r12
+        jz print_elements_close
+        lea rdi,vector_sep
+        xor rax, rax
+        call printf
+        jmp print_elements_next
+print_elements_close:
+        lea rdi,vector_close
+        xor rax, rax
+        call printf
+        lea rsp, [rbp - 16]
+        pop r12
+        pop rbx
+        pop rbp
+        ret
+
+#
+# Print every value upon the stack, as print_stack does, when some of them
+# are vectors.  rsi points to the length of each entry, starting with the
+# topmost, which is zero for a number.
+#
+print_vector_stack:
+        push rbp
+        mov rbp, rsp
+        push rbx
+        push r12
+        and rsp, -16
+        mov r12, rsi
+
+        # show the depth
+        lea rdi,stack_depth
+        mov rsi, qword ptr [depth]
+        xor rax, rax
+        call printf
+
+        # then each entry, preceded by a separator.
+        mov rbx, qword ptr [depth]
+        lea rdi,stack_first
+print_vector_stack_next:
+        cmp rbx, 0
+        je print_vector_stack_done
+        dec rbx
+        xor rax, rax
+        call printf
+        mov rcx, qword ptr [r12 + rbx*8]
+        cmp rcx, 0
+        jne print_vector_stack_vector
+        fld qword ptr [rbp + 16 + rbx*8]
+        call print_number
+        jmp print_vector_stack_entry
+print_vector_stack_vector:
+        mov rax, qword ptr [rbp + 16 + rbx*8]
+        call print_elements
+print_vector_stack_entry:
+        lea rdi,stack_entry
+        jmp print_vector_stack_next
+
+print_vector_stack_done:
+        lea rdi,stack_end
+        xor rax, rax
+        call printf
+        lea rsp, [rbp - 16]
+        pop r12
+        pop rbx
+        pop rbp
+        ret
+
+#
+# Call the C library function whose address is in rax, for each of the
+# rcx elements of the vector at rdi.  Its arguments are read from rsi,
+# and rdx, which are advanced by r8, and r9, after each element.
+#
+vector_map:
+        push rbp
+        mov rbp, rsp
+        push rbx
+        push r12
+        push r13
+        push r14
+        push r15
+        push r8
+        push r9
+        and rsp, -16
+        mov rbx, rdi
+        mov r12, rsi
+        mov r13, rdx
+        mov r14, rcx
+        mov r15, rax
+vector_map_next:
+        movsd xmm0, qword ptr [r12]
+        movsd xmm1, qword ptr [r13]
+        call r15
+        movsd qword ptr [rbx], xmm0
+        add rbx, 8
+        add r12, qword ptr [rbp - 48]
+        add r13, qword ptr [rbp - 56]
+        dec r14
+        jnz vector_map_next
+        lea rsp, [rbp - 40]
+        pop r15
+        pop r14
+        pop r13
+        pop r12
+        pop rbx
+        pop rbp
+        ret
+`
+}
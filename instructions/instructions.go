@@ -15,6 +15,10 @@ const (
 	// Push is used to generate code to push a number onto the stack.
 	Push InstructionType = 'p'
 
+	// Input is used to generate code to push a runtime input, read
+	// from the command-line, onto the stack.
+	Input InstructionType = 'i'
+
 	// Plus means to pop two items from the stack and push the result
 	// of adding them.
 	Plus InstructionType = '+'
@@ -36,12 +40,26 @@ const (
 	Power InstructionType = '^'
 
 	// Modulus means to pop two items from the stack and push the result
-	// of running a modulus operation.
+	// of running a modulus operation, as C's fmod.
 	Modulus InstructionType = '%'
 
+	// FlooredModulus means to pop two items from the stack and push the
+	// result of running a floored modulus operation, as Python's %.
+	FlooredModulus InstructionType = 'm'
+
 	// Factorial allows calculating the factorials.
 	Factorial InstructionType = '!'
 
+	// FusedMultiplyAdd means to pop three items from the stack and push
+	// the result of adding the product of the top two to the third,
+	// with a single rounding.
+	FusedMultiplyAdd InstructionType = 'F'
+
+	// FusedMultiplySubtract means to pop three items from the stack and
+	// push the result of subtracting the product of the top two from
+	// the third, with a single rounding.
+	FusedMultiplySubtract InstructionType = 'f'
+
 	// Abs is used to pop a value from the stack and push the absolute
 	// value back.
 	Abs InstructionType = 'a'
@@ -62,20 +80,130 @@ const (
 	// of calculating its square-root back.
 	Sqrt InstructionType = 'q'
 
+	// Exp is used to pop a value from the stack and push the result
+	// of raising e to its power back.
+	Exp InstructionType = 'E'
+
+	// Ln is used to pop a value from the stack and push its natural
+	// logarithm back.
+	Ln InstructionType = 'l'
+
+	// Real pops a complex number from the stack, and pushes its real
+	// part.
+	Real InstructionType = 'e'
+
+	// Imaginary pops a complex number from the stack, and pushes its
+	// imaginary part.
+	Imaginary InstructionType = 'I'
+
+	// Conj pops a complex number from the stack, and pushes its
+	// conjugate.
+	Conj InstructionType = 'C'
+
+	// Arg pops a complex number from the stack, and pushes its
+	// argument, which is the angle it makes with the real axis.
+	Arg InstructionType = 'A'
+
+	// Polar pops a magnitude and an angle from the stack, and pushes
+	// the complex number they describe.
+	Polar InstructionType = 'o'
+
+	// And pops two integers from the stack and pushes their bitwise and.
+	And InstructionType = '&'
+
+	// Or pops two integers from the stack and pushes their bitwise or.
+	Or InstructionType = '|'
+
+	// Xor pops two integers from the stack and pushes their bitwise
+	// exclusive-or.
+	Xor InstructionType = 'x'
+
+	// Not pops an integer from the stack and pushes its bitwise
+	// complement.
+	Not InstructionType = '~'
+
+	// ShiftLeft pops two integers from the stack and pushes the result
+	// of shifting one left by the other.
+	ShiftLeft InstructionType = '<'
+
+	// ShiftRight pops two integers from the stack and pushes the result
+	// of shifting one right, logically, by the other.
+	ShiftRight InstructionType = '>'
+
+	// PopCount pops an integer from the stack and pushes the number of
+	// bits which are set in it.
+	PopCount InstructionType = '#'
+
+	// Rand pushes a random number, uniformly distributed in [0,1).
+	Rand InstructionType = 'r'
+
+	// RandInt pops two items from the stack, and pushes a random integer
+	// which lies between them, inclusively.
+	RandInt InstructionType = 'R'
+
+	// RandNormal pushes a random number, from the standard normal
+	// distribution.
+	RandNormal InstructionType = 'n'
+
+	// Variable pushes the variable of the quotation we're within, which
+	// is the value that it is being called with.
+	Variable InstructionType = 'v'
+
+	// Integrate pops two items from the stack, and pushes the integral
+	// of a quotation between them.
+	Integrate InstructionType = 'g'
+
+	// Solve pops two items from the stack, and pushes a root of a
+	// quotation which lies between them.
+	Solve InstructionType = 'z'
+
+	// Vector pushes a vector of numbers, which is a single stack-item.
+	Vector InstructionType = 'V'
+
+	// Dot pops two vectors from the stack, and pushes their dot product.
+	Dot InstructionType = 'd'
+
+	// Cross pops two vectors of three elements from the stack, and
+	// pushes their cross product.
+	Cross InstructionType = 'X'
+
+	// Norm pops a vector from the stack, and pushes its length in space.
+	Norm InstructionType = 'N'
+
+	// Sum pops a vector from the stack, and pushes the sum of its elements.
+	Sum InstructionType = 'u'
+
+	// Length pops a vector from the stack, and pushes the number of its
+	// elements.
+	Length InstructionType = 'h'
+
 	// Swap swaps the position of the top two stack-items.
 	Swap InstructionType = 'S'
 
 	// Dup duplicates the stacks topmost value.
 	Dup InstructionType = 'D'
+
+	// Print pops a value from the stack, and prints it.
+	Print InstructionType = '.'
+
+	// PrintStack prints every value upon the stack, without removing them.
+	PrintStack InstructionType = 'P'
+
+	// Label pops a value from the stack, and prints it with a label.
+	Label InstructionType = 'L'
 )
 
 // Instruction holds a single thing that the compiler must generate code for.
-// (The value is only used when a float is to be pushed upon the stack.)
+// (The value is only used when a float, or an input, is to be pushed upon
+// the stack.)
 type Instruction struct {
 
 	// Type holds the type of instruction this object represents
 	Type InstructionType
 
-	// Value holds the value of a number to be pushed upon the RPN stack.
+	// Value holds the value of a number to be pushed upon the RPN stack,
+	// the position of the runtime input to be pushed, the text of a
+	// label, the number of the quotation to be integrated or solved, or
+	// the elements of a vector - separated by spaces.
 	Value string
 }
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/skx/math-compiler/token"
+	"github.com/skx/math-compiler/units"
 )
 
 // Lexer holds our object-state.
@@ -14,15 +15,25 @@ type Lexer struct {
 	readPosition int    //next character position
 	ch           rune   //current character
 	characters   []rune //rune slice of input string
+
+	// variables holds the names of any runtime inputs which have
+	// been declared, these are returned as token.IDENT.
+	variables map[string]bool
 }
 
 // New a Lexer instance from string input.
 func New(input string) *Lexer {
-	l := &Lexer{characters: []rune(input)}
+	l := &Lexer{characters: []rune(input), variables: make(map[string]bool)}
 	l.readChar()
 	return l
 }
 
+// Declare registers the name of a runtime input, so that it will
+// be recognized as an identifier rather than an unknown token.
+func (l *Lexer) Declare(name string) {
+	l.variables[name] = true
+}
+
 // read one forward character
 func (l *Lexer) readChar() {
 	if l.readPosition >= len(l.characters) {
@@ -61,13 +72,41 @@ func (l *Lexer) NextToken() token.Token {
 			// ensure the sign is not lost.
 			tok.Literal = "-" + tok.Literal
 
+			// We've already read the character which follows us,
+			// which might be a bracket.
+			return tok
+
 		} else {
 			tok = newToken(token.MINUS, l.ch)
 		}
+	case rune('$'):
+		// "$1", "$2", etc, refer to positional runtime inputs.
+		if isDigit(l.peekChar()) {
+
+			// swallow the $
+			l.readChar()
+
+			return token.Token{Type: token.IDENT, Literal: "$" + l.readNumber()}
+		}
+		tok.Type = token.ERROR
+		tok.Literal = "Unknown token $"
+	case rune('"'):
+		str, ok := l.readString()
+		if ok {
+			tok.Type = token.STRING
+			tok.Literal = str
+		} else {
+			tok.Type = token.ERROR
+			tok.Literal = "Unterminated string"
+		}
 	case rune('/'):
 		tok = newToken(token.SLASH, l.ch)
 	case rune('*'):
 		tok = newToken(token.ASTERISK, l.ch)
+	case rune('['):
+		tok = newToken(token.LBRACKET, l.ch)
+	case rune(']'):
+		tok = newToken(token.RBRACKET, l.ch)
 	case rune(0):
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -78,11 +117,21 @@ func (l *Lexer) NextToken() token.Token {
 
 		lit := l.readIdentifier()
 		tok.Type = token.LookupIdentifier(lit)
+		if tok.Type == token.ERROR && l.variables[lit] {
+			tok.Type = token.IDENT
+		}
+		if tok.Type == token.ERROR && units.Valid(lit) {
+			tok.Type = token.UNIT
+		}
 		if tok.Type == token.ERROR {
 			tok.Literal = "Unknown token " + lit
 		} else {
 			tok.Literal = lit
 		}
+
+		// We've already read the character which follows us, which
+		// might be a bracket.
+		return tok
 	}
 	l.readChar()
 	return tok
@@ -140,12 +189,51 @@ func (l *Lexer) readDecimal() token.Token {
 		// Read the fractional part.
 		//
 		fraction := l.readNumber()
-		return token.Token{Type: token.NUMBER, Literal: integer + "." + fraction}
+		integer += "." + fraction
+	}
+
+	//
+	//   e[sign][digits] -> Which is an exponent, as in "1e22".
+	//
+	if l.ch == rune('e') || l.ch == rune('E') {
+		next := l.peekChar()
+		if (next == rune('+') || next == rune('-')) && l.readPosition+1 < len(l.characters) {
+			next = l.characters[l.readPosition+1]
+		}
+		if isDigit(next) {
+			exponent := string(l.ch)
+			l.readChar()
+			if l.ch == rune('+') || l.ch == rune('-') {
+				exponent += string(l.ch)
+				l.readChar()
+			}
+			integer += exponent + l.readNumber()
+		}
 	}
 	return token.Token{Type: token.NUMBER, Literal: integer}
 
 }
 
+// readString reads a string, which is terminated by a double-quote.
+//
+// We return false if we hit the end of our input before the string
+// was terminated.
+func (l *Lexer) readString() (string, bool) {
+	str := ""
+
+	// skip the opening quote
+	l.readChar()
+
+	for l.ch != rune('"') {
+		if l.ch == rune(0) {
+			return str, false
+		}
+		str += string(l.ch)
+		l.readChar()
+	}
+	return str, true
+}
+
 // peek character
 func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.characters) {
@@ -173,7 +261,9 @@ func (l *Lexer) readIdentifier() string {
 	//
 	// Build up our identifier, handling only valid characters.
 	//
-	for isIdentifier(l.ch) {
+	// Digits are permitted after the first character, so that
+	// runtime inputs may be named "x1", "x2", etc.
+	for isIdentifier(l.ch) || (id != "" && isDigit(l.ch)) {
 		id += string(l.ch)
 		l.readChar()
 	}
@@ -182,6 +272,9 @@ func (l *Lexer) readIdentifier() string {
 }
 
 // determinate ch is identifier or not
+//
+// Brackets end an identifier, so that a quotation may be written as
+// "[x sin]".
 func isIdentifier(ch rune) bool {
-	return !isDigit(ch) && !isWhitespace(ch) && ch != rune(0)
+	return !isDigit(ch) && !isWhitespace(ch) && ch != rune(0) && ch != rune('[') && ch != rune(']')
 }
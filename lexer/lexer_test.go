@@ -59,6 +59,36 @@ func TestParseFloats(t *testing.T) {
 	}
 }
 
+// Trivial test of parsing numbers with exponents.
+func TestParseExponents(t *testing.T) {
+	input := `1e22 -1.5e-3 2E+4 2 e * 3e`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.NUMBER, "1e22"},
+		{token.NUMBER, "-1.5e-3"},
+		{token.NUMBER, "2E+4"},
+		{token.NUMBER, "2"},
+		{token.E, "e"},
+		{token.ASTERISK, "*"},
+		{token.NUMBER, "3"},
+		{token.E, "e"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
 // Trivial test of the parsing of operators.
 func TestParseOperators(t *testing.T) {
 	input := `+ - * / % ^ - !`
@@ -112,3 +142,162 @@ func TestParseBogus(t *testing.T) {
 		}
 	}
 }
+
+// Test parsing runtime inputs.
+func TestParseInputs(t *testing.T) {
+	input := `$1 $23 x x1 y $ 3`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.IDENT, "$1"},
+		{token.IDENT, "$23"},
+		{token.IDENT, "x"},
+		{token.IDENT, "x1"},
+		{token.ERROR, "Unknown token y"},
+		{token.ERROR, "Unknown token $"},
+		{token.NUMBER, "3"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	l.Declare("x")
+	l.Declare("x1")
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+// Test parsing the printing words, and strings.
+func TestParseOutput(t *testing.T) {
+	input := `. .s "area" .label "oops`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.PRINT, "."},
+		{token.PRINTSTACK, ".s"},
+		{token.STRING, "area"},
+		{token.LABEL, ".label"},
+		{token.ERROR, "Unterminated string"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+// Test parsing units, which may be shadowed by the names of inputs.
+func TestParseUnits(t *testing.T) {
+	input := `9.81 m/s^2 3 km t t furlong`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.NUMBER, "9.81"},
+		{token.UNIT, "m/s^2"},
+		{token.NUMBER, "3"},
+		{token.UNIT, "km"},
+		{token.IDENT, "t"},
+		{token.IDENT, "t"},
+		{token.ERROR, "Unknown token furlong"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	l.Declare("t")
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+// Test parsing quotations, whose brackets needn't be separated by spaces.
+func TestParseQuotations(t *testing.T) {
+	input := `[x sin] 0 pi integrate [ x 2 - ] 0 3 solve`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.LBRACKET, "["},
+		{token.IDENT, "x"},
+		{token.SIN, "sin"},
+		{token.RBRACKET, "]"},
+		{token.NUMBER, "0"},
+		{token.PI, "pi"},
+		{token.INTEGRATE, "integrate"},
+		{token.LBRACKET, "["},
+		{token.IDENT, "x"},
+		{token.NUMBER, "2"},
+		{token.MINUS, "-"},
+		{token.RBRACKET, "]"},
+		{token.NUMBER, "0"},
+		{token.NUMBER, "3"},
+		{token.SOLVE, "solve"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	l.Declare("x")
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+// Test parsing vectors, whose negative elements may touch their brackets.
+func TestParseVectors(t *testing.T) {
+	input := `[-1 2.5 -3] [4 5 6] dot`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.LBRACKET, "["},
+		{token.NUMBER, "-1"},
+		{token.NUMBER, "2.5"},
+		{token.NUMBER, "-3"},
+		{token.RBRACKET, "]"},
+		{token.LBRACKET, "["},
+		{token.NUMBER, "4"},
+		{token.NUMBER, "5"},
+		{token.NUMBER, "6"},
+		{token.RBRACKET, "]"},
+		{token.DOT, "dot"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
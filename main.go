@@ -8,10 +8,28 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
+	"syscall"
 
 	"github.com/skx/math-compiler/compiler"
+	"github.com/skx/math-compiler/plot"
 )
 
+// rangeList holds the ranges given to -range, which may be repeated,
+// or given as a comma-separated list.
+type rangeList []string
+
+// String returns our ranges as a comma-separated list.
+func (r *rangeList) String() string {
+	return strings.Join(*r, ",")
+}
+
+// Set appends the given range, or ranges, to our list.
+func (r *rangeList) Set(value string) error {
+	*r = append(*r, strings.Split(value, ",")...)
+	return nil
+}
+
 func main() {
 
 	//
@@ -21,20 +39,53 @@ func main() {
 	compile := flag.Bool("compile", false, "Compile the program, via invoking gcc.")
 	program := flag.String("filename", "a.out", "The program to write to.")
 	run := flag.Bool("run", false, "Run the binary, post-compile.")
+	inputs := flag.String("inputs", "", "A comma-separated list of the names of runtime inputs.")
+	stream := flag.Bool("stdin", false, "Generate a program which reads its inputs from STDIN, one record per line.")
+	printAll := flag.Bool("print-all", false, "Print every value remaining upon the stack at exit, rather than requiring exactly one.")
+	var ranges rangeList
+	flag.Var(&ranges, "range", "A range of values for a runtime input, such as \"x=0:10:0.5\".  A table of results is output, over every combination of the ranges given.")
+	csv := flag.Bool("csv", false, "Output the table of results as comma-separated values.")
+	plotSpan := flag.String("plot", "", "Plot the expression over a range of a runtime input, such as \"x=0:10\", in the terminal.")
+
+	// Output formatting
+	format := flag.String("format", "", "A printf-format to output numbers with, for example \"%.3f\".")
+	digits := flag.Int("digits", 0, "The number of significant digits to output.")
+	integers := flag.Bool("integers", false, "Output integral values exactly, without an exponent.")
+	bare := flag.Bool("bare", false, "Output results without the \"Result\" prefix.")
+	json := flag.Bool("json", false, "Output each value as a JSON object.")
+	hex := flag.Bool("hex", false, "Output numbers as hexadecimal floats.")
+	exitResult := flag.Bool("exit-result", false, "Use the integer part of the result as the exit-code of the generated program.")
+	seed := flag.Uint64("seed", 0, "Seed the random number generator, rather than seeding it from the clock.")
+
+	// Calculation
+	mode := flag.String("mode", "float", "The kind of values calculated with: float, int64, bignum, rational, complex, interval, or decimal:N - with N decimal places.")
+	rounding := flag.String("rounding", "half-even", "How the products, and quotients, of decimals are rounded: half-even, half-up, or truncate.")
+	unit := flag.String("unit", "", "The unit which values are output in, for example \"km/h\".  By default they're output in SI base units.")
+	grad := flag.String("grad", "", "A comma-separated list of the runtime inputs which results are differentiated by.")
+	derive := flag.String("derive", "", "Differentiate the expression by the named runtime input, outputting its derivative - or compiling it.")
+	infix := flag.Bool("infix", false, "Output the derivative in infix notation.")
+	fpErrors := flag.String("fp-errors", "trap", "How floating-point errors are handled: trap, ieee, or saturate.")
+	precision := flag.String("precision", "double", "The working precision: single, double, or extended.")
+	fpu := flag.String("fpu", "x87", "The instructions used for arithmetic: x87, or sse.")
+	fma := flag.Bool("mfma", false, "Fuse multiplications with the additions, or subtractions, which follow them.  This requires -fpu=sse.")
+	math := flag.String("math", "fast", "How functions such as sin are calculated: fast, by the FPU, or accurate, by the C library.")
 	flag.Parse()
 
 	//
-	// If we're running we're also compiling
+	// If we're running, or plotting, we're also compiling
 	//
-	if *run {
+	if *run || *plotSpan != "" {
 		*compile = true
 	}
 
 	//
-	// Ensure we have an expression as our single argument.
+	// Ensure we have an expression as our first argument.
+	//
+	// Any further arguments are only permitted if we're running the
+	// generated program, as they're passed to it as its inputs.
 	//
-	if len(flag.Args()) != 1 {
-		fmt.Printf("Usage: math-compiler 'expression'\n")
+	if len(flag.Args()) < 1 || (len(flag.Args()) > 1 && !*run && *plotSpan == "") {
+		fmt.Printf("Usage: math-compiler 'expression' [inputs..]\n")
 		os.Exit(1)
 	}
 
@@ -50,6 +101,137 @@ func main() {
 		comp.SetDebug(true)
 	}
 
+	//
+	// Have we been given the names of any runtime inputs?
+	//
+	if *inputs != "" {
+		comp.SetInputs(strings.Split(*inputs, ","))
+	}
+
+	//
+	// Are the inputs to be read from STDIN?
+	//
+	if *stream {
+		comp.SetStream(true)
+	}
+
+	//
+	// Should all remaining values be printed?
+	//
+	if *printAll {
+		comp.SetPrintAll(true)
+	}
+
+	//
+	// Are we printing a table of results over ranges of our inputs?
+	//
+	comp.SetRanges(ranges)
+	comp.SetCSV(*csv)
+
+	//
+	// A plot is drawn from a table of its samples, which we read.
+	//
+	if *plotSpan != "" {
+		if len(ranges) > 0 || *csv {
+			fmt.Printf("A plot can't be combined with -range, or -csv\n")
+			os.Exit(1)
+		}
+		spec, err := plot.Range(*plotSpan, plot.Width*2)
+		if err != nil {
+			fmt.Printf("Error plotting: %s\n", err.Error())
+			os.Exit(1)
+		}
+		comp.SetRanges([]string{spec})
+		comp.SetCSV(true)
+	}
+
+	//
+	// Setup the formatting of our output.
+	//
+	comp.SetFormat(*format)
+	comp.SetDigits(*digits)
+	comp.SetIntegers(*integers)
+	comp.SetBare(*bare || *plotSpan != "")
+	comp.SetJSON(*json)
+	comp.SetHex(*hex)
+	comp.SetExitResult(*exitResult)
+
+	//
+	// Was the random number generator seeded?
+	//
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			comp.SetSeed(*seed)
+		}
+	})
+
+	//
+	// Which unit are values output in?
+	//
+	comp.SetUnit(*unit)
+
+	//
+	// Which inputs are results differentiated by?
+	//
+	if *grad != "" {
+		comp.SetGrad(strings.Split(*grad, ","))
+	}
+
+	//
+	// Are we differentiating the expression?
+	//
+	comp.SetDerive(*derive)
+	comp.SetInfix(*infix)
+
+	//
+	// What kind of values are we calculating with?
+	//
+	comp.SetMode(*mode)
+
+	//
+	// How are decimals rounded?
+	//
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "rounding" {
+			comp.SetRounding(*rounding)
+		}
+	})
+
+	//
+	// How are floating-point errors handled?
+	//
+	comp.SetFPErrors(*fpErrors)
+
+	//
+	// What precision are we working at?
+	//
+	comp.SetPrecision(*precision)
+
+	//
+	// Which instructions are we using?
+	//
+	comp.SetFPU(*fpu)
+	comp.SetFMA(*fma)
+
+	//
+	// How are the transcendental functions calculated?
+	//
+	comp.SetMath(*math)
+
+	//
+	// If we're differentiating, rather than compiling, we output the
+	// derivative, which can itself be compiled.
+	//
+	if *derive != "" && !*compile {
+		expr, err := comp.Derivative()
+		if err != nil {
+			fmt.Printf("Error compiling: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("%s\n", expr)
+		return
+	}
+
 	//
 	// Compile
 	//
@@ -71,7 +253,7 @@ func main() {
 	//
 	// OK we're compiling the program, via gcc.
 	//
-	gcc := exec.Command("gcc", "-static", "-o", *program, "-x", "assembler", "-")
+	gcc := exec.Command("gcc", "-static", "-o", *program, "-x", "assembler", "-", "-lm")
 	gcc.Stdout = os.Stdout
 	gcc.Stderr = os.Stderr
 
@@ -92,14 +274,66 @@ func main() {
 		os.Exit(1)
 	}
 
+	//
+	// Plotting the results of the binary?
+	//
+	if *plotSpan != "" {
+		path := *program
+		if !strings.Contains(path, "/") {
+			path = "./" + path
+		}
+
+		//
+		// Rows which fail are marked in the chart, so only a program
+		// which didn't output any rows has failed.
+		//
+		var stdout, stderr bytes.Buffer
+		exe := exec.Command(path, flag.Args()[1:]...)
+		exe.Stdin = os.Stdin
+		exe.Stdout = &stdout
+		exe.Stderr = &stderr
+		exe.Run()
+
+		points, err := plot.Parse(stdout.String())
+		if err != nil || len(points) == 0 {
+			fmt.Printf("%s", stderr.String())
+			fmt.Printf("Error plotting %s: no results were output\n", *program)
+			os.Exit(1)
+		}
+		fmt.Printf("%s", plot.Render(points, plot.Width, plot.Height))
+		return
+	}
+
 	//
 	// Running the binary too?
 	//
 	if *run {
-		exe := exec.Command(*program)
+		//
+		// Ensure a bare filename is run from the current directory,
+		// rather than being searched for upon the $PATH.
+		//
+		path := *program
+		if !strings.Contains(path, "/") {
+			path = "./" + path
+		}
+		exe := exec.Command(path, flag.Args()[1:]...)
+		exe.Stdin = os.Stdin
 		exe.Stdout = os.Stdout
 		exe.Stderr = os.Stderr
 		err = exe.Run()
+
+		//
+		// The program reported any error itself, so we only need to
+		// pass on its exit-code - unless it was killed by a signal,
+		// which we report, and exit with as a shell would.
+		//
+		if exit, ok := err.(*exec.ExitError); ok {
+			if status, ok := exit.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				fmt.Printf("Error running %s: killed by signal: %s\n", *program, status.Signal())
+				os.Exit(128 + int(status.Signal()))
+			}
+			os.Exit(exit.ExitCode())
+		}
 		if err != nil {
 			fmt.Printf("Error launching %s: %s\n", *program, err)
 			os.Exit(1)
@@ -0,0 +1,252 @@
+// Package plot draws a chart of the results of an expression, sampled
+// over a range of one of its runtime inputs, for display in a terminal.
+//
+// The samples are calculated by the generated program, which prints them
+// as a table, and the chart is drawn with Unicode braille characters -
+// each of which holds a grid of two by four dots.
+package plot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Width and Height are the size of our charts, in characters.
+const (
+	Width  = 64
+	Height = 16
+)
+
+// Point is one sample of the expression we're plotting.
+type Point struct {
+	// X is the value of the input, and Y the result.
+	X float64
+	Y float64
+
+	// Failed is true if the result couldn't be calculated, or isn't
+	// finite.
+	Failed bool
+}
+
+// Range returns the range, such as "x=0:1:0.25", which samples the given
+// span, such as "x=0:1", at n evenly spaced points.
+func Range(span string, n int) (string, error) {
+	eq := strings.Index(span, "=")
+	if eq < 0 {
+		return "", fmt.Errorf("the span '%s' must have the form name=from:to", span)
+	}
+	bounds := strings.Split(span[eq+1:], ":")
+	if len(bounds) != 2 {
+		return "", fmt.Errorf("the span '%s' must have the form name=from:to", span)
+	}
+
+	from, err := strconv.ParseFloat(bounds[0], 64)
+	if err != nil {
+		return "", fmt.Errorf("'%s' isn't a number", bounds[0])
+	}
+	to, err := strconv.ParseFloat(bounds[1], 64)
+	if err != nil {
+		return "", fmt.Errorf("'%s' isn't a number", bounds[1])
+	}
+	if from == to || n < 2 {
+		return "", fmt.Errorf("the span '%s' is empty", span)
+	}
+
+	step := strconv.FormatFloat((to-from)/float64(n-1), 'g', -1, 64)
+	return fmt.Sprintf("%s=%s:%s:%s", span[:eq], bounds[0], bounds[1], step), nil
+}
+
+// Parse reads our points from a table, as printed by the generated
+// program, with one row per line.  The rows hold the value of the input
+// and the result, separated by a comma - without a heading.
+//
+// A result of "error" is a point which couldn't be calculated, and any
+// unit which follows a result is ignored.
+func Parse(table string) ([]Point, error) {
+	points := []Point{}
+	for _, line := range strings.Split(table, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 || len(strings.Fields(fields[1])) < 1 {
+			return nil, fmt.Errorf("the row '%s' isn't a value, and a result", line)
+		}
+
+		x, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("the row '%s' has an invalid value", line)
+		}
+		p := Point{X: x, Failed: true}
+
+		result := strings.Fields(fields[1])[0]
+		if result != "error" {
+			p.Y, err = strconv.ParseFloat(result, 64)
+			if err != nil {
+				return nil, fmt.Errorf("the row '%s' has an invalid result", line)
+			}
+			p.Failed = math.IsInf(p.Y, 0) || math.IsNaN(p.Y)
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// Render draws the given points as a chart, which is the given number of
+// characters wide and high, along with its axes.
+//
+// Each point is joined to the one before it, unless either failed, and
+// the points which failed are marked upon the x-axis with "×".
+func Render(points []Point, width, height int) string {
+
+	//
+	// Find the bounds of our chart.
+	//
+	xmin, xmax := math.Inf(1), math.Inf(-1)
+	ymin, ymax := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		xmin = math.Min(xmin, p.X)
+		xmax = math.Max(xmax, p.X)
+		if !p.Failed {
+			ymin = math.Min(ymin, p.Y)
+			ymax = math.Max(ymax, p.Y)
+		}
+	}
+	if xmin >= xmax {
+		xmin, xmax = xmin-1, xmin+1
+	}
+	if ymin > ymax {
+		ymin, ymax = -1, 1
+	}
+	if ymin == ymax {
+		ymin, ymax = ymin-1, ymax+1
+	}
+
+	//
+	// The dots of our chart, and the columns whose points failed.
+	//
+	cols, rows := width*2, height*4
+	dots := make([][]bool, rows)
+	for i := range dots {
+		dots[i] = make([]bool, cols)
+	}
+	failed := make([]bool, width)
+
+	col := func(x float64) int {
+		return int(math.Round((x - xmin) / (xmax - xmin) * float64(cols-1)))
+	}
+	row := func(y float64) int {
+		return int(math.Round((ymax - y) / (ymax - ymin) * float64(rows-1)))
+	}
+
+	for i, p := range points {
+		if p.Failed {
+			failed[col(p.X)/2] = true
+			continue
+		}
+		c, r := col(p.X), row(p.Y)
+		dots[r][c] = true
+		if i > 0 && !points[i-1].Failed {
+			join(dots, col(points[i-1].X), row(points[i-1].Y), c, r)
+		}
+	}
+
+	//
+	// The labels of our y-axis are at its ends, and its middle.
+	//
+	labels := map[int]string{
+		0:          label(ymax),
+		height / 2: label((ymin + ymax) / 2),
+		height - 1: label(ymin),
+	}
+	margin := 0
+	for _, l := range labels {
+		if len(l) > margin {
+			margin = len(l)
+		}
+	}
+
+	var out strings.Builder
+	for y := 0; y < height; y++ {
+		axis := "│"
+		if l, ok := labels[y]; ok {
+			axis = "┤"
+			out.WriteString(strings.Repeat(" ", margin-len(l)) + l + " ")
+		} else {
+			out.WriteString(strings.Repeat(" ", margin+1))
+		}
+		out.WriteString(axis)
+		for x := 0; x < width; x++ {
+			out.WriteRune(cell(dots, x*2, y*4))
+		}
+		out.WriteString("\n")
+	}
+
+	//
+	// The x-axis, with its markers, and then its labels.
+	//
+	out.WriteString(strings.Repeat(" ", margin+1) + "└")
+	for x := 0; x < width; x++ {
+		if failed[x] {
+			out.WriteString("×")
+		} else {
+			out.WriteString("─")
+		}
+	}
+	out.WriteString("\n")
+
+	first, last := label(xmin), label(xmax)
+	gap := width + 1 - len(first) - len(last)
+	if gap < 1 {
+		gap = 1
+	}
+	out.WriteString(strings.Repeat(" ", margin+1) + first + strings.Repeat(" ", gap) + last + "\n")
+	return out.String()
+}
+
+// join sets the dots along the line between two others, so that the
+// points of our chart are joined.
+func join(dots [][]bool, c0, r0, c1, r1 int) {
+	steps := abs(c1 - c0)
+	if abs(r1-r0) > steps {
+		steps = abs(r1 - r0)
+	}
+	for i := 1; i < steps; i++ {
+		c := c0 + int(math.Round(float64((c1-c0)*i)/float64(steps)))
+		r := r0 + int(math.Round(float64((r1-r0)*i)/float64(steps)))
+		dots[r][c] = true
+	}
+}
+
+// cell returns the braille character which holds the dots whose top-left
+// is at the given column, and row.
+func cell(dots [][]bool, col, row int) rune {
+
+	// The bit which represents each dot, by its row and column.
+	bits := [4][2]rune{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+
+	r := rune(0x2800)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 2; x++ {
+			if dots[row+y][col+x] {
+				r |= bits[y][x]
+			}
+		}
+	}
+	return r
+}
+
+// label returns the text used to label a value upon our axes.
+func label(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+// abs returns the absolute value of the given integer.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,91 @@
+package plot
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test sampling spans.
+func TestRange(t *testing.T) {
+
+	tests := []struct {
+		span   string
+		n      int
+		result string
+	}{
+		{"x=0:1", 5, "x=0:1:0.25"},
+		{"$1=-2:2", 3, "$1=-2:2:2"},
+		{"t=1:0", 3, "t=1:0:-0.5"},
+	}
+	for _, test := range tests {
+		out, err := Range(test.span, test.n)
+		if err != nil {
+			t.Errorf("Unexpected error sampling '%s': %s", test.span, err.Error())
+		}
+		if out != test.result {
+			t.Errorf("Sampling '%s' gave '%s', not '%s'", test.span, out, test.result)
+		}
+	}
+
+	for _, span := range []string{"x", "x=1", "x=0:1:2", "x=a:1", "x=0:b", "x=1:1"} {
+		_, err := Range(span, 10)
+		if err == nil {
+			t.Errorf("Expected an error sampling '%s', but got none", span)
+		}
+	}
+}
+
+// Test reading tables of points.
+func TestParse(t *testing.T) {
+
+	points, err := Parse("0,1\n0.5,error\n1,inf\n1.5,2 km\n\n")
+	if err != nil {
+		t.Errorf("Unexpected error parsing: %s", err.Error())
+	}
+	want := []Point{{0, 1, false}, {0.5, 0, true}, {1, 0, true}, {1.5, 2, false}}
+	if len(points) != len(want) {
+		t.Fatalf("Parsed %d points, not %d", len(points), len(want))
+	}
+	for i, p := range points {
+		if p.Failed != want[i].Failed || p.X != want[i].X || (!p.Failed && p.Y != want[i].Y) {
+			t.Errorf("Point %d was %v, not %v", i, p, want[i])
+		}
+	}
+
+	for _, table := range []string{"1", "1,", "a,1", "1,b", "1,2,3"} {
+		_, err := Parse(table)
+		if err == nil {
+			t.Errorf("Expected an error parsing '%s', but got none", table)
+		}
+	}
+}
+
+// Test drawing charts.
+func TestRender(t *testing.T) {
+
+	points := []Point{{0, 0, false}, {1, 1, false}, {2, 0, true}, {3, 3, false}}
+	out := Render(points, 8, 4)
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("The chart had %d lines, not 6:\n%s", len(lines), out)
+	}
+
+	// The highest point is at the top-right, and the lowest at the
+	// bottom-left - where it is joined to the next.  The labels are
+	// aligned with the widest, which is "1.5".
+	if !strings.HasPrefix(lines[0], "  3 ┤") || !strings.HasSuffix(lines[0], "⠈") {
+		t.Errorf("The top of the chart was wrong: %s", lines[0])
+	}
+	if !strings.HasPrefix(lines[3], "  0 ┤⡠⠊") {
+		t.Errorf("The bottom of the chart was wrong: %s", lines[3])
+	}
+
+	// The failed point is marked upon the x-axis.
+	if lines[4] != "    └─────×──" {
+		t.Errorf("The x-axis was wrong: %s", lines[4])
+	}
+	if lines[5] != "    0       3" {
+		t.Errorf("The labels of the x-axis were wrong: %s", lines[5])
+	}
+}
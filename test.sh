@@ -12,23 +12,28 @@
 # If the optional third argument is present, and non-empty, then we
 # return the full output from the execution. Otherwise just the last
 # token.
+#
+# Any fourth argument is passed as flags to the compiler.
 test_compile() {
     input="$1"
     result="$2"
     full="$3"
+    flags="$4"
 
     #
     # Do this the long way round so we have assembly file for
     # inspection if/when a test fails.
     #
     rm -f test.s test || true
-    go run main.go -- "${input}" > test.s
-    gcc -static -o ./test test.s
+    go run main.go ${flags} -- "${input}" > test.s
+    gcc -static -o ./test test.s -lm
 
     #
     # Run the test.
     #
-    out=`./test`
+    # Errors are reported upon STDERR, so we capture that too.
+    #
+    out=`./test 2>&1`
 
     #
     # If we're not doing a "full" match we only take the last token
@@ -47,12 +52,110 @@ test_compile() {
 
 }
 
+# Compile an expression which uses runtime inputs, and compare the output
+# of running it, with the given arguments, against a fixed value.
+#
+# The names of the inputs are passed via the -inputs flag.
+#
+# Any fifth argument is passed as flags to the compiler.
+test_inputs() {
+    input="$1"
+    names="$2"
+    args="$3"
+    result="$4"
+    flags="$5"
+
+    rm -f test.s test || true
+    go run main.go -inputs="${names}" ${flags} -- "${input}" > test.s
+    gcc -static -o ./test test.s -lm
+
+    # We deliberately don't quote the arguments, so they're split.
+    out=`./test ${args} 2>&1`
+
+    if [ "${result}" = "${out}" ]; then
+        echo "Expected output found for '$input' with '$args' [$result] "
+        rm test test.s
+    else
+        echo "Expected output of '$input' with '$args' is '$result' - got '${out}' instead"
+        exit 1
+    fi
+}
+
+# Compile an expression which reads records from STDIN, and compare the
+# output of feeding it the given records against a fixed value.
+test_stream() {
+    input="$1"
+    records="$2"
+    result="$3"
+
+    rm -f test.s test || true
+    go run main.go -stdin -- "${input}" > test.s
+    gcc -static -o ./test test.s -lm
+
+    # Errors are written to STDERR, which isn't buffered, so to avoid
+    # any confusion over ordering we only look at STDOUT.
+    out=`printf "${records}" | ./test 2>/dev/null | tr '\n' ' '`
+
+    if [ "${result}" = "${out}" ]; then
+        echo "Expected output found for '$input' with STDIN [$result] "
+        rm test test.s
+    else
+        echo "Expected output of '$input' with STDIN is '$result' - got '${out}' instead"
+        exit 1
+    fi
+}
+
+# Compile an expression and compare the exit-code of running it against
+# a fixed value.
+#
+# Any third argument is passed as flags to the compiler.
+test_status() {
+    input="$1"
+    result="$2"
+    flags="$3"
+
+    rm -f test.s test || true
+    go run main.go ${flags} -- "${input}" > test.s
+    gcc -static -o ./test test.s -lm
+
+    ./test >/dev/null 2>&1
+    out=$?
+
+    if [ "${result}" = "${out}" ]; then
+        echo "Expected exit-code found for '$input' [$result] "
+        rm test test.s
+    else
+        echo "Expected exit-code of '$input' is '$result' - got '${out}' instead"
+        exit 1
+    fi
+}
+
+# Differentiate an expression, by the runtime input x, and compare the
+# derivative against a fixed value.
+#
+# Any third argument is passed as flags to the compiler.
+test_derive() {
+    input="$1"
+    result="$2"
+    flags="$3"
+
+    out=`go run main.go -inputs=x,y -derive=x ${flags} -- "${input}" 2>/dev/null`
+
+    if [ "${result}" = "${out}" ]; then
+        echo "Expected derivative found for '$input' [$result] "
+    else
+        echo "Expected derivative of '$input' is '$result' - got '${out}' instead"
+        exit 1
+    fi
+}
+
 
 # Simple operations
 test_compile '1 2 3 4 + + +' 10
 test_compile '3 4 5 * *'     60
 test_compile '20 10 2 - -'   12
 test_compile '20 4 2 / / '   10
+test_compile '1e5 1e+5 +'    200000
 
 # Division by zero
 test_compile '3 4 /' '0.75'
@@ -81,6 +184,21 @@ test_compile '10 4 %' 2
 test_compile '11 4 %' 3
 test_compile '12 4 %' 0
 
+# modulus of real, and negative, numbers - with the sign of the dividend
+test_compile '5.5 2 %'  1.5
+test_compile '-7 3 %'    -1
+test_compile '7 -3 %'     1
+test_compile '5 0 %' 'Attempted division by zero.  Aborting' 'full'
+
+# floored modulus - with the sign of the divisor
+test_compile '7 3 mod'    1
+test_compile '-7 3 mod'   2
+test_compile '7 -3 mod'  -2
+test_compile '-7 -3 mod' -1
+test_compile '-6 3 mod'   0
+test_compile '5.5 -2 mod' -0.5
+test_compile '5 0 mod' 'Attempted division by zero.  Aborting' 'full'
+
 # powers of two - the manual-way
 test_compile '2 2 *' 4
 test_compile '2 2 2 * *' 8
@@ -102,7 +220,7 @@ test_compile "$inp" 1.67772e+07
 
 
 # powers of two - the simple way
-test_compile '2 0 ^'           0
+test_compile '2 0 ^'           1
 test_compile '2 1 ^'           2
 test_compile '2 2 ^'           4
 test_compile '2 3 ^'           8
@@ -113,7 +231,23 @@ test_compile '2 7 ^'         128
 test_compile '2 8 ^'         256
 test_compile '2 16 ^'      65536
 test_compile '2 30 ^' 1.07374e+09
-test_compile '2 300 ^' 'Overflow - value out of range.  Aborting' 'full'
+test_compile '2 300 ^' 2.03704e+90
+test_compile '2 1024 ^' 'Overflow - value out of range.  Aborting' 'full'
+
+# powers which aren't whole numbers, or are negative
+test_compile '0 0 ^'           1
+test_compile '0 2 ^'           0
+test_compile '2 0.5 ^'   1.41421
+test_compile '9 0.5 ^'         3
+test_compile '2 -1 ^'        0.5
+test_compile '10 -300 ^'  '1e-300' 'full' '-bare -digits=17'
+test_compile '4 -0.5 ^'      0.5
+test_compile '2.5 1.5 ^' 3.95285
+test_compile '-2 3 ^'         -8
+test_compile '-2 2 ^'          4
+test_compile '-1 2 40 ^ 1 + ^' -1
+test_compile '-8 0.5 ^' 'Domain error - invalid argument.  Aborting' 'full'
+test_compile '0 -1 ^' 'Attempted division by zero.  Aborting' 'full'
 
 # factorials
 test_compile '-3 !'             0
@@ -146,6 +280,11 @@ test_compile '1 sin' 0.841471
 test_compile '1 cos' 0.540302
 test_compile '1 tan' 1.55741
 
+# exponentials, and logarithms
+test_compile '1 exp' 2.71828
+test_compile '100 ln' 4.60517
+test_compile '2 ln exp' 2
+
 # swap
 test_compile '3 5 -' -2
 test_compile '3 5 swap -' 2
@@ -154,4 +293,265 @@ test_compile '3 5 swap -' 2
 test_compile '3 sqrt dup *' 3
 test_compile '3 dup ^' 27
 
+# printing
+test_compile '3 4 . 5 .'                 $'Result 4\nResult 5\nResult 3' 'full'
+test_compile '1 2 3 .s + + .s'           $'<3> 1 2 3\n<1> 6\nResult 6'   'full'
+test_compile '3 4 * "area" .label'       'area 12'                        'full'
+test_compile '2 3 "a" .label "b" .label' $'a 3\nb 2'                     'full'
+test_compile $'3 "a\tb" .label'          $'a\tb 3'                         'full'
+test_compile '1 2 3 4 +'                 $'Result 1\nResult 2\nResult 7' 'full' '-print-all'
+test_compile '1 2 3'                     $'Result 1\nResult 2\nResult 3' 'full' '-print-all'
+test_compile '1 2 3 4 +'                 'Too many entries remaining on the stack.  Aborting' 'full'
+test_compile '3 .s .'                    $'<1> 3\nResult 3'              'full' '-print-all'
+
+# output formatting
+test_compile '2 24 ^'        '16777216'                       'full' '-bare -integers'
+test_compile '1 3 /'         '0.33333333333333331'            'full' '-bare -digits=17'
+test_compile '3 2 /'         'Result 0x1.8p+0'                'full' '-hex'
+test_compile '2 3 /'         'Result 0.667_units'             'full' '-format=%.3f_units'
+test_compile '3 2 /'         '{"label": "Result", "value": 1.5}' 'full' '-json'
+test_compile '1 2 .s +'      $'{"depth": 2, "stack": [1, 2]}\n{"label": "Result", "value": 3}' 'full' '-json'
+test_compile '3 "" .label'   '3'                              'full'
+
+# random numbers, which are reproducible when seeded.
+test_compile 'rand'            '0.294047'             ''     '-seed=1'
+test_compile 'randn'           '0.461629'             ''     '-seed=1'
+test_compile '1 6 randint'     '2'                    ''     '-seed=1'
+test_compile '3 3 randint'     '3'                    ''
+test_compile '0 1e300 randint'      '2.94047e+299'     ''     '-seed=1'
+test_compile '-1e300 1e300 randint' '8.15156e+298'     ''     '-seed=2'
+test_compile '6 1 randint'     'Domain error - invalid argument.  Aborting' 'full'
+
+# exit-codes
+test_status '3 4 +'        0
+test_status '3 0 /'        2
+test_status '10 400 ^'     3
+test_status '3 +'          4
+test_status '3 3 3 +'      5
+test_status '-4 sqrt'      6
+test_status '6 7 *'        42 '-exit-result'
+test_status '300 3.5 -'    40 '-exit-result'
+
+# square-roots of negative numbers are a domain error
+test_compile '-9 sqrt' 'Domain error - invalid argument.  Aborting' 'full'
+
+# floating-point errors
+test_compile '1 -0 /'         'Attempted division by zero.  Aborting' 'full'
+test_compile '10 300 ^ dup *' 'Overflow - value out of range.  Aborting' 'full'
+test_compile '1 0 /'          'Result inf'           'full' '-fp-errors=ieee'
+test_compile '1 -0 /'         'Result -inf'          'full' '-fp-errors=ieee'
+test_compile '0 0 /'          'Result nan'           'full' '-fp-errors=ieee'
+test_compile '-1 sqrt'        'Result nan'           'full' '-fp-errors=ieee'
+test_compile '1 0 /'          'Result 1.79769e+308'  'full' '-fp-errors=saturate'
+test_compile '10 300 ^ dup *' 'Result 1.79769e+308'  'full' '-fp-errors=saturate'
+test_compile '-1 sqrt'        'Domain error - invalid argument.  Aborting' 'full' '-fp-errors=saturate'
+
+# working precision
+test_compile '1 3 /'    '0.333333343267440795898' 'full' '-bare -digits=21 -precision=single'
+test_compile '1 3 /'    '0.33333333333333331483'  'full' '-bare -digits=21 -precision=double'
+test_compile '1 3 /'    '0.333333333333333333342' 'full' '-bare -digits=21 -precision=extended'
+test_compile '10 39 ^'  'Overflow - value out of range.  Aborting' 'full' '-precision=single'
+test_compile '10 400 ^' 'Result 1e+400'           'full' '-precision=extended'
+
+# SSE, and fused multiply-add
+test_compile '1 3 /'          '0.33333333333333331'   'full' '-bare -digits=17 -fpu=sse'
+test_compile '2 0.5 ^'        'Result 1.41421'        'full' '-fpu=sse'
+test_compile '-1 0.1 10 * +'  '0'                     'full' '-bare -fpu=sse'
+test_compile '-1 0.1 10 * +'  '5.5511151231257827e-17' 'full' '-bare -digits=17 -fpu=sse -mfma'
+test_compile '1 0 /'          'Attempted division by zero.  Aborting' 'full' '-fpu=sse'
+test_compile '-8 0.5 ^'       'Domain error - invalid argument.  Aborting' 'full' '-fpu=sse'
+
+# accurate functions, from the C library
+test_compile '1e22 sin'       '-0.85220084976718879'  'full' '-bare -digits=17 -math=accurate'
+test_compile '1e22 cos'       '0.52321478539513899'   'full' '-bare -digits=17 -math=accurate'
+test_compile '1e22 sin'       '-0.8522008497671888'   'full' '-bare -digits=17 -math=accurate -precision=extended'
+test_compile '1e22 sin'       '-0.734081507'          'full' '-bare -digits=9 -math=accurate -precision=single'
+test_compile '1e22 tan'       '-1.6287782256068988'   'full' '-bare -digits=17 -math=accurate'
+test_compile '2 0.5 ^'        '1.4142135623730951'    'full' '-bare -digits=17 -math=accurate'
+test_compile '2 sqrt'         'Result 1.41421'        'full' '-math=accurate -precision=extended'
+test_compile '-8 0.5 ^'       'Domain error - invalid argument.  Aborting' 'full' '-math=accurate'
+
+# integers
+test_compile '9223372036854775807 1 -'  'Result 9223372036854775806' 'full' '-mode=int64'
+test_compile '9223372036854775807 1 +'  'Overflow - value out of range.  Aborting' 'full' '-mode=int64'
+test_compile '-7 2 /'         'Result -3'             'full' '-mode=int64'
+test_compile '-7 2 %'         'Result -1'             'full' '-mode=int64'
+test_compile '-7 2 mod'       'Result 1'              'full' '-mode=int64'
+test_compile '7 0 /'          'Attempted division by zero.  Aborting' 'full' '-mode=int64'
+test_compile '3 39 ^'         'Result 4052555153018976267' 'full' '-mode=int64'
+test_compile '3 40 ^'         'Overflow - value out of range.  Aborting' 'full' '-mode=int64'
+test_compile '2 -1 ^'         'Domain error - invalid argument.  Aborting' 'full' '-mode=int64'
+test_compile '20 !'           'Result 2432902008176640000' 'full' '-mode=int64'
+test_compile '12 10 and'      'Result 8'              'full' '-mode=int64'
+test_compile '12 10 or'       'Result 14'             'full' '-mode=int64'
+test_compile '12 10 xor'      'Result 6'              'full' '-mode=int64'
+test_compile '0 not'          'Result -1'             'full' '-mode=int64'
+test_compile '1 63 shl'       'Result -9223372036854775808' 'full' '-mode=int64'
+test_compile '-1 60 shr'      'Result 15'             'full' '-mode=int64'
+test_compile '1 64 shl'       'Result 0'              'full' '-mode=int64'
+test_compile '255 7 shl popcount' 'Result 8'          'full' '-mode=int64'
+test_compile '255 not'        'Result 0xffffffffffffff00' 'full' '-mode=int64 -hex'
+test_compile '255'            '{"label": "Result", "value": 255}' 'full' '-mode=int64 -json'
+test_compile '6 7 *'          '00042'                 'full' '-mode=int64 -bare -format=%05d'
+
+# big integers
+test_compile '2 64 ^'         'Result 18446744073709551616' 'full' '-mode=bignum'
+test_compile '30 !'           'Result 265252859812191058636308480000000' 'full' '-mode=bignum'
+test_compile '100 ! 98 ! /'   'Result 9900'           'full' '-mode=bignum'
+test_compile '2 100 ^ 3 %'    'Result 1'              'full' '-mode=bignum'
+test_compile '-7 2 mod'       'Result 1'              'full' '-mode=bignum'
+test_compile '1000000000 1 -' 'Result 999999999'      'full' '-mode=bignum'
+test_compile '1 10 30 ^ -'    'Result -999999999999999999999999999999' 'full' '-mode=bignum'
+test_compile '7 0 %'          'Attempted division by zero.  Aborting' 'full' '-mode=bignum'
+test_compile '2 -1 ^'         'Domain error - invalid argument.  Aborting' 'full' '-mode=bignum'
+
+# rationals
+test_compile '1 3 / 1 6 / +'  'Result 1/2'            'full' '-mode=rational'
+test_compile '0.1 0.2 +'      'Result 3/10'           'full' '-mode=rational'
+test_compile '2 3 / -5 ^'     'Result 243/32'         'full' '-mode=rational'
+test_compile '1 3 / 3 *'      'Result 1'              'full' '-mode=rational'
+test_compile '7 2 / 1 3 / %'  'Result 1/6'            'full' '-mode=rational'
+test_compile '-7 2 / 1 mod'   'Result 1/2'            'full' '-mode=rational'
+test_compile '2 3 /'          'Result 2/3 ≈ 0.6667'   'full' '-mode=rational -digits=4'
+test_compile '1 3 / 0 /'      'Attempted division by zero.  Aborting' 'full' '-mode=rational'
+test_compile '3037000500 dup *' 'Overflow - value out of range.  Aborting' 'full' '-mode=rational'
+test_compile '2 1 2 / ^'      'Domain error - invalid argument.  Aborting' 'full' '-mode=rational'
+
+# complex numbers
+test_compile '-1 sqrt'        'Result i'              'full' '-mode=complex'
+test_compile 'i 2 ^'          'Result -1'             'full' '-mode=complex'
+test_compile '3 4 i * + abs'  'Result 5'              'full' '-mode=complex'
+test_compile '1 i + 1 i - /'  'Result i'              'full' '-mode=complex'
+test_compile '3 4 i * + conj' 'Result 3-4i'           'full' '-mode=complex'
+test_compile 'i ln'           'Result 1.5708i'        'full' '-mode=complex'
+test_compile '-8 1 3 / ^'     'Result 1+1.73205i'     'full' '-mode=complex'
+test_compile '2 0 polar i *'  'Result 2i'             'full' '-mode=complex'
+test_compile '1 i 0 * /'      'Attempted division by zero.  Aborting' 'full' '-mode=complex'
+
+# decimals
+test_compile '0.1 0.2 +'      'Result 0.30'           'full' '-mode=decimal:2'
+test_compile '2 3 /'          'Result 0.67'           'full' '-mode=decimal:2'
+test_compile '0.05 0.5 *'     'Result 0.02'           'full' '-mode=decimal:2'
+test_compile '0.05 0.5 *'     'Result 0.03'           'full' '-mode=decimal:2 -rounding=half-up'
+test_compile '2 3 /'          'Result 0.66'           'full' '-mode=decimal:2 -rounding=truncate'
+test_compile '-7.25 2 mod'    'Result 0.75'           'full' '-mode=decimal:2'
+test_compile '2 -2 ^'         'Result 0.25'           'full' '-mode=decimal:2'
+test_compile '7 2 /'          'Result 4'              'full' '-mode=decimal:0'
+test_compile '1 3 /'          'Result 0.333333333333333333' 'full' '-mode=decimal:18'
+test_compile '1 0 /'          'Attempted division by zero.  Aborting' 'full' '-mode=decimal:2'
+test_compile '2 0.5 ^'        'Domain error - invalid argument.  Aborting' 'full' '-mode=decimal:2'
+test_compile '92233720368547758.07 0.01 +' 'Overflow - value out of range.  Aborting' 'full' '-mode=decimal:2'
+
+# intervals
+test_compile '0.1 0.2 +'      'Result [0.29999999999999993, 0.30000000000000005]' 'full' '-mode=interval'
+test_compile '1 3 /'          'Result [0.33333333333333331, 0.33333333333333338]' 'full' '-mode=interval'
+test_compile '-2 3 ^'         'Result [-8, -8]'       'full' '-mode=interval'
+test_compile '2 sqrt'         'Result [1.41, 1.42]'   'full' '-mode=interval -digits=3'
+test_compile '1 0 /'          'Attempted division by zero.  Aborting' 'full' '-mode=interval'
+test_compile '1 0 /'          'Result [-inf, inf]'    'full' '-mode=interval -fp-errors=ieee'
+test_compile '-1 sqrt'        'Domain error - invalid argument.  Aborting' 'full' '-mode=interval'
+
+# units
+test_compile '3 km 200 m +'   'Result 3200'           'full'
+test_compile '3 km 200 m +'   'Result 3.2 km'         'full' '-unit=km'
+test_compile '100 m 9.58 s /' 'Result 37.5783 km/h'   'full' '-unit=km/h'
+test_compile '2 kW 3 h *'     'Result 6 kWh'          'full' '-unit=kWh'
+test_compile '2 ha sqrt'      'Result 141.421 m'      'full' '-unit=m'
+test_compile '90 deg sin'     'Result 1'              'full'
+test_compile '1 mi'           '1.60934'               'full' '-unit=km -bare'
+test_compile '5 km'           '{"label": "Result", "value": 5, "unit": "km"}' 'full' '-unit=km -json'
+
+# integrals, and roots, of quotations
+test_compile '[ x sin x * ] 0 pi integrate'   'Result 3.14159'  'full'
+test_compile '[ x x * ] 0 1 integrate'        'Result 0.333333' 'full'
+test_compile '[ x exp ] 0 1 integrate'        'Result 1.71828'  'full'
+test_compile '[ [ x x * ] 0 x integrate ] 0 1 integrate' 'Result 0.0833333' 'full'
+test_compile '[ 1 x x * + sqrt 1 swap / ] 0 1e6 integrate' '14.50865773852' 'full' '-bare -digits=13'
+test_compile '[ x x * 2 - ] 0 3 solve'        'Result 1.4142135623730951' 'full' '-digits=17'
+test_compile '[x cos x -] 0 1 solve'          'Result 0.739085' 'full'
+test_compile '[ x x * 2 - ] 0 3 solve'        'Result 1.41421'  'full' '-precision=single'
+test_compile '[ x 2 - ] 5 6 solve'            "The function doesn't change sign over the interval.  Aborting" 'full'
+test_compile '[ 1 x / ] 0 1 integrate'        'Attempted division by zero.  Aborting' 'full'
+
+# vectors, which are calculated elementwise
+test_compile '[1 2 3] 2 *'                    'Result [2, 4, 6]' 'full'
+test_compile '10 [1 2 3 4 5] -'               'Result [9, 8, 7, 6, 5]' 'full'
+test_compile '[1 2 3] [4 5 6] +'              'Result [5, 7, 9]' 'full'
+test_compile '[-1 4 -9] abs sqrt'             'Result [1, 2, 3]' 'full'
+test_compile '2 [1 2 3] ^'                    'Result [2, 4, 8]' 'full'
+test_compile '[1 2 3] [4 5 6] dot'            '32'
+test_compile '[1 0 0] [0 1 0] cross'          'Result [0, 0, 1]' 'full'
+test_compile '[3 4] norm'                     '5'
+test_compile '[1 2 3 4 5] sum'                '15'
+test_compile '[1 2 3] len'                    '3'
+test_compile '[1 2] [1 0] /'                  'Attempted division by zero.  Aborting' 'full'
+test_compile '[1 2] dot'                      'Insufficient entries on the stack.  Aborting' 'full'
+test_compile '[1 2] [3 4] 5 *'                'Too many entries remaining on the stack.  Aborting' 'full'
+test_compile '[1 2] 3 .s *'                   $'<2> [1, 2] 3\nResult [3, 6]' 'full'
+
+# runtime inputs
+test_inputs '$1 $2 +'    ''    '3 4'     'Result 7'
+test_inputs '$2 $1 -'    ''    '3 4'     'Result 1'
+test_inputs 'x x *'      'x'   '1.5'     'Result 2.25'
+test_inputs 'x y / $1 +' 'x,y' '6 -3'    'Result 4'
+test_inputs 'x 2 *'      'x'   '1e3'     'Result 2000'
+test_inputs 'x 2 *'      'x'   ''        'Usage: ./test x'
+test_inputs 'x 2 *'      'x'   '1 2'     'Usage: ./test x'
+test_inputs 'x 2 *'      'x'   'steve'   "Invalid number 'steve'.  Aborting"
+test_inputs 'x 2 *'      'x'   '3x'      "Invalid number '3x'.  Aborting"
+test_inputs 'x 2 *'      'x'   '1e38'    'Result 2e+38' '-precision=single'
+test_inputs 'x 2 *'      'x'   '1e39'    'Overflow - value out of range.  Aborting' '-precision=single'
+test_inputs 'x 3 shl'    'x'   '5'       'Result 40'  '-mode=int64'
+test_inputs 'x 2 *'      'x'   '1.5'     "Invalid number '1.5'.  Aborting" '-mode=int64'
+test_inputs 'x 2 *'      'x'   '9223372036854775808' "Invalid number '9223372036854775808'.  Aborting" '-mode=int64'
+test_inputs 'x 2 *'      'x'   '9223372036854775808' 'Result 18446744073709551616' '-mode=bignum'
+test_inputs 'x 2 *'      'x'   '1e3'     "Invalid number '1e3'.  Aborting" '-mode=bignum'
+test_inputs 'x y +'      'x,y' '1/3 0.25' 'Result 7/12' '-mode=rational'
+test_inputs 'x 2 *'      'x'   '1/0'     "Invalid number '1/0'.  Aborting" '-mode=rational'
+test_inputs 'z z *'      'z'   '3+4i'    'Result -7+24i' '-mode=complex'
+test_inputs 'z 2 *'      'z'   '-i'      'Result -2i' '-mode=complex'
+test_inputs 'z 2 *'      'z'   '3+x'     "Invalid number '3+x'.  Aborting" '-mode=complex'
+test_inputs 'x 3 *'      'x'   '0.1'     'Result [0.29999999999999993, 0.30000000000000005]' '-mode=interval'
+test_inputs 'x 3 *'      'x'   '-0.12'   'Result -0.36' '-mode=decimal:2'
+test_inputs 'dist mi time min /' 'dist,time' '26.2 180' 'Result 14.0549 km/h' '-unit=km/h'
+test_inputs 'x 3 *'      'x'   '0.125'   "Invalid number '0.125'.  Aborting" '-mode=decimal:2'
+test_inputs 'x y * x +'  'x,y' '3 4'     'Result 15 (d/dx 5, d/dy 3)' '-grad=x,y'
+test_inputs 'x sin x /'  'x'   '2'       'Result 0.454649 (d/dx -0.435398)' '-grad=x'
+test_inputs 'x 3 ^ x ln -' 'x' '2'     'Result 7.30685 (d/dx 11.5)' '-grad=x'
+test_inputs 'x sqrt'     'x'   '0'       'Overflow - value out of range.  Aborting' '-grad=x'
+test_inputs 'x sqrt'     'x'   '0'       'Result 0 (d/dx inf)' '-grad=x -fp-errors=ieee'
+test_inputs '-2 x ^'     'x'   '3'       'Domain error - invalid argument.  Aborting' '-grad=x'
+test_inputs 'x 0 ^'      'x'   '0'       'Result 1 (d/dx 0)' '-grad=x'
+test_inputs 'x 2 ^ y +'  'x,y' '3 1'     '{"label": "Result", "value": {"value": 10, "gradient": {"x": 6}}}' '-grad=x -json'
+test_inputs '[ x x * ] 0 x integrate' 'x' '3' 'Result 9'
+test_inputs '[ x x * a - ] 0 a solve' 'a' '2' 'Result 1.41421'
+
+# tables, over ranges of inputs
+test_inputs 'x x *'      'x'   ''        $'x,result\n0,0\n0.5,0.25\n1,1' '-range=x=0:1:0.5 -csv'
+test_inputs 'x y ^'      'x,y' ''        $'x,y,result\n1,0,1\n1,1,1\n2,0,1\n2,1,2' '-range=x=1:2 -range=y=0:1 -csv'
+test_inputs 'x y *'      'x,y' '3'       $'0,0\n1,3\n2,6' '-range=x=0:2 -csv -bare'
+test_inputs 'n 2 shl'    'n'   ''        $'n,result\n1,4\n4,16\n7,28' '-range=n=1:9:3 -csv -mode=int64'
+test_inputs 'x y *'      'x,y' ''        'Usage: ./test y' '-range=x=0:2'
+test_inputs '1 x /'      'x'   ''        $'x,result\n-1,-1\n0,error\n1,1' '-range=x=-1:1 -csv'
+test_inputs '1 x - sqrt' 'x'   ''        $'x,result\n0,1\n1,0\n2,error\n3,error' '-range=x=0:3 -csv'
+
+# symbolic derivatives
+test_derive 'x 2 ^ 3 * x 4 * - 5 +' '6 x * 4 -'
+test_derive 'x 3 ^ x sin *'         '3 * x^2 * sin(x) + x^3 * cos(x)' '-infix'
+test_derive 'x y * x /'             '0'
+test_derive 'x 2 3 ^ ^'             '8 * x^7' '-infix'
+test_derive 'x ln y /'              '1 / x / y' '-infix'
+test_derive 'x cos y -'             '-sin(x)' '-infix'
+test_derive '2 x ^'                 '2 x ^ 2 ln *'
+test_derive 'x !'                   "Error compiling: '!' can't be differentiated"
+
+# records read from STDIN
+test_stream '$1 $2 +'  '1 2\n3 4\n'           'Result 3 Result 7 '
+test_stream '$1 $2 +'  '1,2\n3, 4\t5\r\n'    'Result 3 Result 7 '
+test_stream '$2 sqrt'  '1 9\n1 16\n'          'Result 3 Result 4 '
+test_stream '$1 $2 +'  '1 2\n3\n'             'Result 3 '
+test_stream '$1 $2 +'  '1 2\n\n \t\r\n3 4\n'   'Result 3 Result 7 '
+test_stream '$1 $2 +'  '1 steve\n'            ''
+test_stream '$1 $2 +'  ''                      ''
+
 exit 0
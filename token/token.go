@@ -16,7 +16,21 @@ const (
 	EOF    = "EOF"
 	ERROR  = "ERROR"
 	NUMBER = "NUMBER"
-	IDENT  = "IDENT"
+
+	// IDENT is a runtime input, either "$1" or a declared name.
+	IDENT = "IDENT"
+
+	// STRING is a quoted string, used to label output.
+	STRING = "STRING"
+
+	// UNIT is a unit of measure, such as "km/h", which the value
+	// before it is measured in.
+	UNIT = "UNIT"
+
+	// VECTOR is a vector of numbers, such as "[1 2 3]".  It isn't
+	// produced by the lexer, but from the brackets and numbers which
+	// it does produce.
+	VECTOR = "VECTOR"
 
 	// simple operations
 	PLUS     = "+"
@@ -28,34 +42,106 @@ const (
 	MOD       = "%"
 	POWER     = "^"
 	FACTORIAL = "!"
+	FLOORMOD  = "mod"
 
 	// misc
 	E  = "e"
+	I  = "i"
 	PI = "pi"
 
 	// complex operations
 	ABS  = "abs"
 	COS  = "cos"
+	EXP  = "exp"
+	LN   = "ln"
 	SIN  = "sin"
 	SQRT = "sqrt"
 	TAN  = "tan"
 
+	// operations upon complex numbers
+	ARG   = "arg"
+	CONJ  = "conj"
+	IM    = "im"
+	POLAR = "polar"
+	RE    = "re"
+
+	// bitwise operations, upon integers
+	AND      = "and"
+	NOT      = "not"
+	OR       = "or"
+	POPCOUNT = "popcount"
+	SHL      = "shl"
+	SHR      = "shr"
+	XOR      = "xor"
+
+	// random numbers
+	RAND    = "rand"
+	RANDINT = "randint"
+	RANDN   = "randn"
+
 	// stack operations
 	DUP  = "dup"
 	SWAP = "swap"
+
+	// quotations, and the operations which call them
+	LBRACKET  = "["
+	RBRACKET  = "]"
+	INTEGRATE = "integrate"
+	SOLVE     = "solve"
+
+	// operations upon vectors
+	CROSS = "cross"
+	DOT   = "dot"
+	LEN   = "len"
+	NORM  = "norm"
+	SUM   = "sum"
+
+	// output operations
+	LABEL      = ".label"
+	PRINT      = "."
+	PRINTSTACK = ".s"
 )
 
 // reversed keywords
 var keywords = map[string]Type{
-	"abs":  ABS,
-	"cos":  COS,
-	"dup":  DUP,
-	"e":    E,
-	"pi":   PI,
-	"sin":  SIN,
-	"sqrt": SQRT,
-	"swap": SWAP,
-	"tan":  TAN,
+	".":         PRINT,
+	".label":    LABEL,
+	".s":        PRINTSTACK,
+	"abs":       ABS,
+	"and":       AND,
+	"arg":       ARG,
+	"conj":      CONJ,
+	"cos":       COS,
+	"cross":     CROSS,
+	"dot":       DOT,
+	"dup":       DUP,
+	"e":         E,
+	"exp":       EXP,
+	"i":         I,
+	"im":        IM,
+	"integrate": INTEGRATE,
+	"len":       LEN,
+	"ln":        LN,
+	"mod":       FLOORMOD,
+	"norm":      NORM,
+	"not":       NOT,
+	"or":        OR,
+	"pi":        PI,
+	"polar":     POLAR,
+	"popcount":  POPCOUNT,
+	"rand":      RAND,
+	"randint":   RANDINT,
+	"randn":     RANDN,
+	"re":        RE,
+	"shl":       SHL,
+	"shr":       SHR,
+	"sin":       SIN,
+	"solve":     SOLVE,
+	"sqrt":      SQRT,
+	"sum":       SUM,
+	"swap":      SWAP,
+	"tan":       TAN,
+	"xor":       XOR,
 }
 
 // LookupIdentifier used to determinate whether identifier is keyword nor not
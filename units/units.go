@@ -0,0 +1,272 @@
+// Package units contains the units of measure which numbers may carry,
+// such as "m", "km/h", or "kg*m/s^2".
+//
+// Each unit is a multiple of a product of the SI base units, and the
+// compiler uses these to check that a program is dimensionally sound,
+// before converting every value to the SI base units.
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dimension holds the exponent of each of the SI base units, in the
+// order metre, kilogram, second, ampere, kelvin, mole, and candela.
+type Dimension [7]int
+
+// symbols holds the symbol of each of the SI base units, in the order
+// they're stored in a Dimension.
+var symbols = []string{"m", "kg", "s", "A", "K", "mol", "cd"}
+
+// Unit is a unit of measure, which is a multiple of the product of the
+// SI base units in its dimension.
+type Unit struct {
+	// Scale is the size of the unit, in SI base units.
+	Scale float64
+
+	// Dimension holds the SI base units which this is a multiple of.
+	Dimension Dimension
+}
+
+// known holds the units we recognize, by their symbols.
+//
+// Units such as degrees Celsius, which are offset from the SI base units
+// rather than being a multiple of them, aren't supported.
+var known = map[string]Unit{
+
+	// angles are dimensionless
+	"rad": {1, Dimension{}},
+	"deg": {3.14159265358979323846 / 180, Dimension{}},
+
+	// length
+	"m":   {1, Dimension{1, 0, 0, 0, 0, 0, 0}},
+	"km":  {1e3, Dimension{1, 0, 0, 0, 0, 0, 0}},
+	"cm":  {1e-2, Dimension{1, 0, 0, 0, 0, 0, 0}},
+	"mm":  {1e-3, Dimension{1, 0, 0, 0, 0, 0, 0}},
+	"um":  {1e-6, Dimension{1, 0, 0, 0, 0, 0, 0}},
+	"nm":  {1e-9, Dimension{1, 0, 0, 0, 0, 0, 0}},
+	"in":  {0.0254, Dimension{1, 0, 0, 0, 0, 0, 0}},
+	"ft":  {0.3048, Dimension{1, 0, 0, 0, 0, 0, 0}},
+	"yd":  {0.9144, Dimension{1, 0, 0, 0, 0, 0, 0}},
+	"mi":  {1609.344, Dimension{1, 0, 0, 0, 0, 0, 0}},
+	"nmi": {1852, Dimension{1, 0, 0, 0, 0, 0, 0}},
+
+	// mass
+	"kg": {1, Dimension{0, 1, 0, 0, 0, 0, 0}},
+	"g":  {1e-3, Dimension{0, 1, 0, 0, 0, 0, 0}},
+	"mg": {1e-6, Dimension{0, 1, 0, 0, 0, 0, 0}},
+	"t":  {1e3, Dimension{0, 1, 0, 0, 0, 0, 0}},
+	"lb": {0.45359237, Dimension{0, 1, 0, 0, 0, 0, 0}},
+	"oz": {0.028349523125, Dimension{0, 1, 0, 0, 0, 0, 0}},
+
+	// time
+	"s":    {1, Dimension{0, 0, 1, 0, 0, 0, 0}},
+	"ms":   {1e-3, Dimension{0, 0, 1, 0, 0, 0, 0}},
+	"us":   {1e-6, Dimension{0, 0, 1, 0, 0, 0, 0}},
+	"ns":   {1e-9, Dimension{0, 0, 1, 0, 0, 0, 0}},
+	"min":  {60, Dimension{0, 0, 1, 0, 0, 0, 0}},
+	"h":    {3600, Dimension{0, 0, 1, 0, 0, 0, 0}},
+	"day":  {86400, Dimension{0, 0, 1, 0, 0, 0, 0}},
+	"week": {604800, Dimension{0, 0, 1, 0, 0, 0, 0}},
+
+	// the remaining base units
+	"A":   {1, Dimension{0, 0, 0, 1, 0, 0, 0}},
+	"mA":  {1e-3, Dimension{0, 0, 0, 1, 0, 0, 0}},
+	"K":   {1, Dimension{0, 0, 0, 0, 1, 0, 0}},
+	"mol": {1, Dimension{0, 0, 0, 0, 0, 1, 0}},
+	"cd":  {1, Dimension{0, 0, 0, 0, 0, 0, 1}},
+
+	// area, and volume
+	"ha":   {1e4, Dimension{2, 0, 0, 0, 0, 0, 0}},
+	"acre": {4046.8564224, Dimension{2, 0, 0, 0, 0, 0, 0}},
+	"L":    {1e-3, Dimension{3, 0, 0, 0, 0, 0, 0}},
+	"mL":   {1e-6, Dimension{3, 0, 0, 0, 0, 0, 0}},
+	"gal":  {3.785411784e-3, Dimension{3, 0, 0, 0, 0, 0, 0}},
+
+	// speed
+	"mph": {0.44704, Dimension{1, 0, -1, 0, 0, 0, 0}},
+	"kn":  {1852.0 / 3600, Dimension{1, 0, -1, 0, 0, 0, 0}},
+
+	// frequency
+	"Hz":  {1, Dimension{0, 0, -1, 0, 0, 0, 0}},
+	"kHz": {1e3, Dimension{0, 0, -1, 0, 0, 0, 0}},
+	"MHz": {1e6, Dimension{0, 0, -1, 0, 0, 0, 0}},
+	"GHz": {1e9, Dimension{0, 0, -1, 0, 0, 0, 0}},
+
+	// force
+	"N":   {1, Dimension{1, 1, -2, 0, 0, 0, 0}},
+	"kN":  {1e3, Dimension{1, 1, -2, 0, 0, 0, 0}},
+	"lbf": {4.4482216152605, Dimension{1, 1, -2, 0, 0, 0, 0}},
+
+	// energy
+	"J":    {1, Dimension{2, 1, -2, 0, 0, 0, 0}},
+	"kJ":   {1e3, Dimension{2, 1, -2, 0, 0, 0, 0}},
+	"MJ":   {1e6, Dimension{2, 1, -2, 0, 0, 0, 0}},
+	"cal":  {4.184, Dimension{2, 1, -2, 0, 0, 0, 0}},
+	"kcal": {4184, Dimension{2, 1, -2, 0, 0, 0, 0}},
+	"Wh":   {3600, Dimension{2, 1, -2, 0, 0, 0, 0}},
+	"kWh":  {3.6e6, Dimension{2, 1, -2, 0, 0, 0, 0}},
+	"eV":   {1.602176634e-19, Dimension{2, 1, -2, 0, 0, 0, 0}},
+
+	// power
+	"W":  {1, Dimension{2, 1, -3, 0, 0, 0, 0}},
+	"kW": {1e3, Dimension{2, 1, -3, 0, 0, 0, 0}},
+	"MW": {1e6, Dimension{2, 1, -3, 0, 0, 0, 0}},
+	"hp": {745.69987158227022, Dimension{2, 1, -3, 0, 0, 0, 0}},
+
+	// pressure
+	"Pa":  {1, Dimension{-1, 1, -2, 0, 0, 0, 0}},
+	"kPa": {1e3, Dimension{-1, 1, -2, 0, 0, 0, 0}},
+	"MPa": {1e6, Dimension{-1, 1, -2, 0, 0, 0, 0}},
+	"bar": {1e5, Dimension{-1, 1, -2, 0, 0, 0, 0}},
+	"atm": {101325, Dimension{-1, 1, -2, 0, 0, 0, 0}},
+	"psi": {6894.757293168361, Dimension{-1, 1, -2, 0, 0, 0, 0}},
+
+	// electricity
+	"C":   {1, Dimension{0, 0, 1, 1, 0, 0, 0}},
+	"V":   {1, Dimension{2, 1, -3, -1, 0, 0, 0}},
+	"mV":  {1e-3, Dimension{2, 1, -3, -1, 0, 0, 0}},
+	"kV":  {1e3, Dimension{2, 1, -3, -1, 0, 0, 0}},
+	"ohm": {1, Dimension{2, 1, -3, -2, 0, 0, 0}},
+	"F":   {1, Dimension{-2, -1, 4, 2, 0, 0, 0}},
+}
+
+// Parse converts the given expression, such as "km/h" or "kg*m/s^2",
+// into a unit.
+//
+// An expression is a series of symbols, each of which may be raised to
+// a whole power, separated by "*" or "/".  Each "/" divides by the symbol
+// which follows it, so "m/s/s" is the same as "m/s^2".
+func Parse(expr string) (Unit, error) {
+	result := Unit{Scale: 1}
+	op := byte('*')
+	for {
+		term := expr
+		end := strings.IndexAny(expr, "*/")
+		if end >= 0 {
+			term = expr[:end]
+		}
+
+		u, err := parseTerm(term)
+		if err != nil {
+			return Unit{}, fmt.Errorf("unknown unit '%s'", term)
+		}
+		if op == '*' {
+			result = result.Mul(u)
+		} else {
+			result = result.Div(u)
+		}
+
+		if end < 0 {
+			return result, nil
+		}
+		op = expr[end]
+		expr = expr[end+1:]
+	}
+}
+
+// parseTerm converts a single symbol, optionally raised to a power such
+// as "s^2", into a unit.
+func parseTerm(term string) (Unit, error) {
+	power := 1
+	if i := strings.Index(term, "^"); i >= 0 {
+		n, err := strconv.Atoi(term[i+1:])
+		if err != nil || n == 0 {
+			return Unit{}, fmt.Errorf("invalid power '%s'", term[i+1:])
+		}
+		power = n
+		term = term[:i]
+	}
+
+	u, ok := known[term]
+	if !ok {
+		return Unit{}, fmt.Errorf("unknown symbol '%s'", term)
+	}
+	return u.Pow(power), nil
+}
+
+// Valid returns true if the given expression is a unit.
+func Valid(expr string) bool {
+	_, err := Parse(expr)
+	return err == nil
+}
+
+// Mul returns the product of two units.
+func (u Unit) Mul(other Unit) Unit {
+	return Unit{Scale: u.Scale * other.Scale, Dimension: u.Dimension.Mul(other.Dimension)}
+}
+
+// Div returns the quotient of two units.
+func (u Unit) Div(other Unit) Unit {
+	return Unit{Scale: u.Scale / other.Scale, Dimension: u.Dimension.Div(other.Dimension)}
+}
+
+// Pow returns the unit raised to a whole power, which may be negative.
+func (u Unit) Pow(power int) Unit {
+	result := Unit{Scale: 1}
+	for i := 0; i < power; i++ {
+		result = result.Mul(u)
+	}
+	for i := 0; i > power; i-- {
+		result = result.Div(u)
+	}
+	return result
+}
+
+// Mul returns the dimension of the product of two values, with the
+// given dimensions.
+func (d Dimension) Mul(other Dimension) Dimension {
+	for i := range d {
+		d[i] += other[i]
+	}
+	return d
+}
+
+// Div returns the dimension of the quotient of two values, with the
+// given dimensions.
+func (d Dimension) Div(other Dimension) Dimension {
+	for i := range d {
+		d[i] -= other[i]
+	}
+	return d
+}
+
+// String returns the dimension as a product of the SI base units, such
+// as "kg*m/s^2", which may itself be parsed.  A dimensionless value is
+// described as such.
+func (d Dimension) String() string {
+	over := []string{}
+	under := []string{}
+	for i, n := range d {
+		switch {
+		case n == 1:
+			over = append(over, symbols[i])
+		case n > 1:
+			over = append(over, fmt.Sprintf("%s^%d", symbols[i], n))
+		case n == -1:
+			under = append(under, symbols[i])
+		case n < -1:
+			under = append(under, fmt.Sprintf("%s^%d", symbols[i], -n))
+		}
+	}
+
+	if len(over) == 0 && len(under) == 0 {
+		return "dimensionless"
+	}
+
+	// Without a numerator each symbol has a negative power.
+	if len(over) == 0 {
+		for i, n := range d {
+			if n < 0 {
+				over = append(over, fmt.Sprintf("%s^%d", symbols[i], n))
+			}
+		}
+		return strings.Join(over, "*")
+	}
+	if len(under) == 0 {
+		return strings.Join(over, "*")
+	}
+	return strings.Join(over, "*") + "/" + strings.Join(under, "/")
+}
@@ -0,0 +1,88 @@
+package units
+
+import (
+	"math"
+	"testing"
+)
+
+// Test parsing units, and their combinations.
+func TestParse(t *testing.T) {
+
+	tests := []struct {
+		input     string
+		scale     float64
+		dimension string
+	}{
+		{"m", 1, "m"},
+		{"km", 1000, "m"},
+		{"km/h", 1000.0 / 3600, "m/s"},
+		{"m/s^2", 1, "m/s^2"},
+		{"m/s/s", 1, "m/s^2"},
+		{"kg*m/s^2", 1, "m*kg/s^2"},
+		{"N*m", 1, "m^2*kg/s^2"},
+		{"kWh", 3.6e6, "J"},
+		{"Hz", 1, "s^-1"},
+		{"s^-1", 1, "s^-1"},
+		{"deg", math.Pi / 180, "dimensionless"},
+		{"m^3/L", 1000, "dimensionless"},
+	}
+
+	for _, test := range tests {
+		u, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Unexpected error parsing '%s': %s", test.input, err.Error())
+			continue
+		}
+		if math.Abs(u.Scale-test.scale) > 1e-12*test.scale {
+			t.Errorf("The scale of '%s' was %g, not %g", test.input, u.Scale, test.scale)
+		}
+		want, _ := Parse(test.dimension)
+		if test.dimension != "dimensionless" && u.Dimension != want.Dimension {
+			t.Errorf("The dimension of '%s' was %s, not %s", test.input, u.Dimension, test.dimension)
+		}
+		if test.dimension == "dimensionless" && u.Dimension != (Dimension{}) {
+			t.Errorf("The dimension of '%s' was %s, not dimensionless", test.input, u.Dimension)
+		}
+	}
+}
+
+// Test parsing things which aren't units.
+func TestParseFailures(t *testing.T) {
+
+	for _, input := range []string{"", "foo", "m/", "*m", "m^", "m^x", "m^0", "km/foo", "m**s"} {
+		if Valid(input) {
+			t.Errorf("Expected '%s' to be invalid, but it wasn't", input)
+		}
+	}
+}
+
+// Test describing dimensions.
+func TestString(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"m", "m"},
+		{"km/h", "m/s"},
+		{"N", "m*kg/s^2"},
+		{"V", "m^2*kg/s^3/A"},
+		{"Hz", "s^-1"},
+		{"rad", "dimensionless"},
+	}
+
+	for _, test := range tests {
+		u, _ := Parse(test.input)
+		if u.Dimension.String() != test.expected {
+			t.Errorf("The dimension of '%s' was described as '%s', not '%s'", test.input, u.Dimension.String(), test.expected)
+		}
+
+		// The description is itself a unit.
+		if test.expected != "dimensionless" {
+			v, err := Parse(test.expected)
+			if err != nil || v.Dimension != u.Dimension {
+				t.Errorf("The description '%s' didn't parse to the same dimension", test.expected)
+			}
+		}
+	}
+}